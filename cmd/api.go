@@ -20,6 +20,7 @@ import (
 	"github.com/shaharia-lab/mcp-kit/internal/auth"
 	handlers "github.com/shaharia-lab/mcp-kit/internal/handler"
 	"github.com/shaharia-lab/mcp-kit/internal/observability"
+	"github.com/shaharia-lab/mcp-kit/internal/storage"
 	"github.com/spf13/cobra"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -38,7 +39,7 @@ type ToolInfo struct {
 type RouterDependencies struct {
 	MCPClient          *mcp.Client
 	Logger             *log.Logger
-	ChatHistoryStorage goai.ChatHistoryStorage
+	ChatHistoryStorage storage.ChatHistoryStorage
 	ToolsProvider      *goai.ToolsProvider
 }
 
@@ -90,10 +91,23 @@ func NewAPICmd() *cobra.Command {
 			srv := &http.Server{
 				Addr: fmt.Sprintf(":%d", container.Config.APIServerPort),
 				Handler: setupRouter(
-					container.MCPClient,
-					container.Logger,
-					container.ChatHistoryStorage,
-					container.ToolsProvider,
+					handlers.ChatDependencies{
+						MCPClient:        container.MCPClient,
+						Logger:           container.Logger,
+						HistoryStorage:   container.ChatHistoryStorage,
+						Searcher:         container.ChatHistoryStorage,
+						ToolsProvider:    container.ToolsProvider,
+						Summarizer:       container.Summarizer,
+						Streaming:        container.Config.Streaming,
+						Generations:      handlers.NewGenerationRegistry(container.Config.Streaming.ResumeWindow),
+						Jobs:             handlers.NewJobRunner(container.Config.Jobs, container.Logger),
+						Batches:          container.Config.Batches,
+						RateLimits:       handlers.NewProviderRateLimiter(container.Config.Batches.RateLimits),
+						Fallbacks:        container.Config.Fallbacks,
+						Retries:          container.Config.Retries,
+						StructuredOutput: container.Config.StructuredOutput,
+						SystemPrompts:    container.Config.SystemPrompts,
+					},
 					container.AuthMiddleware,
 					container.GoogleService,
 				),
@@ -157,15 +171,16 @@ func NewAPICmd() *cobra.Command {
 }
 
 func setupRouter(
-	mcpClient *mcp.Client,
-	logger *log.Logger,
-	chatHistoryStorage goai.ChatHistoryStorage,
-	toolsProvider *goai.ToolsProvider,
+	chatDeps handlers.ChatDependencies,
 	authMiddleware *auth.AuthMiddleware,
 	googleService *google.GoogleService,
 ) *chi.Mux {
 	r := chi.NewRouter()
 
+	logger := chatDeps.Logger
+	chatHistoryStorage := chatDeps.HistoryStorage
+	toolsProvider := chatDeps.ToolsProvider
+
 	// Tracing middleware remains the same
 	r.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -187,9 +202,9 @@ func setupRouter(
 
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link", "X-MKit-Chat-UUID"},
+		ExposedHeaders:   []string{"Link", "X-MKit-Chat-UUID", "X-MKit-Truncated-Messages"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
@@ -222,12 +237,12 @@ func setupRouter(
 		r.With(DeprecatedRouteMiddleware(DeprecationInfo{
 			SuccessorURL: "/api/v1/chats",
 			SunsetDate:   sunsetDate,
-		})).Post("/ask", handlers.HandleAsk(mcpClient, logger, chatHistoryStorage, toolsProvider))
+		})).Post("/ask", handlers.HandleAsk(chatDeps))
 
 		r.With(DeprecatedRouteMiddleware(DeprecationInfo{
 			SuccessorURL: "/api/v1/chats/stream",
 			SunsetDate:   sunsetDate,
-		})).Post("/ask-stream", handlers.HandleAskStream(mcpClient, logger, chatHistoryStorage, toolsProvider))
+		})).Post("/ask-stream", handlers.HandleAskStream(chatDeps))
 
 		r.With(
 			authMiddleware.EnsureValidToken,
@@ -253,6 +268,12 @@ func setupRouter(
 		r.Get("/", handlers.LLMProvidersHandler)
 	})
 
+	// Get the personas chats can be created with
+	r.Route("/api/v1/personas", func(r chi.Router) {
+		r.Use(authMiddleware.EnsureValidToken)
+		r.Get("/", handlers.PersonasHandler(chatDeps.SystemPrompts))
+	})
+
 	// Get the list of tools
 	r.Route("/api/v1/tools", func(r chi.Router) {
 		r.Use(authMiddleware.EnsureValidToken)
@@ -260,15 +281,45 @@ func setupRouter(
 	})
 
 	// Ask LLM a question, with or without streaming
-	// Also get the chat history
+	// Also get, rename, archive and delete the chat history and cancel running answers
 	r.Route("/api/v1/chats", func(r chi.Router) {
 		r.Use(authMiddleware.EnsureValidToken)
-		r.Post("/", handlers.HandleAsk(mcpClient, logger, chatHistoryStorage, toolsProvider))
-		r.Post("/stream", handlers.HandleAskStream(mcpClient, logger, chatHistoryStorage, toolsProvider))
+		r.Post("/", handlers.HandleAsk(chatDeps))
+		r.Post("/stream", handlers.HandleAskStream(chatDeps))
+		r.Post("/bulk-delete", handlers.BulkDeleteChatsHandler(logger, chatHistoryStorage))
+		r.Get("/search", handlers.SearchChatsHandler(logger, chatDeps.Searcher))
+		r.Post("/import", handlers.ImportChatHandler(logger, chatHistoryStorage, chatDeps.SystemPrompts))
+		r.Get("/{chatId}/stream", handlers.ResumeStreamHandler(logger, chatHistoryStorage, chatDeps.Generations, chatDeps.Streaming.HeartbeatInterval))
+		r.Post("/{chatId}/cancel", handlers.CancelGenerationHandler(logger, chatHistoryStorage, chatDeps.Generations))
+		r.Get("/{chatId}/branches", handlers.ListBranchesHandler(logger, chatHistoryStorage))
+		r.Put("/{chatId}/active-branch", handlers.SwitchBranchHandler(logger, chatHistoryStorage, chatDeps.Generations))
+		r.Get("/{chatId}/export", handlers.ExportChatHandler(logger, chatHistoryStorage))
+		r.Get("/{chatId}/attachments/{attachmentId}", handlers.GetAttachmentHandler(logger, chatHistoryStorage))
 		r.Get("/{chatId}", handlers.GetChatHandler(logger, chatHistoryStorage))
+		r.Patch("/{chatId}", handlers.UpdateChatHandler(logger, chatHistoryStorage))
+		r.Delete("/{chatId}", handlers.DeleteChatHandler(logger, chatHistoryStorage))
 		r.Get("/", handlers.ChatHistoryListsHandler(logger, chatHistoryStorage))
 	})
 
+	// Ask LLM a question in the background and get the answer later
+	r.Route("/api/v1/jobs", func(r chi.Router) {
+		r.Use(authMiddleware.EnsureValidToken)
+		r.Post("/", handlers.CreateJobHandler(chatDeps))
+		r.Get("/{jobId}", handlers.GetJobHandler(logger, chatDeps.Jobs))
+	})
+
+	// Answer a batch of questions with the same model and tools
+	r.Route("/api/v1/batches", func(r chi.Router) {
+		r.Use(authMiddleware.EnsureValidToken)
+		r.Post("/", handlers.BatchHandler(chatDeps))
+	})
+
+	// Ask several models the same question to compare their answers
+	r.Route("/api/v1/compare", func(r chi.Router) {
+		r.Use(authMiddleware.EnsureValidToken)
+		r.Post("/", handlers.CompareHandler(chatDeps))
+	})
+
 	// Authenticate with Google OAuth2 to access Google services like Gmail Tools
 	r.Route("/google-oauth2", func(r chi.Router) {
 		r.With(authMiddleware.EnsureValidToken).Get("/login", func(w http.ResponseWriter, r *http.Request) {
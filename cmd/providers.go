@@ -3,6 +3,7 @@ package cmd
 import (
 	"context"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/shaharia-lab/mcp-kit/internal/service/google"
@@ -13,6 +14,8 @@ import (
 	"github.com/shaharia-lab/mcp-kit/internal/auth"
 	"github.com/shaharia-lab/mcp-kit/internal/config"
 	"github.com/shaharia-lab/mcp-kit/internal/observability"
+	"github.com/shaharia-lab/mcp-kit/internal/service/llm"
+	"github.com/shaharia-lab/mcp-kit/internal/storage"
 	"github.com/sirupsen/logrus"
 )
 
@@ -21,7 +24,7 @@ type Container struct {
 	Logger                        *log.Logger
 	MCPClient                     *mcp.Client
 	ToolsProvider                 *goai.ToolsProvider
-	ChatHistoryStorage            goai.ChatHistoryStorage
+	ChatHistoryStorage            storage.ChatHistoryStorage
 	Config                        *config.Config
 	TracingService                *observability.TracingService
 	LoggerLogrus                  *logrus.Logger
@@ -31,6 +34,7 @@ type Container struct {
 	AuthMiddleware                *auth.AuthMiddleware
 	GoogleService                 *google.GoogleService
 	GoogleOAuthTokenSourceStorage google.GoogleOAuthTokenSourceStorage
+	Summarizer                    *llm.Summarizer
 }
 
 func ProvideLogger() *log.Logger {
@@ -107,8 +111,27 @@ func ProvideTracingService(cfg *config.Config, logger goaiObs.Logger) *observabi
 	return observability.NewTracingService(tracingConfig, logger)
 }
 
-func ProvideChatHistoryStorage() goai.ChatHistoryStorage {
-	return goai.NewInMemoryChatHistoryStorage()
+func ProvideChatHistoryStorage(ctx context.Context, cfg *config.Config, logger *log.Logger) (storage.ChatHistoryStorage, func(), error) {
+	switch strings.ToLower(cfg.ChatHistory.Driver) {
+	case "", "memory":
+		return storage.NewMemoryChatHistoryStorage(), func() {}, nil
+	default:
+		sqlStorage, err := storage.NewSQLChatHistoryStorage(ctx, cfg.ChatHistory)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		cleanup := func() {
+			if err := sqlStorage.Close(); err != nil {
+				logger.Printf("Error closing chat history storage: %v", err)
+			}
+		}
+		return sqlStorage, cleanup, nil
+	}
+}
+
+func ProvideSummarizer(cfg *config.Config) *llm.Summarizer {
+	return llm.NewSummarizer(cfg.ChatHistory.Summarization)
 }
 
 func ProvideMCPBaseServer(l goaiObs.Logger) (*mcp.BaseServer, error) {
@@ -129,7 +152,7 @@ func NewContainer(
 	logger *log.Logger,
 	mcpClient *mcp.Client,
 	toolsProvider *goai.ToolsProvider,
-	chatHistoryStorage goai.ChatHistoryStorage,
+	chatHistoryStorage storage.ChatHistoryStorage,
 	config *config.Config,
 	tracingService *observability.TracingService,
 	loggerLogrus *logrus.Logger,
@@ -138,6 +161,7 @@ func NewContainer(
 	authService *auth.AuthService,
 	googleService *google.GoogleService,
 	googleOAuthTokenSourceStorage google.GoogleOAuthTokenSourceStorage,
+	summarizer *llm.Summarizer,
 ) *Container {
 	return &Container{
 		Logger:                        logger,
@@ -153,5 +177,6 @@ func NewContainer(
 		AuthMiddleware:                auth.NewAuthMiddleware(authService, logrusLoggerImpl),
 		GoogleService:                 googleService,
 		GoogleOAuthTokenSourceStorage: googleOAuthTokenSourceStorage,
+		Summarizer:                    summarizer,
 	}
 }
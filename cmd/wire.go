@@ -25,5 +25,6 @@ func InitializeAPI(ctx context.Context, configFile string) (*Container, func(),
 		provideAuthenticator,
 		ProvideGoogleService,
 		ProvideGoogleOAuthTokenSourceStorage,
+		ProvideSummarizer,
 	))
 }
@@ -26,19 +26,26 @@ func InitializeAPI(ctx context.Context, configFile2 string) (*Container, func(),
 	if err != nil {
 		return nil, nil, err
 	}
-	chatHistoryStorage := ProvideChatHistoryStorage()
+	chatHistoryStorage, cleanup, err := ProvideChatHistoryStorage(ctx, config, logger)
+	if err != nil {
+		return nil, nil, err
+	}
 	tracingService := ProvideTracingService(config, observabilityLogger)
 	baseServer, err := ProvideMCPBaseServer(observabilityLogger)
 	if err != nil {
+		cleanup()
 		return nil, nil, err
 	}
 	authService, err := provideAuthenticator(ctx, config, observabilityLogger)
 	if err != nil {
+		cleanup()
 		return nil, nil, err
 	}
 	googleOAuthTokenSourceStorage := ProvideGoogleOAuthTokenSourceStorage(config)
 	googleService := ProvideGoogleService(config, googleOAuthTokenSourceStorage)
-	container := NewContainer(logger, client, toolsProvider, chatHistoryStorage, config, tracingService, logrusLogger, observabilityLogger, baseServer, authService, googleService, googleOAuthTokenSourceStorage)
+	summarizer := ProvideSummarizer(config)
+	container := NewContainer(logger, client, toolsProvider, chatHistoryStorage, config, tracingService, logrusLogger, observabilityLogger, baseServer, authService, googleService, googleOAuthTokenSourceStorage, summarizer)
 	return container, func() {
+		cleanup()
 	}, nil
 }
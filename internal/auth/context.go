@@ -0,0 +1,19 @@
+package auth
+
+import "context"
+
+type contextKey string
+
+const subjectContextKey contextKey = "auth.subject"
+
+// WithSubject returns a copy of ctx carrying the subject of a validated token
+func WithSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, subjectContextKey, subject)
+}
+
+// SubjectFromContext returns the subject of the validated token, or an empty
+// string when the request was not authenticated
+func SubjectFromContext(ctx context.Context) string {
+	subject, _ := ctx.Value(subjectContextKey).(string)
+	return subject
+}
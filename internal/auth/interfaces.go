@@ -14,7 +14,8 @@ type AuthProvider interface {
 
 // TokenValidator handles token validation
 type TokenValidator interface {
-	ValidateToken(ctx context.Context, token string) error
+	// ValidateToken validates the token and returns the subject it was issued to
+	ValidateToken(ctx context.Context, token string) (string, error)
 }
 
 // OAuth2Provider handles OAuth2 operations
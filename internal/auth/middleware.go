@@ -31,13 +31,14 @@ func (am *AuthMiddleware) EnsureValidToken(next http.Handler) http.Handler {
 			return
 		}
 
-		if err := am.validator.ValidateToken(r.Context(), token); err != nil {
+		subject, err := am.validator.ValidateToken(r.Context(), token)
+		if err != nil {
 			am.logger.Error("invalid token", err)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(WithSubject(r.Context(), subject)))
 	})
 }
 
@@ -48,13 +48,13 @@ func NewAuthService(ctx context.Context, cfg config.AuthConfig, logger observabi
 }
 
 // ValidateToken implements TokenValidator interface
-func (a *AuthService) ValidateToken(ctx context.Context, tokenString string) error {
+func (a *AuthService) ValidateToken(ctx context.Context, tokenString string) (string, error) {
 	ctx, cancel := context.WithTimeout(ctx, a.config.AuthTokenTTL)
 	defer cancel()
 
 	issuerURL, err := url.Parse("https://" + a.config.AuthDomain + "/")
 	if err != nil {
-		return fmt.Errorf("failed to parse issuer URL: %w", err)
+		return "", fmt.Errorf("failed to parse issuer URL: %w", err)
 	}
 
 	provider := jwks.NewCachingProvider(issuerURL, 5*time.Minute)
@@ -72,15 +72,20 @@ func (a *AuthService) ValidateToken(ctx context.Context, tokenString string) err
 		validator.WithAllowedClockSkew(time.Minute),
 	)
 	if err != nil {
-		return fmt.Errorf("failed to set up JWT validator: %w", err)
+		return "", fmt.Errorf("failed to set up JWT validator: %w", err)
 	}
 
-	_, err = jwtValidator.ValidateToken(ctx, tokenString)
+	validated, err := jwtValidator.ValidateToken(ctx, tokenString)
 	if err != nil {
-		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		return "", fmt.Errorf("%w: %v", ErrInvalidToken, err)
 	}
 
-	return nil
+	claims, ok := validated.(*validator.ValidatedClaims)
+	if !ok || claims.RegisteredClaims.Subject == "" {
+		return "", fmt.Errorf("%w: token has no subject", ErrInvalidToken)
+	}
+
+	return claims.RegisteredClaims.Subject, nil
 }
 
 // AuthCodeURL implements OAuth2Provider interface
@@ -10,14 +10,22 @@ import (
 )
 
 type Config struct {
-	APIServerPort       int                `mapstructure:"api_server_port"`
-	MCPServerURL        string             `mapstructure:"mcp_server_url"`
-	MCPServerPort       int                `mapstructure:"mcp_server_port"`
-	ToolsEnabled        []string           `mapstructure:"tools_enabled"`
-	Tracing             TracingConfig      `mapstructure:"tracing"`
-	Auth                AuthConfig         `mapstructure:"auth"`
-	GoogleServiceConfig GoogleConfig       `mapstructure:"google"`
-	Tools               *tools.ToolsConfig `yaml:"tools" validate:"required"`
+	APIServerPort       int                    `mapstructure:"api_server_port"`
+	MCPServerURL        string                 `mapstructure:"mcp_server_url"`
+	MCPServerPort       int                    `mapstructure:"mcp_server_port"`
+	ToolsEnabled        []string               `mapstructure:"tools_enabled"`
+	Tracing             TracingConfig          `mapstructure:"tracing"`
+	Auth                AuthConfig             `mapstructure:"auth"`
+	GoogleServiceConfig GoogleConfig           `mapstructure:"google"`
+	ChatHistory         ChatHistoryConfig      `mapstructure:"chat_history"`
+	Streaming           StreamingConfig        `mapstructure:"streaming"`
+	StructuredOutput    StructuredOutputConfig `mapstructure:"structured_output"`
+	SystemPrompts       SystemPromptConfig     `mapstructure:"system_prompts"`
+	Jobs                JobsConfig             `mapstructure:"jobs"`
+	Batches             BatchesConfig          `mapstructure:"batches"`
+	Fallbacks           FallbackConfig         `mapstructure:"fallbacks"`
+	Retries             RetryConfig            `mapstructure:"retries"`
+	Tools               *tools.ToolsConfig     `yaml:"tools" validate:"required"`
 }
 
 // TracingConfig holds the configuration for the tracing service
@@ -53,6 +61,127 @@ type GoogleConfig struct {
 	Enabled         bool     `mapstructure:"enabled"`
 }
 
+// ChatHistoryConfig holds the configuration for the chat history storage backend.
+// Driver is one of "memory", "sqlite" or "postgres". DSN is passed to the
+// database driver as is and is ignored by the in-memory storage.
+type ChatHistoryConfig struct {
+	Driver          string              `mapstructure:"driver"`
+	DSN             string              `mapstructure:"dsn"`
+	MaxOpenConns    int                 `mapstructure:"max_open_conns"`
+	MaxIdleConns    int                 `mapstructure:"max_idle_conns"`
+	ConnMaxLifetime time.Duration       `mapstructure:"conn_max_lifetime"`
+	Summarization   SummarizationConfig `mapstructure:"summarization"`
+}
+
+// SummarizationConfig configures the rolling summary of chat messages that no
+// longer fit into the model's context window. A cheaper model than the one
+// answering the questions is usually good enough.
+type SummarizationConfig struct {
+	Enabled   bool   `mapstructure:"enabled"`
+	Provider  string `mapstructure:"provider"`
+	ModelID   string `mapstructure:"model_id"`
+	MaxTokens int64  `mapstructure:"max_tokens"`
+}
+
+// StreamingConfig holds the configuration for streamed chat answers.
+// A zero HeartbeatInterval disables the heartbeats. MaxChunkSize and MaxDelay
+// cap the stream settings requested by clients, a zero MaxChunkSize allows any
+// chunk size. ResumeWindow is how long the events of a finished answer can
+// still be replayed to a client that reconnects.
+type StreamingConfig struct {
+	HeartbeatInterval time.Duration `mapstructure:"heartbeat_interval"`
+	MaxChunkSize      int           `mapstructure:"max_chunk_size"`
+	MaxDelay          time.Duration `mapstructure:"max_delay"`
+	ResumeWindow      time.Duration `mapstructure:"resume_window"`
+}
+
+// StructuredOutputConfig holds the configuration for answers requested as JSON.
+// MaxRetries is how many times the model is asked again for an answer that
+// doesn't match the requested schema.
+type StructuredOutputConfig struct {
+	MaxRetries int `mapstructure:"max_retries"`
+}
+
+// SystemPromptConfig limits the system prompts chats are created with in place
+// of the server's prompt templates. AllowOverrides false rejects both custom
+// prompts and personas, chats created with them before are answered with the
+// prompt templates. MaxLength caps custom prompts, in characters. Personas
+// are named prompts callers choose from.
+type SystemPromptConfig struct {
+	AllowOverrides bool                     `mapstructure:"allow_overrides"`
+	MaxLength      int                      `mapstructure:"max_length"`
+	Personas       map[string]PersonaConfig `mapstructure:"personas"`
+}
+
+// JobsConfig holds the configuration for questions answered asynchronously.
+// Workers bounds the answers generated at the same time, QueueSize the jobs
+// waiting for a worker. Finished jobs can be fetched for Retention. Webhooks are
+// only accepted when WebhooksEnabled is set.
+type JobsConfig struct {
+	Workers         int           `mapstructure:"workers"`
+	QueueSize       int           `mapstructure:"queue_size"`
+	Retention       time.Duration `mapstructure:"retention"`
+	WebhooksEnabled bool          `mapstructure:"webhooks_enabled"`
+	WebhookTimeout  time.Duration `mapstructure:"webhook_timeout"`
+}
+
+// BatchesConfig limits the batches of questions answered in one request.
+// MaxQuestions caps the size of a batch. A batch answers DefaultConcurrency of
+// its questions at the same time unless it asks for another number up to
+// MaxConcurrency. RateLimits caps the requests all batches together send to a
+// provider, keyed by the lower case provider name.
+type BatchesConfig struct {
+	MaxQuestions       int                        `mapstructure:"max_questions"`
+	DefaultConcurrency int                        `mapstructure:"default_concurrency"`
+	MaxConcurrency     int                        `mapstructure:"max_concurrency"`
+	RateLimits         map[string]RateLimitConfig `mapstructure:"rate_limits"`
+}
+
+// RateLimitConfig allows RequestsPerMinute on average with up to Burst at once
+type RateLimitConfig struct {
+	RequestsPerMinute int `mapstructure:"requests_per_minute"`
+	Burst             int `mapstructure:"burst"`
+}
+
+// FallbackConfig lists the models answering in place of a model that fails
+// with a retryable error before it answered anything. Every model but the last
+// one of a chain is given up when its first response takes longer than
+// AttemptTimeout, 0 lets each one take as long as the request. A synchronous
+// answer without tool calls is the first response, so the timeout has to allow
+// for generating it.
+type FallbackConfig struct {
+	AttemptTimeout time.Duration         `mapstructure:"attempt_timeout"`
+	Chains         []FallbackChainConfig `mapstructure:"chains"`
+}
+
+// FallbackChainConfig is tried in order for questions to Provider and ModelID
+type FallbackChainConfig struct {
+	Provider  string        `mapstructure:"provider"`
+	ModelID   string        `mapstructure:"model_id"`
+	Fallbacks []ModelConfig `mapstructure:"fallbacks"`
+}
+
+// RetryConfig is how provider calls failing with a rate limit or an overloaded
+// provider are retried. The delay doubles from InitialDelay with every attempt,
+// up to MaxDelay, and a longer Retry-After of the provider isn't waited for.
+type RetryConfig struct {
+	MaxAttempts  int           `mapstructure:"max_attempts"`
+	InitialDelay time.Duration `mapstructure:"initial_delay"`
+	MaxDelay     time.Duration `mapstructure:"max_delay"`
+}
+
+// ModelConfig names a model of a provider
+type ModelConfig struct {
+	Provider string `mapstructure:"provider"`
+	ModelID  string `mapstructure:"model_id"`
+}
+
+// PersonaConfig is a system prompt offered under a name
+type PersonaConfig struct {
+	Description string `mapstructure:"description"`
+	Prompt      string `mapstructure:"prompt"`
+}
+
 func Load(configFile string) (*Config, error) {
 	var cfg Config
 
@@ -119,4 +248,48 @@ func setDefaults() {
 	viper.SetDefault("google.state_cookie", "")
 	viper.SetDefault("google.token_source_file", "")
 	viper.SetDefault("google.enabled", false)
+
+	// Chat history config defaults
+	viper.SetDefault("chat_history.driver", "memory")
+	viper.SetDefault("chat_history.dsn", "")
+	viper.SetDefault("chat_history.max_open_conns", 10)
+	viper.SetDefault("chat_history.max_idle_conns", 5)
+	viper.SetDefault("chat_history.conn_max_lifetime", "30m")
+	viper.SetDefault("chat_history.summarization.enabled", false)
+	viper.SetDefault("chat_history.summarization.provider", "Anthropic")
+	viper.SetDefault("chat_history.summarization.model_id", "claude-3-5-haiku-latest")
+	viper.SetDefault("chat_history.summarization.max_tokens", 500)
+
+	// Streaming config defaults
+	viper.SetDefault("streaming.heartbeat_interval", "15s")
+	viper.SetDefault("streaming.max_chunk_size", 1000)
+	viper.SetDefault("streaming.max_delay", "500ms")
+	viper.SetDefault("streaming.resume_window", "5m")
+
+	// Structured output config defaults
+	viper.SetDefault("structured_output.max_retries", 2)
+
+	// System prompt config defaults
+	viper.SetDefault("system_prompts.allow_overrides", true)
+	viper.SetDefault("system_prompts.max_length", 4000)
+
+	// Jobs config defaults
+	viper.SetDefault("jobs.workers", 4)
+	viper.SetDefault("jobs.queue_size", 100)
+	viper.SetDefault("jobs.retention", "1h")
+	viper.SetDefault("jobs.webhooks_enabled", false)
+	viper.SetDefault("jobs.webhook_timeout", "10s")
+
+	// Batches config defaults
+	viper.SetDefault("batches.max_questions", 500)
+	viper.SetDefault("batches.default_concurrency", 4)
+	viper.SetDefault("batches.max_concurrency", 16)
+
+	// Fallback config defaults
+	viper.SetDefault("fallbacks.attempt_timeout", "0s")
+
+	// Retry config defaults
+	viper.SetDefault("retries.max_attempts", 3)
+	viper.SetDefault("retries.initial_delay", "1s")
+	viper.SetDefault("retries.max_delay", "20s")
 }
@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/shaharia-lab/goai"
+	"github.com/shaharia-lab/mcp-kit/internal/auth"
+	"github.com/shaharia-lab/mcp-kit/internal/service/llm"
+	"github.com/shaharia-lab/mcp-kit/internal/storage"
+)
+
+const (
+	// MaxAttachments is the maximum number of files attached to one question
+	MaxAttachments = 10
+
+	// MaxAttachmentSize is the maximum size of an attached file in bytes
+	MaxAttachmentSize = 5 << 20
+
+	// MaxQuestionRequestSize is the maximum size of a question request body,
+	// enough for the largest attachments in base64
+	MaxQuestionRequestSize = MaxAttachments*MaxAttachmentSize*4/3 + 1<<20
+
+	// multipartMemory is the part of a multipart form kept in memory, the
+	// rest is buffered in temporary files
+	multipartMemory = 32 << 20
+)
+
+// ErrAttachmentTooLarge is returned for questions with attachments above the size limits
+var ErrAttachmentTooLarge = errors.New("attachment too large")
+
+var (
+	// textAttachmentTypes are added to the text of the question, so every model accepts them
+	textAttachmentTypes = []string{"text/plain", "text/markdown", "text/csv", "application/json"}
+
+	// imageAttachmentTypes are sent as images to the models that support them
+	imageAttachmentTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+	// visionAttachmentTypes are the attachments accepted by models that support images
+	visionAttachmentTypes = slices.Concat(textAttachmentTypes, imageAttachmentTypes)
+)
+
+// decodeQuestionRequest reads the question from a JSON body, or from a
+// multipart form with the JSON in its "request" field and the files to attach
+// in "attachments" fields
+func decodeQuestionRequest(r *http.Request) (QuestionRequest, error) {
+	var req QuestionRequest
+	attachments, err := decodeRequestWithAttachments(r, &req)
+	req.Attachments = append(req.Attachments, attachments...)
+	return req, err
+}
+
+// decodeRequestWithAttachments decodes the JSON of a question request into v
+// and returns the files attached to a multipart form, see decodeQuestionRequest
+func decodeRequestWithAttachments(r *http.Request, v any) ([]storage.Attachment, error) {
+	body := http.MaxBytesReader(nil, r.Body, MaxQuestionRequestSize)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		if err := json.NewDecoder(body).Decode(v); err != nil {
+			return nil, requestBodyError(err)
+		}
+		return nil, nil
+	}
+
+	r.Body = body
+	if err := r.ParseMultipartForm(multipartMemory); err != nil {
+		return nil, requestBodyError(err)
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	if err := json.Unmarshal([]byte(r.FormValue("request")), v); err != nil {
+		return nil, fmt.Errorf("invalid request field: %w", err)
+	}
+
+	var attachments []storage.Attachment
+	for _, header := range r.MultipartForm.File["attachments"] {
+		attachment, err := readAttachment(header)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
+}
+
+func requestBodyError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return fmt.Errorf("%w: request body exceeds %d bytes", ErrAttachmentTooLarge, tooLarge.Limit)
+	}
+	return fmt.Errorf("invalid request body: %w", err)
+}
+
+func readAttachment(header *multipart.FileHeader) (storage.Attachment, error) {
+	if header.Size > MaxAttachmentSize {
+		return storage.Attachment{}, fmt.Errorf("%w: %s exceeds %d bytes", ErrAttachmentTooLarge, header.Filename, MaxAttachmentSize)
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return storage.Attachment{}, fmt.Errorf("failed to read attachment %s: %w", header.Filename, err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return storage.Attachment{}, fmt.Errorf("failed to read attachment %s: %w", header.Filename, err)
+	}
+
+	return storage.Attachment{
+		Name:        header.Filename,
+		ContentType: header.Header.Get("Content-Type"),
+		Data:        data,
+	}, nil
+}
+
+// validateAttachments checks the attachments of the question against the limits
+// and the content types the model accepts. Content types are normalized and the
+// sizes filled in.
+func validateAttachments(req QuestionRequest) error {
+	if len(req.Attachments) > MaxAttachments {
+		return fmt.Errorf("at most %d attachments are allowed", MaxAttachments)
+	}
+
+	for i := range req.Attachments {
+		attachment := &req.Attachments[i]
+		if attachment.Name == "" {
+			return errors.New("attachment name is required")
+		}
+		if len(attachment.Data) == 0 {
+			return fmt.Errorf("attachment %s is empty", attachment.Name)
+		}
+		if len(attachment.Data) > MaxAttachmentSize {
+			return fmt.Errorf("%w: %s exceeds %d bytes", ErrAttachmentTooLarge, attachment.Name, MaxAttachmentSize)
+		}
+
+		mediaType, err := attachmentContentType(*attachment)
+		if err != nil {
+			return err
+		}
+		if !isImageAttachment(mediaType) && !utf8.Valid(attachment.Data) {
+			return fmt.Errorf("attachment %s is not valid UTF-8 text", attachment.Name)
+		}
+
+		attachment.ContentType = mediaType
+		attachment.Size = len(attachment.Data)
+	}
+	return validateAttachmentTypes(req)
+}
+
+// attachmentContentType returns the media type of the attachment. Without a
+// content type, or with the generic one of multipart files, it is sniffed from
+// the data. Images have to be what their content type claims.
+func attachmentContentType(attachment storage.Attachment) (string, error) {
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(attachment.Data))
+	if attachment.ContentType == "" || attachment.ContentType == "application/octet-stream" {
+		return sniffed, nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(attachment.ContentType)
+	if err != nil {
+		return "", fmt.Errorf("invalid content type of attachment %s: %w", attachment.Name, err)
+	}
+	if (isImageAttachment(mediaType) || isImageAttachment(sniffed)) && mediaType != sniffed {
+		return "", fmt.Errorf("attachment %s is %s, not %s", attachment.Name, sniffed, mediaType)
+	}
+	return mediaType, nil
+}
+
+// validateAttachmentTypes checks that the model accepts the content types of
+// the attachments of the question, which are validated already
+func validateAttachmentTypes(req QuestionRequest) error {
+	model, _ := getLLMProviders().GetModel(req.LLMProvider.Provider, req.LLMProvider.ModelID)
+	for _, attachment := range req.Attachments {
+		if !slices.Contains(model.AttachmentTypes, attachment.ContentType) {
+			return fmt.Errorf("model %s doesn't support %s attachments", req.LLMProvider.ModelID, attachment.ContentType)
+		}
+	}
+	return nil
+}
+
+func isImageAttachment(contentType string) bool {
+	return slices.Contains(imageAttachmentTypes, contentType)
+}
+
+// withAttachmentData returns copies of the messages with the data of their
+// attachments, which the storage keeps apart from the messages
+func withAttachmentData(ctx context.Context, chatUUID uuid.UUID, messages []storage.Message, historyStorage storage.ChatHistoryStorage) ([]storage.Message, error) {
+	var ids []int64
+	for _, msg := range messages {
+		for _, attachment := range msg.Attachments {
+			ids = append(ids, attachment.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return messages, nil
+	}
+
+	attachments, err := historyStorage.GetAttachments(ctx, chatUUID, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load attachments: %w", err)
+	}
+
+	messages = slices.Clone(messages)
+	for i := range messages {
+		n := len(messages[i].Attachments)
+		messages[i].Attachments, attachments = attachments[:n:n], attachments[n:]
+	}
+	return messages, nil
+}
+
+// modelMessage returns the message as it is sent to the model, which needs the
+// data of its attachments. Text files are added to the text, images are
+// mentioned by name and sent along with the message, see attachmentImages.
+func modelMessage(msg storage.Message) goai.LLMMessage {
+	if len(msg.Attachments) == 0 {
+		return msg.LLMMessage
+	}
+
+	var b strings.Builder
+	b.WriteString(msg.Text)
+	for _, attachment := range msg.Attachments {
+		if isImageAttachment(attachment.ContentType) {
+			fmt.Fprintf(&b, "\n\n[Attached image: %s]", attachment.Name)
+			continue
+		}
+		fmt.Fprintf(&b, "\n\nAttached file %s:\n%s", attachment.Name, codeBlock("", string(attachment.Data)))
+	}
+
+	return goai.LLMMessage{Role: msg.Role, Text: strings.TrimRight(b.String(), "\n")}
+}
+
+// attachmentImages returns the images among the attachments, which need their data
+func attachmentImages(attachments []storage.Attachment) []llm.Image {
+	var images []llm.Image
+	for _, attachment := range attachments {
+		if isImageAttachment(attachment.ContentType) {
+			images = append(images, llm.Image{MediaType: attachment.ContentType, Data: attachment.Data})
+		}
+	}
+	return images
+}
+
+// modelImages returns the images sent to the model by the position of their
+// message among the user messages, see llm.ProviderConfig. images are the ones
+// of the last user messages, oldest first. Images the model doesn't accept are
+// left out, the text of their message still names them.
+func modelImages(req QuestionRequest, messages []goai.LLMMessage, images [][]llm.Image) map[int][]llm.Image {
+	model, _ := getLLMProviders().GetModel(req.LLMProvider.Provider, req.LLMProvider.ModelID)
+
+	userMessages := 0
+	for _, msg := range messages {
+		if msg.Role == goai.UserRole {
+			userMessages++
+		}
+	}
+
+	byMessage := make(map[int][]llm.Image)
+	first := userMessages - len(images)
+	for i, messageImages := range images {
+		for _, image := range messageImages {
+			if first+i >= 0 && slices.Contains(model.AttachmentTypes, image.MediaType) {
+				byMessage[first+i] = append(byMessage[first+i], image)
+			}
+		}
+	}
+	return byMessage
+}
+
+// GetAttachmentHandler Handler to download a file attached to a question of a
+// chat of the authenticated caller
+func GetAttachmentHandler(logger *log.Logger, historyStorage storage.ChatHistoryStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parsedChatUUID, ok := parseChatUUID(w, r, logger)
+		if !ok {
+			return
+		}
+		attachmentID, err := strconv.ParseInt(chi.URLParam(r, "attachmentId"), 10, 64)
+		if err != nil {
+			http.Error(w, `{"error": "Invalid attachment ID"}`, http.StatusBadRequest)
+			return
+		}
+
+		// Attachments are only found through a chat of the caller
+		chat, err := historyStorage.GetOwnedChat(r.Context(), parsedChatUUID, auth.SubjectFromContext(r.Context()))
+		if err != nil {
+			logger.Printf("Chat not found for UUID: %v, error: %v", parsedChatUUID, err)
+			http.Error(w, `{"error": "Chat not found"}`, http.StatusNotFound)
+			return
+		}
+
+		attachments, err := historyStorage.GetAttachments(r.Context(), chat.UUID, []int64{attachmentID})
+		if errors.Is(err, storage.ErrAttachmentNotFound) {
+			http.Error(w, `{"error": "Attachment not found"}`, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Printf("Error loading attachment %d of chat %s: %v", attachmentID, chat.UUID, err)
+			http.Error(w, `{"error": "Failed to load attachment"}`, http.StatusInternalServerError)
+			return
+		}
+		attachment := attachments[0]
+
+		w.Header().Set("Content-Type", attachment.ContentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(attachment.Data)))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name}))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(attachment.Data); err != nil {
+			logger.Printf("Error writing attachment %d of chat %s: %v", attachmentID, chat.UUID, err)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shaharia-lab/goai"
+	"github.com/shaharia-lab/mcp-kit/internal/config"
+	"github.com/shaharia-lab/mcp-kit/internal/observability"
+)
+
+// MaxBatchRequestSize is the maximum size of a batch request body in bytes
+const MaxBatchRequestSize = 10 << 20
+
+// Types of the lines of a batch response
+const (
+	BatchLineResult  = "result"
+	BatchLineSummary = "summary"
+)
+
+// BatchRequest is a list of questions answered independently of each other by
+// the same model with the same tools. The answers are not saved to any chat.
+type BatchRequest struct {
+	Questions      []BatchQuestion `json:"questions"`
+	SelectedTools  []string        `json:"selectedTools"`
+	ModelSettings  ModelSettings   `json:"modelSettings"`
+	LLMProvider    LLMProvider     `json:"llmProvider"`
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	// Concurrency is how many questions are answered at the same time, 0 for
+	// the server's default
+	Concurrency int `json:"concurrency,omitempty"`
+}
+
+// BatchQuestion is a question of a batch. ID is returned with its result.
+type BatchQuestion struct {
+	ID       string `json:"id,omitempty"`
+	Question string `json:"question"`
+}
+
+// BatchResult is the answer to a question of a batch, or why it failed. Index
+// is the position of the question in the batch.
+type BatchResult struct {
+	Type        string          `json:"type"`
+	Index       int             `json:"index"`
+	ID          string          `json:"id,omitempty"`
+	Answer      string          `json:"answer,omitempty"`
+	Output      json.RawMessage `json:"output,omitempty"`
+	InputToken  int             `json:"input_token"`
+	OutputToken int             `json:"output_token"`
+	LatencyMs   int64           `json:"latency_ms"`
+	// Provider and Model generated the answer, see Response
+	Provider string `json:"provider,omitempty"`
+	Model    string `json:"model,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// BatchSummary is the last line of a batch response
+type BatchSummary struct {
+	Type        string `json:"type"`
+	Total       int    `json:"total"`
+	Succeeded   int    `json:"succeeded"`
+	Failed      int    `json:"failed"`
+	InputToken  int    `json:"input_token"`
+	OutputToken int    `json:"output_token"`
+	DurationMs  int64  `json:"duration_ms"`
+}
+
+func (s *BatchSummary) add(result BatchResult) {
+	if result.Error == "" {
+		s.Succeeded++
+	} else {
+		s.Failed++
+	}
+	s.InputToken += result.InputToken
+	s.OutputToken += result.OutputToken
+}
+
+// questionRequest returns the question of the batch as a QuestionRequest
+func (b BatchRequest) questionRequest(question BatchQuestion) QuestionRequest {
+	return QuestionRequest{
+		Question:       question.Question,
+		SelectedTools:  b.SelectedTools,
+		ModelSettings:  b.ModelSettings,
+		LLMProvider:    b.LLMProvider,
+		ResponseFormat: b.ResponseFormat,
+	}
+}
+
+func validateBatchRequest(req BatchRequest, cfg config.BatchesConfig) error {
+	if len(req.Questions) == 0 {
+		return errors.New("questions cannot be empty")
+	}
+	if cfg.MaxQuestions > 0 && len(req.Questions) > cfg.MaxQuestions {
+		return fmt.Errorf("a batch can have at most %d questions", cfg.MaxQuestions)
+	}
+	if req.Concurrency < 0 || (cfg.MaxConcurrency > 0 && req.Concurrency > cfg.MaxConcurrency) {
+		return fmt.Errorf("concurrency must be between 0 and %d", cfg.MaxConcurrency)
+	}
+	for i, question := range req.Questions {
+		if strings.TrimSpace(question.Question) == "" {
+			return fmt.Errorf("questions[%d].question cannot be empty", i)
+		}
+	}
+
+	// The questions share everything else, so checking one checks them all
+	return validateRequest(req.questionRequest(req.Questions[0]))
+}
+
+// BatchHandler Handler to answer a batch of questions. The results are written
+// as JSON lines in the order the answers are done, followed by a summary line
+// with the totals.
+func BatchHandler(deps ChatDependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := observability.StartSpan(r.Context(), "handle_batch")
+		defer span.End()
+
+		var req BatchRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBatchRequestSize)).Decode(&req); err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, "Invalid request body: "+err.Error(), err, ctx)
+			return
+		}
+		if err := validateBatchRequest(req, deps.Batches); err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, err.Error(), err, ctx)
+			return
+		}
+
+		concurrency := req.Concurrency
+		if concurrency == 0 {
+			concurrency = deps.Batches.DefaultConcurrency
+		}
+		concurrency = max(concurrency, 1)
+
+		observability.AddAttribute(ctx, "batch.questions", len(req.Questions))
+		observability.AddAttribute(ctx, "batch.concurrency", concurrency)
+		observability.AddAttribute(ctx, "llm.provider", req.LLMProvider.Provider)
+		observability.AddAttribute(ctx, "llm.model_id", req.LLMProvider.ModelID)
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusOK)
+		flusher, _ := w.(http.Flusher)
+
+		start := time.Now()
+		summary := BatchSummary{Type: BatchLineSummary, Total: len(req.Questions)}
+		encoder := json.NewEncoder(w)
+		for result := range runBatch(ctx, req, concurrency, deps) {
+			summary.add(result)
+			// The remaining questions fail fast once the client is gone
+			if err := encoder.Encode(result); err != nil {
+				continue
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		summary.DurationMs = time.Since(start).Milliseconds()
+		observability.AddAttribute(ctx, "batch.failed", summary.Failed)
+		if err := encoder.Encode(summary); err != nil {
+			deps.Logger.Printf("Failed to write batch summary: %v", err)
+		}
+	}
+}
+
+// runBatch answers the questions of the batch, up to concurrency at the same
+// time, and sends their results as they are done
+func runBatch(ctx context.Context, req BatchRequest, concurrency int, deps ChatDependencies) <-chan BatchResult {
+	results := make(chan BatchResult)
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < min(concurrency, len(req.Questions)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				results <- answerBatchQuestion(ctx, req, index, deps)
+			}
+		}()
+	}
+
+	go func() {
+		for index := range req.Questions {
+			indexes <- index
+		}
+		close(indexes)
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+// answerBatchQuestion answers the question at index of the batch. Every call
+// to the provider waits for its rate limit, the calls of tool loops, retries
+// and structured output retries included.
+func answerBatchQuestion(ctx context.Context, batch BatchRequest, index int, deps ChatDependencies) BatchResult {
+	ctx, span := observability.StartSpan(ctx, "batch_question")
+	defer span.End()
+	observability.AddAttribute(ctx, "batch.index", index)
+
+	question := batch.Questions[index]
+	result := BatchResult{Type: BatchLineResult, Index: index, ID: question.ID}
+	req := batch.questionRequest(question)
+
+	messages, err := buildMessagesFromPromptTemplates(ctx, deps.MCPClient, req)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to build prompt templates: %v", err)
+		return result
+	}
+	messages = append(messages, goai.LLMMessage{Role: goai.UserRole, Text: req.Question})
+	messages = withResponseFormatInstruction(messages, req.ResponseFormat)
+
+	calls := llmCalls{
+		fallbacks:  fallbackChainFor(req, deps.Fallbacks),
+		retries:    deps.Retries,
+		rateLimits: deps.RateLimits,
+		logger:     deps.Logger,
+	}
+	llmCompletion, provider, err := setupLLMCompletion(ctx, req, messages, nil, deps.ToolsProvider, nil, calls)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	metrics := startCompletionMetrics(req, false)
+	defer metrics.record(ctx)
+
+	start := time.Now()
+	response, err := llmCompletion.Generate(ctx, messages)
+	if err == nil && req.ResponseFormat != nil {
+		reqCtx := &chatRequestContext{ctx: ctx, req: req, messages: messages, llmCompletion: llmCompletion, provider: provider, calls: calls, logger: deps.Logger}
+		response, result.Output, err = generateStructuredOutput(ctx, reqCtx, response, deps.StructuredOutput.MaxRetries)
+	}
+	result.LatencyMs = time.Since(start).Milliseconds()
+
+	// An answer that doesn't match the response format is returned with the error
+	if err == nil || errors.Is(err, ErrInvalidStructuredOutput) {
+		answered := provider.Answered()
+		result.Provider, result.Model = answered.Provider, answered.ModelID
+		metrics.answeredBy(LLMProvider{Provider: answered.Provider, ModelID: answered.ModelID})
+		metrics.complete(completionStatusSuccess, response.TotalInputToken, response.TotalOutputToken)
+		result.Answer = response.Text
+		result.InputToken = response.TotalInputToken
+		result.OutputToken = response.TotalOutputToken
+	}
+	if err != nil {
+		span.RecordError(err)
+		result.Error = err.Error()
+	}
+	return result
+}
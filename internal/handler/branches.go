@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/shaharia-lab/goai"
+	"github.com/shaharia-lab/mcp-kit/internal/auth"
+	"github.com/shaharia-lab/mcp-kit/internal/observability"
+	"github.com/shaharia-lab/mcp-kit/internal/storage"
+)
+
+// Branch describes one branch of a conversation by its last message
+type Branch struct {
+	LeafMessageID int64           `json:"leaf_message_id"`
+	Messages      int             `json:"messages"`
+	LastMessage   storage.Message `json:"last_message"`
+	Active        bool            `json:"active"`
+}
+
+// BranchesResponse lists all branches of a chat, oldest first
+type BranchesResponse struct {
+	ChatUUID        uuid.UUID `json:"chat_uuid"`
+	ActiveMessageID int64     `json:"active_message_id"`
+	Branches        []Branch  `json:"branches"`
+}
+
+// SwitchBranchRequest selects the branch to continue the conversation on. The
+// latest branch going through MessageID becomes the active one.
+type SwitchBranchRequest struct {
+	MessageID int64 `json:"message_id"`
+}
+
+// ListBranchesHandler Handler to list the branches of a chat of the authenticated caller
+func ListBranchesHandler(logger *log.Logger, historyStorage storage.ChatHistoryStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parsedChatUUID, ok := parseChatUUID(w, r, logger)
+		if !ok {
+			return
+		}
+
+		chat, err := historyStorage.GetOwnedChat(r.Context(), parsedChatUUID, auth.SubjectFromContext(r.Context()))
+		if err != nil {
+			logger.Printf("Chat not found for UUID: %v, error: %v", parsedChatUUID, err)
+			http.Error(w, `{"error": "Chat not found"}`, http.StatusNotFound)
+			return
+		}
+
+		response := BranchesResponse{
+			ChatUUID:        chat.UUID,
+			ActiveMessageID: chat.ActiveMessageID,
+			Branches:        []Branch{},
+		}
+		for _, leaf := range chat.Leaves() {
+			response.Branches = append(response.Branches, Branch{
+				LeafMessageID: leaf.ID,
+				Messages:      len(chat.Branch(leaf.ID)),
+				LastMessage:   leaf,
+				Active:        leaf.ID == chat.ActiveMessageID,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			logger.Printf("Error encoding branches response: %v", err)
+		}
+	}
+}
+
+// SwitchBranchHandler Handler to continue a chat of the authenticated caller on another branch
+func SwitchBranchHandler(logger *log.Logger, historyStorage storage.ChatHistoryStorage, generations *GenerationRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := observability.StartSpan(r.Context(), "switch_branch")
+		defer span.End()
+
+		parsedChatUUID, ok := parseChatUUID(w, r, logger)
+		if !ok {
+			return
+		}
+
+		var req SwitchBranchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+			return
+		}
+
+		chat, err := historyStorage.GetOwnedChat(ctx, parsedChatUUID, auth.SubjectFromContext(ctx))
+		if err != nil {
+			logger.Printf("Chat not found for UUID: %v, error: %v", parsedChatUUID, err)
+			http.Error(w, `{"error": "Chat not found"}`, http.StatusNotFound)
+			return
+		}
+
+		leaf, err := chat.LatestLeaf(req.MessageID)
+		if err != nil {
+			http.Error(w, `{"error": "Message not found"}`, http.StatusNotFound)
+			return
+		}
+
+		// Don't pull the branch out from under a running generation
+		ctx, release, err := generations.Start(ctx, chat.UUID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusConflict)
+			return
+		}
+		defer release()
+
+		chat, err = switchBranch(ctx, chat, leaf.ID, historyStorage)
+		if err != nil {
+			logger.Printf("Failed to switch branch of chat %s: %v", parsedChatUUID, err)
+			http.Error(w, `{"error": "Failed to switch branch"}`, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(activeBranchOnly(*chat)); err != nil {
+			logger.Printf("Error encoding chat response: %v", err)
+		}
+	}
+}
+
+// validateBranchRequest checks the branching fields of a question
+func validateBranchRequest(req QuestionRequest) error {
+	if req.ParentMessageID == nil && req.RegenerateMessageID == 0 {
+		return nil
+	}
+	if req.ChatUUID == uuid.Nil {
+		return errors.New("chat_uuid is required to branch a conversation")
+	}
+	if req.ParentMessageID != nil && req.RegenerateMessageID != 0 {
+		return errors.New("parent_message_id and regenerate_message_id cannot be used together")
+	}
+	if req.RegenerateMessageID != 0 && (req.Question != "" || len(req.Attachments) > 0) {
+		return errors.New("question and attachments must be empty when regenerating an answer")
+	}
+	return nil
+}
+
+// checkoutBranch moves the active branch of the chat to where the question
+// branches off. When an answer is regenerated the question it answered is
+// copied into req.
+func checkoutBranch(ctx context.Context, req *QuestionRequest, chat *storage.Chat, historyStorage storage.ChatHistoryStorage) (*storage.Chat, error) {
+	var target int64
+	switch {
+	case req.RegenerateMessageID != 0:
+		answer, ok := chat.Message(req.RegenerateMessageID)
+		if !ok {
+			return nil, fmt.Errorf("%w: %d", storage.ErrMessageNotFound, req.RegenerateMessageID)
+		}
+		if answer.Role != goai.AssistantRole {
+			return nil, errors.New("regenerate_message_id must refer to an answer")
+		}
+
+		question, ok := chat.Message(answer.ParentID)
+		if !ok || question.Role != goai.UserRole {
+			return nil, errors.New("the answer to regenerate doesn't follow a question")
+		}
+
+		req.Question = question.Text
+		req.Attachments = question.Attachments
+		target = question.ID
+	case req.ParentMessageID != nil:
+		target = *req.ParentMessageID
+		if target != storage.RootMessageID {
+			parent, ok := chat.Message(target)
+			if !ok {
+				return nil, fmt.Errorf("%w: %d", storage.ErrMessageNotFound, target)
+			}
+			if parent.Role == goai.UserRole {
+				return nil, errors.New("parent_message_id must refer to an answer")
+			}
+		}
+	default:
+		return chat, nil
+	}
+
+	observability.AddAttribute(ctx, "chat.branch_from", target)
+	return switchBranch(ctx, chat, target, historyStorage)
+}
+
+// switchBranch makes messageID the end of the active branch. The rolling
+// summary is dropped when it covers messages that aren't part of the new branch.
+func switchBranch(ctx context.Context, chat *storage.Chat, messageID int64, historyStorage storage.ChatHistoryStorage) (*storage.Chat, error) {
+	shared := sharedMessages(chat.ActiveBranch(), chat.Branch(messageID))
+
+	if err := historyStorage.SetActiveMessage(ctx, chat.UUID, messageID); err != nil {
+		return nil, fmt.Errorf("failed to switch branch: %w", err)
+	}
+
+	if shared < chat.SummarizedMessages {
+		if err := historyStorage.ResetChatSummary(ctx, chat.UUID); err != nil {
+			return nil, fmt.Errorf("failed to reset chat summary: %w", err)
+		}
+	}
+
+	return historyStorage.GetOwnedChat(ctx, chat.UUID, chat.Owner)
+}
+
+// sharedMessages counts the non-system messages both branches start with
+func sharedMessages(a, b []storage.Message) int {
+	shared := 0
+	for i := 0; i < len(a) && i < len(b) && a[i].ID == b[i].ID; i++ {
+		if a[i].Role != goai.SystemRole {
+			shared++
+		}
+	}
+	return shared
+}
+
+// activeBranchOnly returns the chat with only the messages of its active
+// branch, which is how conversations are presented to clients
+func activeBranchOnly(chat storage.Chat) storage.Chat {
+	chat.Messages = chat.ActiveBranch()
+	if chat.Messages == nil {
+		chat.Messages = []storage.Message{}
+	}
+	return chat
+}
@@ -7,28 +7,29 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/otel/trace"
 
 	"github.com/google/uuid"
 	"github.com/shaharia-lab/goai"
 	"github.com/shaharia-lab/goai/mcp"
+	"github.com/shaharia-lab/mcp-kit/internal/auth"
+	"github.com/shaharia-lab/mcp-kit/internal/config"
 	"github.com/shaharia-lab/mcp-kit/internal/observability"
 	"github.com/shaharia-lab/mcp-kit/internal/service/llm"
+	"github.com/shaharia-lab/mcp-kit/internal/storage"
 )
 
-const (
-	MaxChatHistoryMessages = 20 // Configurable max number of messages to retain
-)
-
+// ModelSettings are the parameters of the model. Zero is a valid temperature
+// and top p, so they are nil when left out.
 type ModelSettings struct {
-	Temperature float64 `json:"temperature"`
-	MaxTokens   int64   `json:"maxTokens"`
-	TopP        float64 `json:"topP"`
-	TopK        int64   `json:"topK"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	MaxTokens   int64    `json:"maxTokens"`
+	TopP        *float64 `json:"topP,omitempty"`
+	TopK        int64    `json:"topK"`
 }
 
 type LLMProvider struct {
@@ -48,149 +49,333 @@ type QuestionRequest struct {
 	ModelSettings  ModelSettings  `json:"modelSettings"`
 	LLMProvider    LLMProvider    `json:"llmProvider"`
 	StreamSettings StreamSettings `json:"stream_settings"`
+	// ParentMessageID branches the conversation off: the question replies to
+	// this message instead of the last one, 0 starts over from the beginning
+	ParentMessageID *int64 `json:"parent_message_id,omitempty"`
+	// RegenerateMessageID asks for another answer next to the given one, to
+	// the same question. Question must be empty.
+	RegenerateMessageID int64 `json:"regenerate_message_id,omitempty"`
+	// Attachments are files sent along with the question, see decodeQuestionRequest
+	Attachments []storage.Attachment `json:"attachments,omitempty"`
+	// ResponseFormat asks for the answer as JSON, see validateResponseFormat.
+	// Streamed answers don't support it.
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	// SystemPrompt or Persona replace the server's prompt templates for the
+	// whole chat. They can only be set when the chat is created.
+	SystemPrompt string `json:"system_prompt,omitempty"`
+	Persona      string `json:"persona,omitempty"`
 }
 
 type Response struct {
-	ChatUUID    uuid.UUID `json:"chat_uuid"`
-	Answer      string    `json:"answer"`
-	InputToken  int       `json:"input_token"`
-	OutputToken int       `json:"output_token"`
+	ChatUUID          uuid.UUID `json:"chat_uuid"`
+	Answer            string    `json:"answer"`
+	InputToken        int       `json:"input_token"`
+	OutputToken       int       `json:"output_token"`
+	TruncatedMessages int       `json:"truncated_messages"`
+	Cancelled         bool      `json:"cancelled,omitempty"`
+	// LatencyMs is how long generating the answer took
+	LatencyMs    int64                `json:"latency_ms,omitempty"`
+	FinishReason storage.FinishReason `json:"finish_reason,omitempty"`
+	// Output is the answer parsed as JSON when a response format was requested
+	Output json.RawMessage `json:"output,omitempty"`
+	// Provider and Model generated the answer. They differ from the requested
+	// ones when the answer comes from a fallback.
+	Provider string `json:"provider,omitempty"`
+	Model    string `json:"model,omitempty"`
+}
+
+// ChatDependencies holds the collaborators shared by the chat handlers
+type ChatDependencies struct {
+	MCPClient      *mcp.Client
+	Logger         *log.Logger
+	HistoryStorage storage.ChatHistoryStorage
+	Searcher       storage.ChatSearcher
+	ToolsProvider  *goai.ToolsProvider
+	Summarizer     *llm.Summarizer
+	Streaming      config.StreamingConfig
+	Generations    *GenerationRegistry
+	Jobs           *JobRunner
+	// StructuredOutput limits the retries of answers that don't match the response format
+	StructuredOutput config.StructuredOutputConfig
+	SystemPrompts    config.SystemPromptConfig
+	Batches          config.BatchesConfig
+	// RateLimits spaces out the requests of batches to each provider
+	RateLimits *ProviderRateLimiter
+	Fallbacks  config.FallbackConfig
+	Retries    config.RetryConfig
 }
 
 type chatRequestContext struct {
-	ctx            context.Context
-	span           trace.Span
-	req            QuestionRequest
-	chat           *goai.ChatHistory
-	messages       []goai.LLMMessage
-	llmCompletion  *goai.LLMRequest
-	logger         *log.Logger
-	historyStorage goai.ChatHistoryStorage
-}
-
-func prepareRequestContext(
-	r *http.Request,
-	logger *log.Logger,
-	historyStorage goai.ChatHistoryStorage,
-	toolsProvider *goai.ToolsProvider,
-	mcpClient *mcp.Client,
-	operationName string,
-) (*chatRequestContext, error) {
-	ctx, span := observability.StartSpan(r.Context(), operationName)
+	ctx               context.Context
+	span              trace.Span
+	req               QuestionRequest
+	chat              *storage.Chat
+	messages          []goai.LLMMessage
+	truncatedMessages int
+	llmCompletion     *goai.LLMRequest
+	provider          *llm.FallbackProvider
+	logger            *log.Logger
+	historyStorage    storage.ChatHistoryStorage
+	// release unregisters the generation from the GenerationRegistry
+	release func()
+	// toolCalls records the tool calls to save with the answer
+	toolCalls *toolCallRecorder
+	// startedAt is when the model was asked for the answer
+	startedAt time.Time
+	// answeredAt is when a streamed answer arrived completely, before it was
+	// paced. It is zero until then.
+	answeredAt time.Time
+	// images and calls are what llmCompletion was set up with, which the
+	// corrections of a structured answer are set up with as well
+	images [][]llm.Image
+	calls  llmCalls
+}
+
+// prepareRequestContext decodes the question and prepares it with prepareQuestion
+func prepareRequestContext(r *http.Request, deps ChatDependencies, operationName string, streaming bool, toolObserver toolCallObserver) (*chatRequestContext, error) {
+	req, err := decodeQuestionRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return prepareQuestion(r.Context(), req, deps, operationName, streaming, toolObserver)
+}
+
+// prepareQuestion validates the question and sets up the chat and the LLM
+// request. Tool calls are recorded, the optional toolObserver is notified about
+// them as well.
+func prepareQuestion(ctx context.Context, req QuestionRequest, deps ChatDependencies, operationName string, streaming bool, toolObserver toolCallObserver) (*chatRequestContext, error) {
+	ctx, span := observability.StartSpan(ctx, operationName)
 
-	var req QuestionRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return nil, fmt.Errorf("invalid request body: %w", err)
+	// Follow-up questions inherit the settings of the chat they don't set themselves
+	var chat *storage.Chat
+	var err error
+	if req.ChatUUID != uuid.Nil {
+		chat, err = getOwnedChat(ctx, req.ChatUUID, deps.HistoryStorage, deps.Logger)
+		if err != nil {
+			return nil, err
+		}
+		inheritChatSettings(&req, chat.Settings)
 	}
 
 	// Validate request
 	if err := validateRequest(req); err != nil {
 		return nil, err
 	}
+	if streaming && req.ResponseFormat != nil {
+		return nil, errors.New("response_format is not supported for streamed answers")
+	}
 
 	// Add observability attributes
 	addRequestAttributes(ctx, req)
 
-	// Initialize chat and get history
-	chat, messages, err := initializeChatAndHistory(ctx, req, historyStorage, logger, mcpClient)
+	if chat == nil {
+		chat, err = createChat(ctx, req, deps)
+		if err != nil {
+			return nil, err
+		}
+	}
+	systemPrompt := chatSystemPrompt(chat, deps.SystemPrompts)
+	observability.AddAttribute(ctx, "chat.system_prompt", systemPrompt != "")
+	observability.AddAttribute(ctx, "chat.persona", chat.Persona)
+
+	// Register the generation so it can be cancelled, one at a time per chat.
+	// It keeps running when the client goes away, so the answer is still saved.
+	ctx, release, err := deps.Generations.Start(context.WithoutCancel(ctx), chat.UUID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Add user message
-	messages, err = addUserMessage(ctx, messages, req.Question, chat.UUID, historyStorage)
+	// Edited questions and regenerated answers continue on a new branch
+	chat, err = checkoutBranch(ctx, &req, chat, deps.HistoryStorage)
 	if err != nil {
+		release()
 		return nil, err
 	}
 
+	// A regenerated answer may be asked from a model that doesn't support the attachments of the question
+	if req.RegenerateMessageID != 0 {
+		if err := validateAttachmentTypes(req); err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	// Get the history of the active branch
+	history, err := initializeChatHistory(ctx, req, chat, systemPrompt, deps.HistoryStorage, deps.Logger, deps.MCPClient, deps.Summarizer)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	messages, images := history.messages, history.images
+
+	// Add user message, a regenerated answer replies to the existing one
+	if req.RegenerateMessageID == 0 {
+		messages, err = addUserMessage(ctx, messages, req.Question, req.Attachments, chat.UUID, deps.HistoryStorage)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		images = append(images, attachmentImages(req.Attachments))
+	}
+
+	// The question is saved as asked, the model is told about the response format as well
+	messages = withResponseFormatInstruction(messages, req.ResponseFormat)
+
 	// Setup LLM
-	llmCompletion, err := setupLLMCompletion(ctx, req, toolsProvider)
+	toolCalls := newToolCallRecorder(toolObserver)
+	calls := llmCalls{
+		fallbacks: fallbackChainFor(req, deps.Fallbacks),
+		retries:   deps.Retries,
+		system:    history.system,
+		logger:    deps.Logger,
+	}
+	llmCompletion, provider, err := setupLLMCompletion(ctx, req, messages, images, deps.ToolsProvider, toolCalls.observe, calls)
 	if err != nil {
+		release()
 		return nil, err
 	}
 
 	return &chatRequestContext{
-		ctx:            ctx,
-		span:           span,
-		req:            req,
-		chat:           chat,
-		messages:       messages,
-		llmCompletion:  llmCompletion,
-		logger:         logger,
-		historyStorage: historyStorage,
+		ctx:               ctx,
+		span:              span,
+		req:               req,
+		chat:              chat,
+		messages:          messages,
+		truncatedMessages: history.truncated,
+		llmCompletion:     llmCompletion,
+		provider:          provider,
+		images:            images,
+		calls:             calls,
+		logger:            deps.Logger,
+		historyStorage:    deps.HistoryStorage,
+		release:           release,
+		toolCalls:         toolCalls,
 	}, nil
 }
 
-func HandleAsk(mcpClient *mcp.Client, logger *log.Logger, historyStorage goai.ChatHistoryStorage, toolsProvider *goai.ToolsProvider) http.HandlerFunc {
+func HandleAsk(deps ChatDependencies) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		reqCtx, err := prepareRequestContext(r, logger, historyStorage, toolsProvider, mcpClient, "handle_ask")
+		reqCtx, err := prepareRequestContext(r, deps, "handle_ask", false, nil)
 		if err != nil {
-			writeErrorResponse(w, http.StatusBadRequest, err.Error(), err, r.Context())
+			writeErrorResponse(w, requestErrorStatus(err), err.Error(), err, r.Context())
 			return
 		}
 		defer reqCtx.span.End()
+		defer reqCtx.release()
 
 		// Generate response
-		generateCtx, generateSpan := observability.StartSpan(reqCtx.ctx, "generate_response")
-		defer generateSpan.End()
-
-		response, err := generateSynchronousResponse(generateCtx, reqCtx)
+		response, err := answerQuestion(reqCtx, deps.StructuredOutput.MaxRetries)
+		if errors.Is(err, ErrInvalidStructuredOutput) {
+			writeErrorResponse(w, http.StatusUnprocessableEntity, err.Error(), err, reqCtx.ctx)
+			return
+		}
 		if err != nil {
-			writeErrorResponse(w, http.StatusInternalServerError, err.Error(), err, generateCtx)
+			writeGenerationError(w, err, reqCtx.ctx)
 			return
 		}
 
 		// Return the successful response
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(Response{
-			ChatUUID:    reqCtx.chat.UUID,
-			Answer:      response.Text,
-			InputToken:  response.TotalInputToken,
-			OutputToken: response.TotalOutputToken,
-		})
+		json.NewEncoder(w).Encode(response)
 	}
 }
 
-func HandleAskStream(mcpClient *mcp.Client, logger *log.Logger, historyStorage goai.ChatHistoryStorage, toolsProvider *goai.ToolsProvider) http.HandlerFunc {
+func HandleAskStream(deps ChatDependencies) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		reqCtx, err := prepareRequestContext(r, logger, historyStorage, toolsProvider, mcpClient, "handle_ask_stream")
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
 			return
 		}
-		defer reqCtx.span.End()
 
-		// Setup streaming headers
-		var chatUUID string
-		if reqCtx.chat != nil {
-			chatUUID = reqCtx.chat.UUID.String()
+		// The stream format is negotiated with the Accept header
+		stream := newStreamWriter(r, w, flusher, 0)
+
+		// Tool calls are handed over to the goroutine generating the answer,
+		// which keeps them in order with the text deltas
+		toolCalls := make(chan streamEvent)
+		generated := make(chan struct{})
+		observer := func(event string, call ToolCallEvent) {
+			select {
+			case toolCalls <- streamEvent{name: event, data: call}:
+			case <-generated:
+			}
 		}
 
-		if err := setupStreamingHeaders(w, chatUUID); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		reqCtx, err := prepareRequestContext(r, deps, "handle_ask_stream", true, observer)
+		if err != nil {
+			http.Error(w, err.Error(), requestErrorStatus(err))
 			return
 		}
 
-		flusher, ok := w.(http.Flusher)
-		if !ok {
-			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		// The answer is generated into a buffer the client reads from, so it
+		// survives the connection and can be resumed from GET /{chatId}/stream
+		events := newEventBuffer(reqCtx.truncatedMessages)
+		deps.Generations.Stream(reqCtx.chat.UUID, events)
+
+		settings := effectiveStreamSettings(reqCtx.req.StreamSettings, deps.Streaming)
+		go func() {
+			defer reqCtx.span.End()
+			defer reqCtx.release()
+			defer events.Close()
+			defer close(generated)
+
+			if err := handleStreamingResponse(reqCtx, events, settings, toolCalls); err != nil {
+				deps.Logger.Printf("Streaming error: %v", err)
+				reqCtx.span.RecordError(err)
+
+				// The status has been sent already, so the error is reported on the stream
+				status, response := generationError(err)
+				streamErr := StreamError{Error: response.Error, Type: response.Type, Status: status, RetryAfter: response.RetryAfter}
+				if err := events.WriteEvent(StreamEventError, streamErr); err != nil {
+					deps.Logger.Printf("Failed to write stream error: %v", err)
+				}
+			}
+		}()
+
+		if err := setupStreamingHeaders(w, stream.ContentType(), reqCtx.chat.UUID.String(), reqCtx.truncatedMessages); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		err = handleStreamingResponse(reqCtx, w, flusher)
-		if err != nil {
-			logger.Printf("Streaming error: %v", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		stopHeartbeat := startHeartbeat(stream, deps.Streaming.HeartbeatInterval)
+		defer stopHeartbeat()
+
+		if err := relayEvents(r.Context(), events, stream, 0); err != nil && r.Context().Err() == nil {
+			deps.Logger.Printf("Failed to write stream: %v", err)
 		}
 	}
 }
 
 // Helper functions
 
+// requestErrorStatus maps an error from preparing a chat request to an HTTP status code
+func requestErrorStatus(err error) int {
+	if errors.Is(err, storage.ErrChatNotFound) || errors.Is(err, storage.ErrMessageNotFound) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, ErrGenerationInProgress) {
+		return http.StatusConflict
+	}
+	if errors.Is(err, ErrAttachmentTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	if errors.Is(err, ErrSystemPromptForbidden) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
 func validateRequest(req QuestionRequest) error {
-	if req.Question == "" {
+	if err := validateBranchRequest(req); err != nil {
+		return err
+	}
+	if req.Question == "" && req.RegenerateMessageID == 0 {
 		return errors.New("question cannot be empty")
 	}
+	if (req.SystemPrompt != "" || req.Persona != "") && req.ChatUUID != uuid.Nil {
+		return errors.New("system_prompt and persona can only be set when a chat is created")
+	}
 	if req.LLMProvider.Provider == "" || req.LLMProvider.ModelID == "" {
 		return errors.New("LLM provider is required")
 	}
@@ -198,61 +383,246 @@ func validateRequest(req QuestionRequest) error {
 	if !supportedLLMProviders.IsSupported(req.LLMProvider.Provider, req.LLMProvider.ModelID) {
 		return errors.New("LLM provider or model is not supported")
 	}
-	return nil
+	if err := validateAttachments(req); err != nil {
+		return err
+	}
+	if err := validateResponseFormat(req.ResponseFormat); err != nil {
+		return err
+	}
+	return validateStreamSettings(req.StreamSettings)
 }
 
-func setupLLMCompletion(ctx context.Context, req QuestionRequest, toolsProvider *goai.ToolsProvider) (*goai.LLMRequest, error) {
+// llmCalls is how a completion calls the providers' APIs
+type llmCalls struct {
+	fallbacks fallbackChain
+	// retries applies to every single call, see llm.RetryPolicy
+	retries config.RetryConfig
+	// rateLimits, if set, is waited for before every single call
+	rateLimits *ProviderRateLimiter
+	// system is the system message of the chat, which is sent to every
+	// provider, see llm.ProviderConfig.System
+	system string
+	logger *log.Logger
+}
+
+// setupLLMCompletion sets up the request to the requested model, falling back
+// to the next model of the chain of calls when it fails. images are the ones
+// of the last user messages, see modelImages.
+func setupLLMCompletion(ctx context.Context, req QuestionRequest, messages []goai.LLMMessage, images [][]llm.Image, toolsProvider *goai.ToolsProvider, toolObserver toolCallObserver, calls llmCalls) (*goai.LLMRequest, *llm.FallbackProvider, error) {
 	reqOptions := prepareLLMRequestOptions(req)
 	if len(req.SelectedTools) > 0 {
+		observed, err := observeToolCalls(ctx, toolsProvider, req.SelectedTools, toolObserver)
+		if err != nil {
+			return nil, nil, err
+		}
+		toolsProvider = observed
+
 		reqOptions = append(reqOptions,
 			goai.UseToolsProvider(toolsProvider),
 			goai.WithAllowedTools(req.SelectedTools),
 		)
 	}
 
-	builder := llm.NewLLMBuilder(ctx)
-	llmProvider, err := builder.BuildProvider(llm.ProviderConfig{
-		Provider: req.LLMProvider.Provider,
-		ModelID:  req.LLMProvider.ModelID,
-	})
-	if err != nil {
-		return nil, err
+	candidates := make([]llm.Candidate, 0, len(calls.fallbacks.models)+1)
+	for i, model := range append([]LLMProvider{req.LLMProvider}, calls.fallbacks.models...) {
+		modelReq := req
+		modelReq.LLMProvider = model
+
+		llmProvider, err := buildLLMProvider(ctx, modelReq, messages, images, calls)
+		if err != nil {
+			if i == 0 {
+				return nil, nil, err
+			}
+			// A fallback that isn't set up, e.g. without an API key, is left out
+			calls.logger.Printf("Fallback %s %s is not available: %v", model.Provider, model.ModelID, err)
+			continue
+		}
+		candidates = append(candidates, llm.Candidate{Provider: model.Provider, ModelID: model.ModelID, LLM: llmProvider})
 	}
 
-	return goai.NewLLMRequest(goai.NewRequestConfig(reqOptions...), llmProvider), nil
+	provider := llm.NewFallbackProvider(candidates, calls.fallbacks.attemptTimeout, recordFallback(calls.logger))
+	return goai.NewLLMRequest(goai.NewRequestConfig(reqOptions...), provider), provider, nil
 }
 
-func handleStreamingResponse(reqCtx *chatRequestContext, w http.ResponseWriter, flusher http.Flusher) error {
-	streamChan, err := reqCtx.llmCompletion.GenerateStream(reqCtx.ctx, reqCtx.messages)
+// buildLLMProvider sets up the provider of the requested model for the messages
+func buildLLMProvider(ctx context.Context, req QuestionRequest, messages []goai.LLMMessage, images [][]llm.Image, calls llmCalls) (goai.LLMProvider, error) {
+	var rateLimit func(ctx context.Context) error
+	if calls.rateLimits != nil {
+		rateLimit = func(ctx context.Context) error {
+			return calls.rateLimits.Wait(ctx, req.LLMProvider.Provider)
+		}
+	}
+
+	return llm.NewLLMBuilder(ctx).BuildProvider(llm.ProviderConfig{
+		Provider:       req.LLMProvider.Provider,
+		ModelID:        req.LLMProvider.ModelID,
+		Images:         modelImages(req, messages, images),
+		ResponseFormat: llmResponseFormat(req),
+		System:         calls.system,
+		Retries:        retryPolicy(calls.retries),
+		OnRetry:        recordRetries(req.LLMProvider, calls.logger),
+		RateLimit:      rateLimit,
+	})
+}
+
+// answeredBy returns the model that generated the answer, a fallback of the
+// requested one if that failed
+func (c *chatRequestContext) answeredBy() LLMProvider {
+	if c.provider == nil {
+		return c.req.LLMProvider
+	}
+	answered := c.provider.Answered()
+	return LLMProvider{Provider: answered.Provider, ModelID: answered.ModelID}
+}
+
+// handleStreamingResponse writes the answer to stream as it is generated,
+// chunked and paced according to settings and interleaved with the tool call
+// events received on toolCalls
+func handleStreamingResponse(reqCtx *chatRequestContext, stream eventWriter, settings StreamSettings, toolCalls <-chan streamEvent) error {
+	ctx, span := observability.StartSpan(reqCtx.ctx, "generate_response")
+	defer span.End()
+	observability.AddAttribute(ctx, "HandleAskStream.total_messages", len(reqCtx.messages))
+
+	metrics := startCompletionMetrics(reqCtx.req, true)
+	defer metrics.record(ctx)
+
+	reqCtx.startedAt = time.Now()
+	streamChan, err := reqCtx.llmCompletion.GenerateStream(ctx, reqCtx.messages)
 	if err != nil {
+		span.RecordError(err)
 		return err
 	}
+	metrics.answeredBy(reqCtx.answeredBy())
+	responses, stopReceiving := receiveStream(streamChan)
+	defer stopReceiving()
+
+	pacer := newDeltaPacer(reqCtx.ctx, stream, settings)
 
 	var fullResponse strings.Builder
-	for streamResp := range streamChan {
+	reportedTokens := 0
+	for {
+		var streamResp timedResponse
+		select {
+		case event := <-toolCalls:
+			// Text generated before the tool call goes out first
+			if err := pacer.Flush(); err != nil {
+				return err
+			}
+			if err := stream.WriteEvent(event.name, event.data); err != nil {
+				return err
+			}
+			continue
+		case resp, ok := <-responses:
+			if !ok {
+				return nil
+			}
+			streamResp = resp
+		case <-reqCtx.ctx.Done():
+			if generationCancelled(reqCtx.ctx) {
+				return finishCancelledStream(reqCtx, stream, pacer, metrics, fullResponse.String(), reportedTokens)
+			}
+			return context.Cause(reqCtx.ctx)
+		}
+
 		if streamResp.Error != nil {
+			if generationCancelled(reqCtx.ctx) {
+				return finishCancelledStream(reqCtx, stream, pacer, metrics, fullResponse.String(), reportedTokens)
+			}
+			span.RecordError(streamResp.Error)
 			return streamResp.Error
 		}
 
-		if err := writeStreamChunk(w, flusher, streamResp); err != nil {
-			return err
+		if streamResp.Text != "" {
+			metrics.chunk(streamResp.receivedAt)
+			if err := pacer.Write(streamResp.Text); err != nil {
+				return err
+			}
+			fullResponse.WriteString(streamResp.Text)
+			reportedTokens += streamResp.TokenCount
 		}
 
-		fullResponse.WriteString(streamResp.Text)
-
 		if streamResp.Done {
-			return saveAssistantResponse(reqCtx, fullResponse.String())
+			reqCtx.answeredAt = streamResp.receivedAt
+			metrics.finished(streamResp.receivedAt)
+			if err := pacer.Flush(); err != nil {
+				return err
+			}
+
+			usage := estimateStreamUsage(reqCtx.messages, fullResponse.String(), reportedTokens)
+			metrics.complete(completionStatusSuccess, usage.InputTokens, usage.OutputTokens)
+			message, err := saveAssistantResponse(reqCtx, fullResponse.String(), storage.MessageStatusComplete, usage.messageUsage())
+			if err != nil {
+				return err
+			}
+
+			if err := stream.WriteEvent(StreamEventUsage, usage); err != nil {
+				return err
+			}
+
+			return stream.WriteEvent(StreamEventMessageDone, StreamDone{
+				ChatUUID:     reqCtx.chat.UUID.String(),
+				Usage:        &usage,
+				LatencyMs:    message.LatencyMs,
+				FinishReason: message.FinishReason,
+				Provider:     message.Provider,
+				Model:        message.Model,
+			})
 		}
 	}
-	return nil
+}
+
+// finishCancelledStream saves the partial answer of a cancelled generation,
+// which may be empty, and tells the client the answer is over
+func finishCancelledStream(reqCtx *chatRequestContext, stream eventWriter, pacer *deltaPacer, metrics *completionMetrics, partialAnswer string, reportedTokens int) error {
+	observability.AddAttribute(reqCtx.ctx, "generation.cancelled", true)
+	usage := estimateStreamUsage(reqCtx.messages, partialAnswer, reportedTokens)
+	metrics.complete(completionStatusCancelled, usage.InputTokens, usage.OutputTokens)
+
+	if err := pacer.Flush(); err != nil {
+		return err
+	}
+
+	message, err := saveAssistantResponse(reqCtx, partialAnswer, storage.MessageStatusCancelled, usage.messageUsage())
+	if err != nil {
+		return err
+	}
+
+	return stream.WriteEvent(StreamEventMessageDone, StreamDone{
+		ChatUUID:     reqCtx.chat.UUID.String(),
+		Cancelled:    true,
+		Usage:        &usage,
+		LatencyMs:    message.LatencyMs,
+		FinishReason: message.FinishReason,
+		Provider:     message.Provider,
+		Model:        message.Model,
+	})
+}
+
+// estimateStreamUsage approximates the token usage of a streamed answer.
+// Output tokens counted by the provider are preferred over the estimate.
+func estimateStreamUsage(messages []goai.LLMMessage, answer string, reportedOutputTokens int) StreamUsage {
+	estimator := llm.NewHeuristicTokenEstimator()
+
+	usage := StreamUsage{OutputTokens: reportedOutputTokens}
+	for _, msg := range messages {
+		usage.InputTokens += llm.EstimateMessageTokens(estimator, msg)
+	}
+	if usage.OutputTokens == 0 {
+		usage.OutputTokens = estimator.EstimateTokens(answer)
+	}
+	return usage
 }
 
 func addRequestAttributes(ctx context.Context, req QuestionRequest) {
 	observability.AddAttribute(ctx, "question.length", len(req.Question))
 	observability.AddAttribute(ctx, "question.use_tools", req.SelectedTools)
-	observability.AddAttribute(ctx, "model.temperature", req.ModelSettings.Temperature)
+	if req.ModelSettings.Temperature != nil {
+		observability.AddAttribute(ctx, "model.temperature", *req.ModelSettings.Temperature)
+	}
 	observability.AddAttribute(ctx, "model.max_tokens", req.ModelSettings.MaxTokens)
-	observability.AddAttribute(ctx, "model.top_p", req.ModelSettings.TopP)
+	if req.ModelSettings.TopP != nil {
+		observability.AddAttribute(ctx, "model.top_p", *req.ModelSettings.TopP)
+	}
 	observability.AddAttribute(ctx, "model.top_k", req.ModelSettings.TopK)
 	observability.AddAttribute(ctx, "llm.provider", req.LLMProvider.Provider)
 	observability.AddAttribute(ctx, "llm.model_id", req.LLMProvider.ModelID)
@@ -271,60 +641,115 @@ func addRequestAttributes(ctx context.Context, req QuestionRequest) {
 	}
 }
 
-func initializeChatAndHistory(
+// chatHistory is the part of the chat sent to the model before the question
+type chatHistory struct {
+	messages []goai.LLMMessage
+	// images are the ones of the user messages, see modelImages
+	images [][]llm.Image
+	// truncated is the number of left out messages
+	truncated int
+	// system is the text of the system message put in front of the messages,
+	// see systemPromptMessage
+	system string
+}
+
+// initializeChatHistory returns the messages of the active branch of the chat
+// that fit into the model's context window and the images of their user
+// messages. A non-empty systemPrompt replaces the prompt templates, see
+// chatSystemPrompt. The summary of the left out messages is sent along with
+// the system prompt.
+func initializeChatHistory(
 	ctx context.Context,
 	req QuestionRequest,
-	historyStorage goai.ChatHistoryStorage,
+	chat *storage.Chat,
+	systemPrompt string,
+	historyStorage storage.ChatHistoryStorage,
 	logger *log.Logger,
 	mcpClient *mcp.Client,
-) (*goai.ChatHistory, []goai.LLMMessage, error) {
-	chat, err := getOrInitializeChat(req, historyStorage, logger, ctx)
+	summarizer *llm.Summarizer,
+) (chatHistory, error) {
+	branch, err := withAttachmentData(ctx, chat.UUID, chat.ActiveBranch(), historyStorage)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get or create chat history: %w", err)
+		return chatHistory{}, err
 	}
 
-	messages, err := getTruncatedChatHistory(ctx, chat.UUID, historyStorage)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to retrieve chat history: %w", err)
+	// The stored summary is sent whenever older messages are left out
+	var storedSummary string
+	if summarizer != nil {
+		storedSummary = chat.Summary
+	}
+	estimator := llm.NewHeuristicTokenEstimator()
+	budget := historyBudget(req, estimator)
+	if system := systemPromptMessage(systemPrompt, storedSummary); system.Text != "" {
+		budget = max(0, budget-llm.EstimateMessageTokens(estimator, system))
 	}
+	messages, truncated := getTruncatedChatHistory(branch, budget, estimator)
+	observability.AddAttribute(ctx, "chat_history.truncated_messages", truncated)
 
-	if len(messages) == 0 {
+	// Images are sent again with the questions they were attached to
+	var images [][]llm.Image
+	n := 0
+	for _, msg := range branch {
+		if msg.Role == goai.SystemRole {
+			continue
+		}
+		if n >= truncated && msg.Role == goai.UserRole {
+			images = append(images, attachmentImages(msg.Attachments))
+		}
+		n++
+	}
+	history := chatHistory{messages: messages, images: images, truncated: truncated}
+
+	// Older messages that didn't fit are carried over as a summary
+	summary := summarizeDroppedMessages(ctx, chat, truncated, summarizer, historyStorage, logger)
+
+	// The chat's own system prompt replaces the prompt templates on every turn
+	if systemPrompt == "" && len(messages) == 0 {
 		promptMessages, err := buildMessagesFromPromptTemplates(ctx, mcpClient, req)
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to build prompt templates: %w", err)
+			return chatHistory{}, fmt.Errorf("failed to build prompt templates: %w", err)
 		}
-		messages = append(messages, promptMessages...)
+		history.messages = append(promptMessages, history.messages...)
 	}
 
-	return chat, messages, nil
+	if systemPrompt != "" || summary != "" {
+		system := systemPromptMessage(systemPrompt, summary)
+		history.system = system.Text
+		history.messages = append([]goai.LLMMessage{system}, history.messages...)
+	}
+
+	return history, nil
 }
 
 func addUserMessage(
 	ctx context.Context,
 	messages []goai.LLMMessage,
 	question string,
+	attachments []storage.Attachment,
 	chatUUID uuid.UUID,
-	historyStorage goai.ChatHistoryStorage,
+	historyStorage storage.ChatHistoryStorage,
 ) ([]goai.LLMMessage, error) {
-	userMessage := goai.LLMMessage{
-		Role: goai.UserRole,
-		Text: question,
-	}
-	messages = append(messages, userMessage)
-
-	err := historyStorage.AddMessage(ctx, chatUUID, goai.ChatHistoryMessage{
-		LLMMessage:  userMessage,
-		GeneratedAt: time.Now(),
+	userMessage, err := historyStorage.AddChatMessage(ctx, chatUUID, storage.Message{
+		ChatHistoryMessage: goai.ChatHistoryMessage{
+			LLMMessage: goai.LLMMessage{
+				Role: goai.UserRole,
+				Text: question,
+			},
+			GeneratedAt: time.Now(),
+		},
+		Attachments: attachments,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to add user message to chat history: %w", err)
 	}
 
-	return messages, nil
+	// The stored message keeps the metadata of the attachments only
+	userMessage.Attachments = attachments
+	return append(messages, modelMessage(userMessage)), nil
 }
 
-func setupStreamingHeaders(w http.ResponseWriter, chatUUID string) error {
-	w.Header().Set("Content-Type", "application/json")
+func setupStreamingHeaders(w http.ResponseWriter, contentType string, chatUUID string, truncatedMessages int) error {
+	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
@@ -333,178 +758,185 @@ func setupStreamingHeaders(w http.ResponseWriter, chatUUID string) error {
 	if chatUUID != "" {
 		w.Header().Set("X-MKit-Chat-UUID", chatUUID)
 	}
+	w.Header().Set("X-MKit-Truncated-Messages", strconv.Itoa(truncatedMessages))
 
 	return nil
 }
 
-func writeStreamChunk(w http.ResponseWriter, flusher http.Flusher, streamResp goai.StreamingLLMResponse) error {
-	response := struct {
-		Content string `json:"content"`
-		MetaKey string `json:"meta_key,omitempty"`
-		Done    bool   `json:"done,omitempty"`
-	}{
-		Content: streamResp.Text,
-		Done:    streamResp.Done,
-	}
-
-	chunkData, err := json.Marshal(response)
+// saveAssistantResponse adds the answer to the chat history together with the
+// tool calls made for it, its usage and latency, and returns the saved message.
+// The answer is saved even if the request has been cancelled in the meantime.
+func saveAssistantResponse(reqCtx *chatRequestContext, response string, status storage.MessageStatus, usage *storage.MessageUsage) (storage.Message, error) {
+	answeredBy := reqCtx.answeredBy()
+	settings := chatSettings(reqCtx.req)
+	settings.Provider, settings.ModelID = answeredBy.Provider, answeredBy.ModelID
+
+	answeredAt := reqCtx.answeredAt
+	if answeredAt.IsZero() {
+		answeredAt = time.Now()
+	}
+
+	message, err := reqCtx.historyStorage.AddChatMessage(context.WithoutCancel(reqCtx.ctx), reqCtx.chat.UUID, storage.Message{
+		ChatHistoryMessage: goai.ChatHistoryMessage{
+			LLMMessage: goai.LLMMessage{
+				Role: goai.AssistantRole,
+				Text: response,
+			},
+			GeneratedAt: time.Now(),
+		},
+		Status:       status,
+		Provider:     answeredBy.Provider,
+		Model:        answeredBy.ModelID,
+		ToolCalls:    reqCtx.toolCalls.Calls(),
+		Usage:        usage,
+		LatencyMs:    answeredAt.Sub(reqCtx.startedAt).Milliseconds(),
+		FinishReason: finishReason(status),
+		Settings:     settings,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to marshal stream chunk: %w", err)
+		return storage.Message{}, fmt.Errorf("failed to add assistant message to history: %w", err)
 	}
+	return message, nil
+}
 
-	if _, err := fmt.Fprintf(w, "%s\n", chunkData); err != nil {
-		return fmt.Errorf("error writing response: %w", err)
+// finishReason tells why the generation of an answer ended. The providers
+// don't report their stop reason, so only a cancellation is known.
+func finishReason(status storage.MessageStatus) storage.FinishReason {
+	if status == storage.MessageStatusCancelled {
+		return storage.FinishReasonCancelled
 	}
-
-	flusher.Flush()
-	return nil
+	return ""
 }
 
-func saveAssistantResponse(reqCtx *chatRequestContext, response string) error {
-	err := reqCtx.historyStorage.AddMessage(reqCtx.ctx, reqCtx.chat.UUID, goai.ChatHistoryMessage{
-		LLMMessage: goai.LLMMessage{
-			Role: goai.AssistantRole,
-			Text: response,
-		},
-		GeneratedAt: time.Now(),
-	})
-	if err != nil {
-		return fmt.Errorf("failed to add assistant message to history: %w", err)
-	}
-	return nil
+// answerQuestion generates the answer to a prepared question. A cancelled
+// generation is not an error, it is saved as an empty cancelled answer and
+// returns a Response with Cancelled set.
+func answerQuestion(reqCtx *chatRequestContext, structuredOutputRetries int) (Response, error) {
+	generateCtx, generateSpan := observability.StartSpan(reqCtx.ctx, "generate_response")
+	defer generateSpan.End()
+
+	response, err := generateSynchronousResponse(generateCtx, reqCtx, structuredOutputRetries)
+	if err != nil && generationCancelled(generateCtx) {
+		// The provider doesn't return a partial answer, so the cancelled one is empty
+		observability.AddAttribute(generateCtx, "generation.cancelled", true)
+		message, err := saveAssistantResponse(reqCtx, "", storage.MessageStatusCancelled, nil)
+		if err != nil {
+			return Response{}, err
+		}
+		return Response{
+			ChatUUID:          reqCtx.chat.UUID,
+			TruncatedMessages: reqCtx.truncatedMessages,
+			Cancelled:         true,
+			LatencyMs:         message.LatencyMs,
+			FinishReason:      message.FinishReason,
+			Provider:          message.Provider,
+			Model:             message.Model,
+		}, nil
+	}
+	return response, err
 }
 
-func generateSynchronousResponse(ctx context.Context, reqCtx *chatRequestContext) (*goai.LLMResponse, error) {
+// generateSynchronousResponse generates and saves the answer. An answer that
+// doesn't match the requested response format is retried up to
+// structuredOutputRetries times, the last one is saved with
+// storage.MessageStatusInvalidOutput and returned together with
+// ErrInvalidStructuredOutput.
+func generateSynchronousResponse(ctx context.Context, reqCtx *chatRequestContext, structuredOutputRetries int) (Response, error) {
 	observability.AddAttribute(ctx, "HandleAsk.total_messages", len(reqCtx.messages))
 
-	timer := prometheus.NewTimer(observability.LLMCompletionDuration.WithLabelValues(
-		reqCtx.req.LLMProvider.Provider,
-		reqCtx.req.LLMProvider.ModelID,
-		"success",
-	))
-	defer timer.ObserveDuration()
-
-	// Track in-flight requests
-	inFlightMetric := observability.LLMCompletionInFlight.WithLabelValues(
-		reqCtx.req.LLMProvider.Provider,
-		reqCtx.req.LLMProvider.ModelID,
-	)
-	inFlightMetric.Inc()
-	defer inFlightMetric.Dec()
+	metrics := startCompletionMetrics(reqCtx.req, false)
+	defer metrics.record(ctx)
 
+	reqCtx.startedAt = time.Now()
 	response, err := reqCtx.llmCompletion.Generate(ctx, reqCtx.messages)
-	if err != nil {
-		// Record failed completion duration with "error" status
-		observability.LLMCompletionDuration.WithLabelValues(
-			reqCtx.req.LLMProvider.Provider,
-			reqCtx.req.LLMProvider.ModelID,
-			"error",
-		).Observe(timer.ObserveDuration().Seconds())
 
-		return nil, fmt.Errorf("failed to generate response: %w", err)
+	var output json.RawMessage
+	var outputErr error
+	if err == nil && reqCtx.req.ResponseFormat != nil {
+		response, output, outputErr = generateStructuredOutput(ctx, reqCtx, response, structuredOutputRetries)
+		if !errors.Is(outputErr, ErrInvalidStructuredOutput) {
+			err = outputErr
+		}
 	}
-
-	// Update metrics
-	observability.TokensInputTotal.WithLabelValues(
-		reqCtx.req.LLMProvider.Provider,
-		reqCtx.req.LLMProvider.ModelID,
-	).Add(float64(response.TotalInputToken))
-
-	observability.TokensOutputTotal.WithLabelValues(
-		reqCtx.req.LLMProvider.Provider,
-		reqCtx.req.LLMProvider.ModelID,
-	).Add(float64(response.TotalOutputToken))
-
-	// Add response to chat history
-	err = saveAssistantResponse(reqCtx, response.Text)
 	if err != nil {
-		return nil, err
+		if generationCancelled(ctx) {
+			metrics.complete(completionStatusCancelled, 0, 0)
+		}
+		return Response{}, fmt.Errorf("failed to generate response: %w", err)
 	}
+	metrics.answeredBy(reqCtx.answeredBy())
+	metrics.complete(completionStatusSuccess, response.TotalInputToken, response.TotalOutputToken)
 
-	// Add attributes for observability
-	observability.AddAttribute(ctx, "response.input_tokens", response.TotalInputToken)
-	observability.AddAttribute(ctx, "response.output_tokens", response.TotalOutputToken)
-
-	return &response, nil
+	// Add response to chat history
+	status := storage.MessageStatusComplete
+	if outputErr != nil {
+		status = storage.MessageStatusInvalidOutput
+	}
+	message, err := saveAssistantResponse(reqCtx, response.Text, status, &storage.MessageUsage{
+		InputTokens:  response.TotalInputToken,
+		OutputTokens: response.TotalOutputToken,
+	})
+	if err != nil {
+		return Response{}, err
+	}
+
+	return Response{
+		ChatUUID:          reqCtx.chat.UUID,
+		Answer:            response.Text,
+		InputToken:        response.TotalInputToken,
+		OutputToken:       response.TotalOutputToken,
+		TruncatedMessages: reqCtx.truncatedMessages,
+		LatencyMs:         message.LatencyMs,
+		FinishReason:      message.FinishReason,
+		Output:            output,
+		Provider:          message.Provider,
+		Model:             message.Model,
+	}, outputErr
 }
 
 // Helper Function Implementations
-func getOrInitializeChat(req QuestionRequest, historyStorage goai.ChatHistoryStorage, logger *log.Logger, ctx context.Context) (*goai.ChatHistory, error) {
-	var chat *goai.ChatHistory
-	var err error
 
-	if req.ChatUUID == uuid.Nil {
-		chat, err = historyStorage.CreateChat(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create chat")
-		}
-	} else {
-		logger.Printf("ChatUUID: %s", req.ChatUUID)
-		chat, err = historyStorage.GetChat(ctx, req.ChatUUID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get chat")
-		}
+// getOwnedChat loads the requested chat if the authenticated caller owns it
+func getOwnedChat(ctx context.Context, chatUUID uuid.UUID, historyStorage storage.ChatHistoryStorage, logger *log.Logger) (*storage.Chat, error) {
+	logger.Printf("ChatUUID: %s", chatUUID)
+	chat, err := historyStorage.GetOwnedChat(ctx, chatUUID, auth.SubjectFromContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat: %w", err)
 	}
-
 	return chat, nil
 }
 
-func getTruncatedChatHistory(ctx context.Context, chatUUID uuid.UUID, historyStorage goai.ChatHistoryStorage) ([]goai.LLMMessage, error) {
-	// Retrieve all messages for the chat
-	chatHistory, err := historyStorage.GetChat(ctx, chatUUID)
+// createChat creates a new chat owned by the authenticated caller with the
+// system prompt and the settings of the question
+func createChat(ctx context.Context, req QuestionRequest, deps ChatDependencies) (*storage.Chat, error) {
+	options, err := chatOptions(req.SystemPrompt, req.Persona, deps.SystemPrompts)
 	if err != nil {
 		return nil, err
 	}
+	options.Settings = chatSettings(req)
 
-	if chatHistory == nil {
-		return []goai.LLMMessage{}, nil
-	}
-
-	messages := chatHistory.Messages
-	var result []goai.LLMMessage
-
-	// Determine the starting index for truncation
-	startIdx := 0
-	if len(messages) > MaxChatHistoryMessages {
-		startIdx = len(messages) - MaxChatHistoryMessages
-	}
-
-	// Ensure System messages are always included
-	for _, msg := range messages {
-		if msg.Role == goai.SystemRole {
-			result = append(result, goai.LLMMessage{
-				Role: msg.Role,
-				Text: msg.Text,
-			})
-		}
-	}
-
-	// Convert Message to goai.LLMMessage
-	for _, msg := range messages[startIdx:] {
-		if msg.Role != goai.SystemRole {
-			llmMsg := goai.LLMMessage{
-				Role: msg.Role,
-				Text: msg.Text,
-			}
-			result = append(result, llmMsg)
-		}
+	chat, err := deps.HistoryStorage.CreateOwnedChat(ctx, auth.SubjectFromContext(ctx), options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chat")
 	}
-
-	return result, nil
+	return chat, nil
 }
 
+// buildMessagesFromPromptTemplates fetches the prompt for the question from the
+// MCP server
 func buildMessagesFromPromptTemplates(ctx context.Context, sseClient *mcp.Client, req QuestionRequest) ([]goai.LLMMessage, error) {
-	promptName := "llm_general"
-	if len(req.SelectedTools) > 0 {
-		promptName = "llm_with_tools_v2"
-	}
+	promptName := promptTemplateName(req)
+	arguments := map[string]string{"question": req.Question}
 	log.Printf("Fetching prompt: %s", promptName)
 
+	rawArguments, err := json.Marshal(arguments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode prompt arguments: %w", err)
+	}
+
 	promptMessages, err := sseClient.GetPrompt(ctx, mcp.GetPromptParams{
-		Name: promptName,
-		Arguments: json.RawMessage(`{
-        "question": ` + fmt.Sprintf("%q", req.Question) + `
-    }`),
+		Name:      promptName,
+		Arguments: rawArguments,
 	})
 
 	if err != nil {
@@ -521,22 +953,30 @@ func buildMessagesFromPromptTemplates(ctx context.Context, sseClient *mcp.Client
 	return messages, nil
 }
 
+// promptTemplateName picks the prompt for the question by whether it may use tools
+func promptTemplateName(req QuestionRequest) string {
+	if len(req.SelectedTools) > 0 {
+		return "llm_with_tools_v2"
+	}
+	return "llm_general"
+}
+
 func prepareLLMRequestOptions(req QuestionRequest) []goai.RequestOption {
 	reqOptions := []goai.RequestOption{
-		goai.WithMaxToken(1000),
+		goai.WithMaxToken(DefaultMaxTokens),
 		goai.WithTemperature(0.5),
 	}
 
-	if req.ModelSettings.Temperature != 0 {
-		reqOptions = append(reqOptions, goai.WithTemperature(req.ModelSettings.Temperature))
+	if req.ModelSettings.Temperature != nil {
+		reqOptions = append(reqOptions, goai.WithTemperature(*req.ModelSettings.Temperature))
 	}
 
 	if req.ModelSettings.MaxTokens != 0 {
 		reqOptions = append(reqOptions, goai.WithMaxToken(req.ModelSettings.MaxTokens))
 	}
 
-	if req.ModelSettings.TopP != 0 {
-		reqOptions = append(reqOptions, goai.WithTopP(req.ModelSettings.TopP))
+	if req.ModelSettings.TopP != nil {
+		reqOptions = append(reqOptions, goai.WithTopP(*req.ModelSettings.TopP))
 	}
 
 	if req.ModelSettings.TopK != 0 {
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shaharia-lab/mcp-kit/internal/storage"
+)
+
+// DefaultChatPageSize is the number of chats listed when no limit is given
+const DefaultChatPageSize = 50
+
+// MaxChatPageSize is the maximum number of chats listed at once
+const MaxChatPageSize = 200
+
+// Projections of the chat list
+const (
+	ChatListViewFull    = "full"
+	ChatListViewSummary = "summary"
+)
+
+// ChatListResponse is a page of chats, either []storage.Chat or []storage.ChatSummary
+// depending on the requested view. NextCursor is empty on the last page.
+type ChatListResponse struct {
+	Chats      any    `json:"chats"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// chatCursor is the JSON behind the opaque next_cursor. It carries the sort
+// order so it can't be used to continue a differently sorted list.
+type chatCursor struct {
+	SortBy    storage.ChatSortField `json:"s"`
+	Ascending bool                  `json:"a"`
+	Time      time.Time             `json:"t"`
+	UUID      uuid.UUID             `json:"u"`
+}
+
+// parseChatListQuery reads the query parameters of the chat list:
+// limit, cursor, sort (created_at or updated_at), order (asc or desc), from
+// and to (RFC 3339 bounds of the sort field), archived, model, tool (may be
+// repeated) and view (full or summary)
+func parseChatListQuery(params url.Values) (storage.ChatListQuery, string, error) {
+	query := storage.ChatListQuery{
+		SortBy: storage.SortByCreatedAt,
+		Limit:  DefaultChatPageSize,
+		Model:  params.Get("model"),
+		Tools:  params["tool"],
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 1 || parsed > MaxChatPageSize {
+			return query, "", fmt.Errorf("limit must be between 1 and %d", MaxChatPageSize)
+		}
+		query.Limit = parsed
+	}
+
+	switch sortBy := storage.ChatSortField(params.Get("sort")); sortBy {
+	case "":
+	case storage.SortByCreatedAt, storage.SortByUpdatedAt:
+		query.SortBy = sortBy
+	default:
+		return query, "", errors.New("sort must be created_at or updated_at")
+	}
+
+	switch params.Get("order") {
+	case "", "desc":
+	case "asc":
+		query.Ascending = true
+	default:
+		return query, "", errors.New("order must be asc or desc")
+	}
+
+	for name, bound := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		if value := params.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return query, "", fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+			}
+			*bound = parsed
+		}
+	}
+
+	if archived := params.Get("archived"); archived != "" {
+		parsed, err := strconv.ParseBool(archived)
+		if err != nil {
+			return query, "", errors.New("archived must be true or false")
+		}
+		query.Archived = &parsed
+	}
+
+	if cursor := params.Get("cursor"); cursor != "" {
+		after, err := decodeChatCursor(query, cursor)
+		if err != nil {
+			return query, "", err
+		}
+		query.After = &after
+	}
+
+	view := params.Get("view")
+	switch view {
+	case "":
+		view = ChatListViewFull
+	case ChatListViewFull, ChatListViewSummary:
+	default:
+		return query, "", errors.New("view must be full or summary")
+	}
+
+	return query, view, nil
+}
+
+func encodeChatCursor(query storage.ChatListQuery, next storage.ChatCursor) string {
+	data, _ := json.Marshal(chatCursor{
+		SortBy:    query.SortBy,
+		Ascending: query.Ascending,
+		Time:      next.Time,
+		UUID:      next.UUID,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeChatCursor(query storage.ChatListQuery, cursor string) (storage.ChatCursor, error) {
+	var decoded chatCursor
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(data, &decoded)
+	}
+	if err != nil {
+		return storage.ChatCursor{}, errors.New("invalid cursor")
+	}
+
+	if decoded.SortBy != query.SortBy || decoded.Ascending != query.Ascending {
+		return storage.ChatCursor{}, errors.New("cursor belongs to a list with another sort order")
+	}
+	return storage.ChatCursor{Time: decoded.Time, UUID: decoded.UUID}, nil
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/shaharia-lab/mcp-kit/internal/storage"
+)
+
+// inheritChatSettings fills in the settings the question leaves out from the
+// settings of its chat. A question overrides the model by naming one, the tools
+// by listing them, even none, and each parameter by setting it, temperature and
+// top p even to zero.
+func inheritChatSettings(req *QuestionRequest, settings *storage.ChatSettings) {
+	if settings == nil {
+		return
+	}
+
+	if req.LLMProvider.Provider == "" && req.LLMProvider.ModelID == "" {
+		req.LLMProvider = LLMProvider{Provider: settings.Provider, ModelID: settings.ModelID}
+	}
+	if req.SelectedTools == nil {
+		req.SelectedTools = append([]string{}, settings.SelectedTools...)
+	}
+
+	modelSettings := &req.ModelSettings
+	if modelSettings.Temperature == nil {
+		modelSettings.Temperature = settings.Temperature
+	}
+	if modelSettings.MaxTokens == 0 {
+		modelSettings.MaxTokens = settings.MaxTokens
+	}
+	if modelSettings.TopP == nil {
+		modelSettings.TopP = settings.TopP
+	}
+	if modelSettings.TopK == 0 {
+		modelSettings.TopK = settings.TopK
+	}
+}
+
+// chatSettings returns the settings the question is answered with
+func chatSettings(req QuestionRequest) *storage.ChatSettings {
+	return &storage.ChatSettings{
+		Provider:      req.LLMProvider.Provider,
+		ModelID:       req.LLMProvider.ModelID,
+		SelectedTools: append([]string{}, req.SelectedTools...),
+		Temperature:   req.ModelSettings.Temperature,
+		MaxTokens:     req.ModelSettings.MaxTokens,
+		TopP:          req.ModelSettings.TopP,
+		TopK:          req.ModelSettings.TopK,
+	}
+}
+
+// validateChatSettings checks settings set on a chat directly
+func validateChatSettings(settings *storage.ChatSettings) error {
+	if settings.Provider == "" || settings.ModelID == "" {
+		return errors.New("settings.provider and settings.model_id are required")
+	}
+	if !getLLMProviders().IsSupported(settings.Provider, settings.ModelID) {
+		return errors.New("LLM provider or model is not supported")
+	}
+	if negative(settings.Temperature) || settings.MaxTokens < 0 || negative(settings.TopP) || settings.TopK < 0 {
+		return errors.New("settings parameters cannot be negative")
+	}
+	return nil
+}
+
+func negative(parameter *float64) bool {
+	return parameter != nil && *parameter < 0
+}
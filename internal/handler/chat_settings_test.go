@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/shaharia-lab/mcp-kit/internal/storage"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestInheritChatSettings(t *testing.T) {
+	settings := &storage.ChatSettings{
+		Provider:      "anthropic",
+		ModelID:       "claude-3-5-sonnet-latest",
+		SelectedTools: []string{"get_weather"},
+		Temperature:   ptr(0.7),
+		MaxTokens:     2000,
+		TopP:          ptr(0.9),
+		TopK:          50,
+	}
+
+	tests := []struct {
+		name      string
+		request   string
+		want      ModelSettings
+		wantTools []string
+		wantModel string
+	}{
+		{
+			name:      "nothing set",
+			request:   `{"question": "Hi"}`,
+			want:      ModelSettings{Temperature: ptr(0.7), MaxTokens: 2000, TopP: ptr(0.9), TopK: 50},
+			wantTools: []string{"get_weather"},
+			wantModel: "claude-3-5-sonnet-latest",
+		},
+		{
+			name:      "zero temperature and top p",
+			request:   `{"question": "Hi", "modelSettings": {"temperature": 0, "topP": 0}}`,
+			want:      ModelSettings{Temperature: ptr(0.0), MaxTokens: 2000, TopP: ptr(0.0), TopK: 50},
+			wantTools: []string{"get_weather"},
+			wantModel: "claude-3-5-sonnet-latest",
+		},
+		{
+			name:      "no tools",
+			request:   `{"question": "Hi", "selectedTools": []}`,
+			want:      ModelSettings{Temperature: ptr(0.7), MaxTokens: 2000, TopP: ptr(0.9), TopK: 50},
+			wantTools: []string{},
+			wantModel: "claude-3-5-sonnet-latest",
+		},
+		{
+			name:      "own model and parameters",
+			request:   `{"question": "Hi", "llmProvider": {"provider": "openai", "modelId": "gpt-4o"}, "modelSettings": {"temperature": 0.2, "maxTokens": 100, "topK": 5}}`,
+			want:      ModelSettings{Temperature: ptr(0.2), MaxTokens: 100, TopP: ptr(0.9), TopK: 5},
+			wantTools: []string{"get_weather"},
+			wantModel: "gpt-4o",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req QuestionRequest
+			if err := json.Unmarshal([]byte(tt.request), &req); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+
+			inheritChatSettings(&req, settings)
+
+			got := req.ModelSettings
+			if *got.Temperature != *tt.want.Temperature || got.MaxTokens != tt.want.MaxTokens || *got.TopP != *tt.want.TopP || got.TopK != tt.want.TopK {
+				t.Errorf("ModelSettings = temperature %v, max tokens %d, top p %v, top k %d, want %v, %d, %v, %d",
+					*got.Temperature, got.MaxTokens, *got.TopP, got.TopK,
+					*tt.want.Temperature, tt.want.MaxTokens, *tt.want.TopP, tt.want.TopK)
+			}
+			if req.SelectedTools == nil || !slices.Equal(req.SelectedTools, tt.wantTools) {
+				t.Errorf("SelectedTools = %#v, want %#v", req.SelectedTools, tt.wantTools)
+			}
+			if req.LLMProvider.ModelID != tt.wantModel {
+				t.Errorf("ModelID = %q, want %q", req.LLMProvider.ModelID, tt.wantModel)
+			}
+		})
+	}
+}
+
+func TestChatSettingsKeepZeroParameters(t *testing.T) {
+	req := QuestionRequest{ModelSettings: ModelSettings{Temperature: ptr(0.0), TopP: ptr(0.0)}}
+
+	data, err := json.Marshal(chatSettings(req))
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var stored storage.ChatSettings
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	if stored.Temperature == nil || *stored.Temperature != 0 || stored.TopP == nil || *stored.TopP != 0 {
+		t.Errorf("stored settings = %s, want a temperature and top p of 0", data)
+	}
+	if stored.SelectedTools == nil {
+		t.Errorf("stored settings = %s, want an empty tool selection", data)
+	}
+}
@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shaharia-lab/goai"
+	"github.com/shaharia-lab/mcp-kit/internal/observability"
+)
+
+// MaxCompareModels is the maximum number of models a question is compared across
+const MaxCompareModels = 8
+
+// MaxCompareRequestSize is the maximum size of a compare request body in bytes
+const MaxCompareRequestSize = 1 << 20
+
+// Event types sent on a comparison stream
+const (
+	CompareEventDelta  = "compare.delta"
+	CompareEventResult = "compare.result"
+	CompareEventDone   = "compare.done"
+)
+
+// CompareRequest asks the same question to several models. Every model gets
+// the same prompt and tools. The answers are not saved to any chat.
+type CompareRequest struct {
+	Question      string        `json:"question"`
+	SelectedTools []string      `json:"selectedTools"`
+	ModelSettings ModelSettings `json:"modelSettings"`
+	Models        []LLMProvider `json:"models"`
+	// Stream sends the answers as they are generated, multiplexed on one stream
+	Stream bool `json:"stream,omitempty"`
+}
+
+// CompareResult is the answer of one model, or why it failed. Index is the
+// position of the model in the request. Streamed answers have estimated
+// token counts, see StreamUsage.
+type CompareResult struct {
+	Type            string `json:"type"`
+	Index           int    `json:"index"`
+	Provider        string `json:"provider"`
+	ModelID         string `json:"modelId"`
+	Answer          string `json:"answer,omitempty"`
+	InputToken      int    `json:"input_token"`
+	OutputToken     int    `json:"output_token"`
+	TokensEstimated bool   `json:"tokens_estimated,omitempty"`
+	LatencyMs       int64  `json:"latency_ms"`
+	Error           string `json:"error,omitempty"`
+}
+
+// CompareResponse is returned for comparisons that aren't streamed, with the
+// results in the order of the models in the request
+type CompareResponse struct {
+	Results []CompareResult `json:"results"`
+}
+
+// CompareDelta is the payload of a compare.delta event
+type CompareDelta struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Content string `json:"content"`
+}
+
+// CompareDone is the payload of the compare.done event, sent once every model has answered
+type CompareDone struct {
+	Type       string `json:"type"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// questionRequest returns the question as asked to the model
+func (c CompareRequest) questionRequest(model LLMProvider) QuestionRequest {
+	return QuestionRequest{
+		Question:      c.Question,
+		SelectedTools: c.SelectedTools,
+		ModelSettings: c.ModelSettings,
+		LLMProvider:   model,
+	}
+}
+
+func validateCompareRequest(req CompareRequest) error {
+	if req.Question == "" {
+		return errors.New("question cannot be empty")
+	}
+	if len(req.Models) < 2 || len(req.Models) > MaxCompareModels {
+		return fmt.Errorf("between 2 and %d models are required", MaxCompareModels)
+	}
+
+	seen := make(map[LLMProvider]bool)
+	for i, model := range req.Models {
+		if seen[model] {
+			return fmt.Errorf("models[%d] is listed twice", i)
+		}
+		seen[model] = true
+
+		if err := validateRequest(req.questionRequest(model)); err != nil {
+			return fmt.Errorf("models[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// jsonLinesWriter writes the payload of every event as a line of JSON. The
+// payloads of the comparison stream carry their event type themselves.
+type jsonLinesWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// WriteEvent implements eventWriter interface
+func (j *jsonLinesWriter) WriteEvent(event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %w", event, err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err := fmt.Fprintf(j.w, "%s\n", payload); err != nil {
+		return fmt.Errorf("error writing response: %w", err)
+	}
+
+	j.flusher.Flush()
+	return nil
+}
+
+// CompareHandler Handler to ask one question to several models at the same
+// time. Streamed comparisons are sent as Server-Sent Events when the client
+// accepts text/event-stream, as JSON lines otherwise.
+func CompareHandler(deps ChatDependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := observability.StartSpan(r.Context(), "handle_compare")
+		defer span.End()
+
+		var req CompareRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxCompareRequestSize)).Decode(&req); err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, "Invalid request body: "+err.Error(), err, ctx)
+			return
+		}
+		if err := validateCompareRequest(req); err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, err.Error(), err, ctx)
+			return
+		}
+		observability.AddAttribute(ctx, "compare.models", len(req.Models))
+		observability.AddAttribute(ctx, "compare.stream", req.Stream)
+
+		// The prompt doesn't depend on the model, so it is built once for all of them
+		messages, err := buildMessagesFromPromptTemplates(ctx, deps.MCPClient, req.questionRequest(req.Models[0]))
+		if err != nil {
+			writeErrorResponse(w, http.StatusInternalServerError, "Failed to build prompt templates", err, ctx)
+			return
+		}
+		messages = append(messages, goai.LLMMessage{Role: goai.UserRole, Text: req.Question})
+
+		if !req.Stream {
+			results := make([]CompareResult, len(req.Models))
+			var wg sync.WaitGroup
+			for i := range req.Models {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					results[i] = compareModel(ctx, req, i, messages, deps, nil)
+				}()
+			}
+			wg.Wait()
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(CompareResponse{Results: results})
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		var stream eventWriter = &jsonLinesWriter{w: w, flusher: flusher}
+		contentType := "application/x-ndjson"
+		if acceptsEventStream(r.Header.Values("Accept")) {
+			stream = &sseStreamWriter{w: w, flusher: flusher}
+			contentType = contentTypeEventStream
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		start := time.Now()
+		var wg sync.WaitGroup
+		for i := range req.Models {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result := compareModel(ctx, req, i, messages, deps, stream)
+				if err := stream.WriteEvent(CompareEventResult, result); err != nil {
+					deps.Logger.Printf("Failed to write comparison result: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if err := stream.WriteEvent(CompareEventDone, CompareDone{Type: "done", DurationMs: time.Since(start).Milliseconds()}); err != nil {
+			deps.Logger.Printf("Failed to write end of comparison: %v", err)
+		}
+	}
+}
+
+// compareModel asks the question to the model at index of the request. With a
+// stream the answer is written to it as it is generated.
+func compareModel(ctx context.Context, req CompareRequest, index int, messages []goai.LLMMessage, deps ChatDependencies, stream eventWriter) CompareResult {
+	model := req.Models[index]
+
+	ctx, span := observability.StartSpan(ctx, "compare_model")
+	defer span.End()
+	observability.AddAttribute(ctx, "llm.provider", model.Provider)
+	observability.AddAttribute(ctx, "llm.model_id", model.ModelID)
+
+	result := CompareResult{Type: "result", Index: index, Provider: model.Provider, ModelID: model.ModelID}
+	questionReq := req.questionRequest(model)
+
+	// Every model answers for itself, so none falls back to another
+	llmCompletion, _, err := setupLLMCompletion(ctx, questionReq, messages, nil, deps.ToolsProvider, nil, llmCalls{retries: deps.Retries, logger: deps.Logger})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	start := time.Now()
+	if stream == nil {
+		result.Answer, result.InputToken, result.OutputToken, err = generateComparison(ctx, questionReq, llmCompletion, messages)
+	} else {
+		result.Answer, result.InputToken, result.OutputToken, err = streamComparison(ctx, questionReq, llmCompletion, messages, index, stream)
+		result.TokensEstimated = true
+	}
+	result.LatencyMs = time.Since(start).Milliseconds()
+
+	if err != nil {
+		span.RecordError(err)
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func generateComparison(ctx context.Context, req QuestionRequest, llmCompletion *goai.LLMRequest, messages []goai.LLMMessage) (string, int, int, error) {
+	metrics := startCompletionMetrics(req, false)
+	defer metrics.record(ctx)
+
+	response, err := llmCompletion.Generate(ctx, messages)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	metrics.complete(completionStatusSuccess, response.TotalInputToken, response.TotalOutputToken)
+	return response.Text, response.TotalInputToken, response.TotalOutputToken, nil
+}
+
+func streamComparison(ctx context.Context, req QuestionRequest, llmCompletion *goai.LLMRequest, messages []goai.LLMMessage, index int, stream eventWriter) (string, int, int, error) {
+	metrics := startCompletionMetrics(req, true)
+	defer metrics.record(ctx)
+
+	streamChan, err := llmCompletion.GenerateStream(ctx, messages)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	var answer strings.Builder
+	reportedTokens := 0
+	for resp := range streamChan {
+		if resp.Error == nil && resp.Text != "" {
+			metrics.chunk(time.Now())
+			answer.WriteString(resp.Text)
+			reportedTokens += resp.TokenCount
+			err = stream.WriteEvent(CompareEventDelta, CompareDelta{Type: "delta", Index: index, Content: resp.Text})
+		}
+		if resp.Error != nil || err != nil {
+			// Let the provider run into the end of the stream without blocking on us
+			go func() {
+				for range streamChan {
+				}
+			}()
+			if resp.Error != nil {
+				return answer.String(), 0, 0, resp.Error
+			}
+			return answer.String(), 0, 0, err
+		}
+		if resp.Done {
+			break
+		}
+	}
+
+	usage := estimateStreamUsage(messages, answer.String(), reportedTokens)
+	metrics.complete(completionStatusSuccess, usage.InputTokens, usage.OutputTokens)
+	return answer.String(), usage.InputTokens, usage.OutputTokens, nil
+}
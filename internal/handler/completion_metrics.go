@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/shaharia-lab/mcp-kit/internal/observability"
+)
+
+// Status labels of observability.LLMCompletionDuration
+const (
+	completionStatusSuccess   = "success"
+	completionStatusError     = "error"
+	completionStatusCancelled = "cancelled"
+)
+
+// completionMetrics records the metrics and span attributes of one generation,
+// the same way for synchronous and streamed answers. A generation that isn't
+// completed is recorded as failed.
+type completionMetrics struct {
+	provider string
+	model    string
+	// answeredProvider and answeredModel differ from provider and model when
+	// a fallback generated the answer
+	answeredProvider string
+	answeredModel    string
+	streaming        bool
+	start            time.Time
+	// firstChunk and end are when the first text and the end of a streamed
+	// answer arrived from the provider, before the answer was paced
+	firstChunk   time.Time
+	end          time.Time
+	chunks       int
+	status       string
+	inputTokens  int
+	outputTokens int
+}
+
+// startCompletionMetrics counts the generation as in flight until record is called
+func startCompletionMetrics(req QuestionRequest, streaming bool) *completionMetrics {
+	observability.LLMCompletionInFlight.WithLabelValues(req.LLMProvider.Provider, req.LLMProvider.ModelID).Inc()
+
+	return &completionMetrics{
+		provider:         req.LLMProvider.Provider,
+		model:            req.LLMProvider.ModelID,
+		answeredProvider: req.LLMProvider.Provider,
+		answeredModel:    req.LLMProvider.ModelID,
+		streaming:        streaming,
+		start:            time.Now(),
+		status:           completionStatusError,
+	}
+}
+
+// chunk counts a chunk of text received from the provider at receivedAt
+func (m *completionMetrics) chunk(receivedAt time.Time) {
+	if m.chunks == 0 {
+		m.firstChunk = receivedAt
+	}
+	m.chunks++
+}
+
+// finished sets when the provider ended the stream, which the duration is
+// measured until instead of the end of the pacing
+func (m *completionMetrics) finished(receivedAt time.Time) {
+	m.end = receivedAt
+}
+
+// answeredBy sets the model that generated the answer, which the duration and
+// the tokens are recorded for
+func (m *completionMetrics) answeredBy(model LLMProvider) {
+	m.answeredProvider = model.Provider
+	m.answeredModel = model.ModelID
+}
+
+// complete sets the outcome of the generation and the tokens it used
+func (m *completionMetrics) complete(status string, inputTokens, outputTokens int) {
+	m.status = status
+	m.inputTokens = inputTokens
+	m.outputTokens = outputTokens
+}
+
+// record observes the metrics of the generation and adds them to the span of ctx
+func (m *completionMetrics) record(ctx context.Context) {
+	end := m.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	// Providers don't report the input tokens while streaming, see StreamUsage
+	estimated := strconv.FormatBool(m.streaming)
+
+	observability.LLMCompletionInFlight.WithLabelValues(m.provider, m.model).Dec()
+	observability.LLMCompletionDuration.WithLabelValues(m.answeredProvider, m.answeredModel, m.status).Observe(end.Sub(m.start).Seconds())
+	observability.TokensInputTotal.WithLabelValues(m.answeredProvider, m.answeredModel, estimated).Add(float64(m.inputTokens))
+	observability.TokensOutputTotal.WithLabelValues(m.answeredProvider, m.answeredModel, estimated).Add(float64(m.outputTokens))
+
+	if m.answeredProvider != m.provider || m.answeredModel != m.model {
+		observability.AddAttribute(ctx, "llm.answered_provider", m.answeredProvider)
+		observability.AddAttribute(ctx, "llm.answered_model_id", m.answeredModel)
+	}
+
+	observability.AddAttribute(ctx, "response.status", m.status)
+	observability.AddAttribute(ctx, "response.input_tokens", m.inputTokens)
+	observability.AddAttribute(ctx, "response.output_tokens", m.outputTokens)
+	observability.AddAttribute(ctx, "response.tokens_estimated", m.streaming)
+
+	if !m.streaming {
+		return
+	}
+
+	observability.LLMStreamChunks.WithLabelValues(m.provider, m.model).Observe(float64(m.chunks))
+	observability.AddAttribute(ctx, "stream.chunks", m.chunks)
+	if m.chunks > 0 {
+		timeToFirstToken := m.firstChunk.Sub(m.start)
+		observability.LLMStreamTimeToFirstToken.WithLabelValues(m.provider, m.model).Observe(timeToFirstToken.Seconds())
+		observability.AddAttribute(ctx, "stream.time_to_first_token_ms", timeToFirstToken.Milliseconds())
+	}
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/shaharia-lab/goai"
+	"github.com/shaharia-lab/mcp-kit/internal/observability"
+	"github.com/shaharia-lab/mcp-kit/internal/service/llm"
+	"github.com/shaharia-lab/mcp-kit/internal/storage"
+)
+
+const (
+	// DefaultMaxTokens is the output token limit used when the request doesn't set one
+	DefaultMaxTokens = 1000
+
+	// DefaultContextWindow is used for models without a context window in the catalog
+	DefaultContextWindow = 8192
+
+	// contextWindowSafetyMargin is the share of the context window kept free to
+	// absorb the error of the token estimator
+	contextWindowSafetyMargin = 0.1
+
+	// imageTokens is the allowance for an image sent along with a message. It
+	// covers the largest images providers accept without downscaling them.
+	imageTokens = 1600
+)
+
+// historyBudget returns how many tokens of the model's context window are left
+// for chat history once the answer (ModelSettings.MaxTokens) and the new
+// question with its attachments have been accounted for
+func historyBudget(req QuestionRequest, estimator llm.TokenEstimator) int {
+	contextWindow := DefaultContextWindow
+	if model, ok := getLLMProviders().GetModel(req.LLMProvider.Provider, req.LLMProvider.ModelID); ok && model.ContextWindow > 0 {
+		contextWindow = model.ContextWindow
+	}
+
+	maxTokens := maxOutputTokens(req)
+	usable := int(float64(contextWindow) * (1 - contextWindowSafetyMargin))
+	question := messageTokens(estimator, storage.Message{
+		ChatHistoryMessage: goai.ChatHistoryMessage{LLMMessage: goai.LLMMessage{Role: goai.UserRole, Text: req.Question}},
+		Attachments:        req.Attachments,
+	})
+
+	budget := usable - maxTokens - question
+	if budget < 0 {
+		return 0
+	}
+	return budget
+}
+
+// messageTokens estimates the tokens a message occupies as it is sent to the
+// model, with its text files inlined and an allowance for each of its images
+func messageTokens(estimator llm.TokenEstimator, msg storage.Message) int {
+	return llm.EstimateMessageTokens(estimator, modelMessage(msg)) + len(attachmentImages(msg.Attachments))*imageTokens
+}
+
+// maxOutputTokens returns the output token limit of the answer
+func maxOutputTokens(req QuestionRequest) int {
+	if req.ModelSettings.MaxTokens <= 0 {
+		return DefaultMaxTokens
+	}
+	return int(req.ModelSettings.MaxTokens)
+}
+
+// getTruncatedChatHistory returns the chat history that fits into budget tokens.
+// System messages are always kept, the remaining budget is filled with the most
+// recent messages. The number of dropped messages is returned alongside.
+func getTruncatedChatHistory(messages []storage.Message, budget int, estimator llm.TokenEstimator) ([]goai.LLMMessage, int) {
+	// Ensure System messages are always included
+	var system []goai.LLMMessage
+	remaining := budget
+	for _, msg := range messages {
+		if msg.Role == goai.SystemRole {
+			system = append(system, msg.LLMMessage)
+			remaining -= llm.EstimateMessageTokens(estimator, msg.LLMMessage)
+		}
+	}
+
+	// Walk back from the newest message and keep as many as fit
+	var recent []goai.LLMMessage
+	dropped := 0
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		if msg.Role == goai.SystemRole {
+			continue
+		}
+
+		text := modelMessage(msg)
+		cost := messageTokens(estimator, msg)
+		if dropped > 0 || cost > remaining {
+			dropped++
+			continue
+		}
+
+		// Providers refuse empty messages, which cancelled answers can be
+		if msg.Status == storage.MessageStatusCancelled && msg.Text == "" {
+			continue
+		}
+
+		remaining -= cost
+		recent = append(recent, text)
+	}
+
+	result := make([]goai.LLMMessage, 0, len(system)+len(recent))
+	result = append(result, system...)
+	for i := len(recent) - 1; i >= 0; i-- {
+		result = append(result, recent[i])
+	}
+
+	return result, dropped
+}
+
+// summarizingChats holds the chats whose summary is being updated, so that
+// questions asked meanwhile don't summarize the same messages again
+var summarizingChats sync.Map
+
+// summarizeDroppedMessages returns the stored rolling summary covering the
+// dropped oldest messages of the chat. Messages that are not part of the
+// summary yet are summarized in the background for the following questions,
+// so the answer never waits for the summarizer. A chat is summarized by one
+// question at a time, the following ones use the stored summary meanwhile.
+func summarizeDroppedMessages(
+	ctx context.Context,
+	chat *storage.Chat,
+	dropped int,
+	summarizer *llm.Summarizer,
+	historyStorage storage.ChatHistoryStorage,
+	logger *log.Logger,
+) string {
+	if summarizer == nil || dropped == 0 {
+		return ""
+	}
+
+	if dropped <= chat.SummarizedMessages {
+		return chat.Summary
+	}
+
+	if _, busy := summarizingChats.LoadOrStore(chat.UUID, struct{}{}); busy {
+		return chat.Summary
+	}
+
+	var pending []goai.LLMMessage
+	n := 0
+	for _, msg := range chat.ActiveBranch() {
+		if msg.Role == goai.SystemRole {
+			continue
+		}
+		if n >= chat.SummarizedMessages && n < dropped {
+			pending = append(pending, msg.LLMMessage)
+		}
+		n++
+	}
+
+	chatUUID, previous, basedOn := chat.UUID, chat.Summary, chat.SummarizedMessages
+	go func() {
+		defer summarizingChats.Delete(chatUUID)
+		updateChatSummary(context.WithoutCancel(ctx), chatUUID, previous, basedOn, pending, dropped, summarizer, historyStorage, logger)
+	}()
+	return chat.Summary
+}
+
+// updateChatSummary merges pending into the previous summary of the chat, which
+// covers its first basedOn messages, and stores the result as the summary of
+// its first summarizedMessages messages, unless the stored summary was changed
+// in the meantime, e.g. by switching branches.
+// Summarization failures are logged and never fail the request.
+func updateChatSummary(
+	ctx context.Context,
+	chatUUID uuid.UUID,
+	previous string,
+	basedOn int,
+	pending []goai.LLMMessage,
+	summarizedMessages int,
+	summarizer *llm.Summarizer,
+	historyStorage storage.ChatHistoryStorage,
+	logger *log.Logger,
+) {
+	ctx, span := observability.StartSpan(ctx, "summarize_chat_history")
+	defer span.End()
+	observability.AddAttribute(ctx, "summary.new_messages", len(pending))
+
+	summary, err := summarizer.Summarize(ctx, previous, pending)
+	if err != nil {
+		logger.Printf("Failed to summarize chat %s: %v", chatUUID, err)
+		span.RecordError(err)
+		return
+	}
+
+	err = historyStorage.SetChatSummary(ctx, chatUUID, summary, summarizedMessages, basedOn)
+	if errors.Is(err, storage.ErrSummaryOutdated) {
+		logger.Printf("Discarded summary of chat %s, it changed in the meantime", chatUUID)
+		return
+	}
+	if err != nil {
+		logger.Printf("Failed to save summary of chat %s: %v", chatUUID, err)
+	}
+}
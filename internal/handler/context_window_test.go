@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shaharia-lab/goai"
+	"github.com/shaharia-lab/mcp-kit/internal/prompt"
+	"github.com/shaharia-lab/mcp-kit/internal/service/llm"
+	"github.com/shaharia-lab/mcp-kit/internal/storage"
+)
+
+func TestPromptTemplateName(t *testing.T) {
+	tests := []struct {
+		name  string
+		tools []string
+		want  string
+	}{
+		{name: "no tools", want: "llm_general"},
+		{name: "tools", tools: []string{"get_weather"}, want: "llm_with_tools_v2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := promptTemplateName(QuestionRequest{Question: "Hi", SelectedTools: tt.tools})
+			if got != tt.want {
+				t.Errorf("promptTemplateName() = %q, want %q", got, tt.want)
+			}
+
+			registered := false
+			for _, p := range prompt.MCPPromptsRegistry {
+				registered = registered || p.Name == got
+			}
+			if !registered {
+				t.Errorf("prompt %q is not registered with the MCP server", got)
+			}
+		})
+	}
+}
+
+func TestGetTruncatedChatHistoryLeavesOutEmptyCancelledAnswers(t *testing.T) {
+	message := func(role goai.LLMMessageRole, text string, status storage.MessageStatus) storage.Message {
+		return storage.Message{
+			ChatHistoryMessage: goai.ChatHistoryMessage{LLMMessage: goai.LLMMessage{Role: role, Text: text}},
+			Status:             status,
+		}
+	}
+	messages := []storage.Message{
+		message(goai.UserRole, "What's the weather in Tokyo?", ""),
+		message(goai.AssistantRole, "", storage.MessageStatusCancelled),
+		message(goai.UserRole, "And in Paris?", ""),
+		message(goai.AssistantRole, "It's sun", storage.MessageStatusCancelled),
+	}
+
+	got, dropped := getTruncatedChatHistory(messages, 10000, llm.NewHeuristicTokenEstimator())
+	if dropped != 0 {
+		t.Errorf("dropped = %d, want 0", dropped)
+	}
+	want := []string{"What's the weather in Tokyo?", "And in Paris?", "It's sun"}
+	if len(got) != len(want) {
+		t.Fatalf("messages = %+v, want %q", got, want)
+	}
+	for i, msg := range got {
+		if msg.Text != want[i] {
+			t.Errorf("messages[%d] = %q, want %q", i, msg.Text, want[i])
+		}
+	}
+}
+
+func TestHistoryBudgetCountsTheAttachmentsOfTheQuestion(t *testing.T) {
+	estimator := llm.NewHeuristicTokenEstimator()
+	req := QuestionRequest{Question: "What's in these files?"}
+	without := historyBudget(req, estimator)
+
+	notes := strings.Repeat("Meeting notes about the release. ", 200)
+	req.Attachments = []storage.Attachment{
+		{Name: "notes.txt", ContentType: "text/plain", Size: len(notes), Data: []byte(notes)},
+		{Name: "chart.png", ContentType: "image/png", Size: 4, Data: []byte("\x89PNG")},
+	}
+	with := historyBudget(req, estimator)
+
+	if want := estimator.EstimateTokens(notes) + imageTokens; without-with < want {
+		t.Errorf("budget shrank by %d tokens, want at least %d for the text file and the image", without-with, want)
+	}
+}
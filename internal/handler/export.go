@@ -0,0 +1,444 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shaharia-lab/goai"
+	"github.com/shaharia-lab/mcp-kit/internal/auth"
+	"github.com/shaharia-lab/mcp-kit/internal/config"
+	"github.com/shaharia-lab/mcp-kit/internal/observability"
+	"github.com/shaharia-lab/mcp-kit/internal/storage"
+)
+
+// Formats a chat can be exported as. Only the JSON formats can be imported again.
+const (
+	ExportFormatMarkdown = "markdown"
+	ExportFormatJSON     = "json"
+	ExportFormatJSONL    = "jsonl"
+)
+
+// ChatExportVersion is the version of the JSON and JSONL export formats
+const ChatExportVersion = 1
+
+// MaxImportSize is the maximum size in bytes of an imported chat
+const MaxImportSize = 10 << 20
+
+// ChatExport is a chat in the JSON export format, with the messages of all of
+// its branches. In the JSONL format it is the first line, without messages,
+// followed by one line per message.
+type ChatExport struct {
+	Version         int                   `json:"version"`
+	ExportedAt      time.Time             `json:"exported_at"`
+	UUID            uuid.UUID             `json:"uuid"`
+	Title           string                `json:"title"`
+	Archived        bool                  `json:"archived"`
+	CreatedAt       time.Time             `json:"created_at"`
+	UpdatedAt       time.Time             `json:"updated_at"`
+	ActiveMessageID int64                 `json:"active_message_id"`
+	SystemPrompt    string                `json:"system_prompt,omitempty"`
+	Persona         string                `json:"persona,omitempty"`
+	Settings        *storage.ChatSettings `json:"settings,omitempty"`
+	Messages        []storage.Message     `json:"messages,omitempty"`
+}
+
+// ExportChatHandler Handler to download a chat of the authenticated caller as
+// Markdown, JSON or JSONL
+func ExportChatHandler(logger *log.Logger, historyStorage storage.ChatHistoryStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := observability.StartSpan(r.Context(), "export_chat")
+		defer span.End()
+
+		parsedChatUUID, ok := parseChatUUID(w, r, logger)
+		if !ok {
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = ExportFormatJSON
+		}
+		observability.AddAttribute(ctx, "export.format", format)
+
+		var contentType, extension string
+		switch format {
+		case ExportFormatMarkdown:
+			contentType, extension = "text/markdown; charset=utf-8", "md"
+		case ExportFormatJSON:
+			contentType, extension = "application/json", "json"
+		case ExportFormatJSONL:
+			contentType, extension = "application/x-ndjson", "jsonl"
+		default:
+			http.Error(w, `{"error": "format must be one of markdown, json or jsonl"}`, http.StatusBadRequest)
+			return
+		}
+
+		chat, err := historyStorage.GetOwnedChat(ctx, parsedChatUUID, auth.SubjectFromContext(ctx))
+		if err != nil {
+			logger.Printf("Chat not found for UUID: %v, error: %v", parsedChatUUID, err)
+			http.Error(w, `{"error": "Chat not found"}`, http.StatusNotFound)
+			return
+		}
+
+		// JSON exports carry the data of the attachments, so they can be imported again
+		if format != ExportFormatMarkdown {
+			chat.Messages, err = withAttachmentData(ctx, chat.UUID, chat.Messages, historyStorage)
+			if err != nil {
+				logger.Printf("Error loading attachments of chat %s: %v", chat.UUID, err)
+				http.Error(w, `{"error": "Failed to export chat"}`, http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chat-%s.%s"`, chat.UUID, extension))
+		w.WriteHeader(http.StatusOK)
+
+		switch format {
+		case ExportFormatMarkdown:
+			err = writeMarkdownExport(w, chat)
+		case ExportFormatJSON:
+			export := newChatExport(chat)
+			export.Messages = chat.Messages
+			if export.Messages == nil {
+				export.Messages = []storage.Message{}
+			}
+			err = json.NewEncoder(w).Encode(export)
+		case ExportFormatJSONL:
+			err = writeJSONLExport(w, chat)
+		}
+		if err != nil {
+			logger.Printf("Error writing export of chat %s: %v", chat.UUID, err)
+		}
+	}
+}
+
+// ImportChatHandler Handler to recreate a chat from a JSON or JSONL export. The
+// imported chat gets a new UUID and is owned by the authenticated caller.
+func ImportChatHandler(logger *log.Logger, historyStorage storage.ChatHistoryStorage, systemPrompts config.SystemPromptConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := observability.StartSpan(r.Context(), "import_chat")
+		defer span.End()
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = ExportFormatJSON
+		}
+
+		body := http.MaxBytesReader(w, r.Body, MaxImportSize)
+
+		var export ChatExport
+		var err error
+		switch format {
+		case ExportFormatJSON:
+			err = json.NewDecoder(body).Decode(&export)
+		case ExportFormatJSONL:
+			export, err = readJSONLExport(body)
+		default:
+			http.Error(w, `{"error": "format must be one of json or jsonl"}`, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, "Invalid chat export: "+err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		if err := validateChatExport(export); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+		observability.AddAttribute(ctx, "import.messages", len(export.Messages))
+
+		// The system prompt of a persona is taken from the configuration of this server
+		systemPrompt := export.SystemPrompt
+		if export.Persona != "" {
+			systemPrompt = ""
+		}
+		options, err := chatOptions(systemPrompt, export.Persona, systemPrompts)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), requestErrorStatus(err))
+			return
+		}
+		if export.Settings != nil {
+			if err := validateChatSettings(export.Settings); err != nil {
+				http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+				return
+			}
+			options.Settings = export.Settings
+		}
+
+		chat, err := importChat(ctx, export, options, auth.SubjectFromContext(ctx), historyStorage)
+		if err != nil {
+			logger.Printf("Failed to import chat: %v", err)
+			http.Error(w, `{"error": "Failed to import chat"}`, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(activeBranchOnly(*chat)); err != nil {
+			logger.Printf("Error encoding chat response: %v", err)
+		}
+	}
+}
+
+func newChatExport(chat *storage.Chat) ChatExport {
+	return ChatExport{
+		Version:         ChatExportVersion,
+		ExportedAt:      time.Now().UTC(),
+		UUID:            chat.UUID,
+		Title:           chat.Title,
+		Archived:        chat.Archived,
+		CreatedAt:       chat.CreatedAt,
+		UpdatedAt:       chat.UpdatedAt,
+		ActiveMessageID: chat.ActiveMessageID,
+		SystemPrompt:    chat.SystemPrompt,
+		Persona:         chat.Persona,
+		Settings:        chat.Settings,
+	}
+}
+
+func writeJSONLExport(w io.Writer, chat *storage.Chat) error {
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(newChatExport(chat)); err != nil {
+		return err
+	}
+	for _, msg := range chat.Messages {
+		if err := encoder.Encode(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readJSONLExport(r io.Reader) (ChatExport, error) {
+	var export ChatExport
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), MaxImportSize)
+
+	line := 0
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		line++
+
+		if line == 1 {
+			if err := json.Unmarshal(scanner.Bytes(), &export); err != nil {
+				return ChatExport{}, fmt.Errorf("line %d: %w", line, err)
+			}
+			continue
+		}
+
+		var msg storage.Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return ChatExport{}, fmt.Errorf("line %d: %w", line, err)
+		}
+		export.Messages = append(export.Messages, msg)
+	}
+	if err := scanner.Err(); err != nil {
+		return ChatExport{}, err
+	}
+	if line == 0 {
+		return ChatExport{}, errors.New("empty export")
+	}
+	return export, nil
+}
+
+// validateChatExport checks that the messages of an export form a tree the
+// storage can rebuild
+func validateChatExport(export ChatExport) error {
+	if export.Version != ChatExportVersion {
+		return fmt.Errorf("unsupported export version %d", export.Version)
+	}
+	if len([]rune(export.Title)) > MaxChatTitleLength {
+		return fmt.Errorf("title cannot be longer than %d characters", MaxChatTitleLength)
+	}
+
+	seen := map[int64]bool{storage.RootMessageID: true}
+	for _, msg := range sortedByID(export.Messages) {
+		switch msg.Role {
+		case goai.UserRole, goai.AssistantRole, goai.SystemRole:
+		default:
+			return fmt.Errorf("message %d has an unknown role %q", msg.ID, msg.Role)
+		}
+		if msg.ID <= storage.RootMessageID || seen[msg.ID] {
+			return fmt.Errorf("message IDs must be positive and unique, got %d", msg.ID)
+		}
+		if msg.ParentID >= msg.ID || !seen[msg.ParentID] {
+			return fmt.Errorf("message %d has an unknown parent %d", msg.ID, msg.ParentID)
+		}
+		for _, attachment := range msg.Attachments {
+			if len(attachment.Data) == 0 {
+				return fmt.Errorf("attachment %s of message %d has no data", attachment.Name, msg.ID)
+			}
+		}
+		seen[msg.ID] = true
+	}
+
+	if !seen[export.ActiveMessageID] {
+		return fmt.Errorf("unknown active_message_id %d", export.ActiveMessageID)
+	}
+	return nil
+}
+
+// importChat adds the messages of the export to a new chat, branch by branch.
+// The storage assigns new message IDs, so parents are looked up by their old ID.
+func importChat(ctx context.Context, export ChatExport, options storage.ChatOptions, owner string, historyStorage storage.ChatHistoryStorage) (*storage.Chat, error) {
+	chat, err := historyStorage.CreateOwnedChat(ctx, owner, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chat: %w", err)
+	}
+
+	if err := restoreChat(ctx, chat.UUID, owner, export, historyStorage); err != nil {
+		if _, deleteErr := historyStorage.DeleteOwnedChats(context.WithoutCancel(ctx), owner, []uuid.UUID{chat.UUID}); deleteErr != nil {
+			err = errors.Join(err, deleteErr)
+		}
+		return nil, err
+	}
+
+	return historyStorage.GetOwnedChat(ctx, chat.UUID, owner)
+}
+
+func restoreChat(ctx context.Context, chatUUID uuid.UUID, owner string, export ChatExport, historyStorage storage.ChatHistoryStorage) error {
+	newIDs := map[int64]int64{storage.RootMessageID: storage.RootMessageID}
+	active := storage.RootMessageID
+
+	for _, msg := range sortedByID(export.Messages) {
+		if parent := newIDs[msg.ParentID]; parent != active {
+			if err := historyStorage.SetActiveMessage(ctx, chatUUID, parent); err != nil {
+				return err
+			}
+		}
+
+		oldID := msg.ID
+		msg.ID, msg.ParentID = 0, 0
+		added, err := historyStorage.AddChatMessage(ctx, chatUUID, msg)
+		if err != nil {
+			return err
+		}
+		newIDs[oldID] = added.ID
+		active = added.ID
+	}
+
+	if err := historyStorage.SetActiveMessage(ctx, chatUUID, newIDs[export.ActiveMessageID]); err != nil {
+		return err
+	}
+
+	_, err := historyStorage.UpdateOwnedChat(ctx, chatUUID, owner, storage.ChatUpdate{
+		Title:    &export.Title,
+		Archived: &export.Archived,
+	})
+	return err
+}
+
+func sortedByID(messages []storage.Message) []storage.Message {
+	sorted := append([]storage.Message(nil), messages...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+	return sorted
+}
+
+// writeMarkdownExport renders the active branch of the chat as a readable
+// transcript
+func writeMarkdownExport(w io.Writer, chat *storage.Chat) error {
+	var b strings.Builder
+
+	title := chat.Title
+	if title == "" {
+		title = "Chat " + chat.UUID.String()
+	}
+	fmt.Fprintf(&b, "# %s\n\n", title)
+	fmt.Fprintf(&b, "- Chat: `%s`\n", chat.UUID)
+	fmt.Fprintf(&b, "- Created: %s\n", chat.CreatedAt.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "- Exported: %s\n", time.Now().UTC().Format(time.RFC3339))
+	if chat.Persona != "" {
+		fmt.Fprintf(&b, "- Persona: %s\n", chat.Persona)
+	} else if chat.SystemPrompt != "" {
+		fmt.Fprintf(&b, "\n## System prompt\n\n%s\n", chat.SystemPrompt)
+	}
+
+	for _, msg := range chat.ActiveBranch() {
+		fmt.Fprintf(&b, "\n## %s\n\n", markdownRole(msg.Role))
+		fmt.Fprintf(&b, "_%s_\n\n", msg.GeneratedAt.UTC().Format(time.RFC3339))
+
+		for _, call := range msg.ToolCalls {
+			status := "succeeded"
+			if call.IsError {
+				status = "failed"
+			}
+			fmt.Fprintf(&b, "**Tool call** `%s` %s in %d ms\n\n", call.Name, status, call.DurationMs)
+			if len(call.Arguments) > 0 {
+				b.WriteString(codeBlock("json", string(call.Arguments)))
+			}
+			if call.Error != "" {
+				fmt.Fprintf(&b, "Error: %s\n\n", call.Error)
+			}
+			if call.Result != "" {
+				b.WriteString(codeBlock("", call.Result))
+			}
+			if call.ResultTruncated {
+				b.WriteString("_Result truncated_\n\n")
+			}
+		}
+
+		b.WriteString(strings.TrimSpace(msg.Text))
+		b.WriteString("\n")
+
+		for _, attachment := range msg.Attachments {
+			fmt.Fprintf(&b, "\n_Attachment: %s (%s, %d bytes)_\n", attachment.Name, attachment.ContentType, attachment.Size)
+		}
+		switch msg.Status {
+		case storage.MessageStatusCancelled:
+			b.WriteString("\n_Answer cancelled_\n")
+		case storage.MessageStatusInvalidOutput:
+			b.WriteString("\n_Answer doesn't match the response format_\n")
+		}
+		if msg.Usage != nil {
+			fmt.Fprintf(&b, "\n_Tokens: %d input, %d output_\n", msg.Usage.InputTokens, msg.Usage.OutputTokens)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func markdownRole(role goai.LLMMessageRole) string {
+	switch role {
+	case goai.UserRole:
+		return "User"
+	case goai.AssistantRole:
+		return "Assistant"
+	case goai.SystemRole:
+		return "System"
+	default:
+		return string(role)
+	}
+}
+
+// codeBlock fences text with more backticks than any run of backticks it contains
+func codeBlock(language, text string) string {
+	longest, run := 0, 0
+	for _, c := range text {
+		if c == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	fence := strings.Repeat("`", max(3, longest+1))
+	return fmt.Sprintf("%s%s\n%s\n%s\n\n", fence, language, strings.TrimRight(text, "\n"), fence)
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/shaharia-lab/mcp-kit/internal/config"
+	"github.com/shaharia-lab/mcp-kit/internal/observability"
+	"github.com/shaharia-lab/mcp-kit/internal/service/llm"
+)
+
+// fallbackChain is the models tried after the requested one, see llm.FallbackProvider
+type fallbackChain struct {
+	models         []LLMProvider
+	attemptTimeout time.Duration
+}
+
+// fallbackChainFor returns the configured fallbacks of the requested model
+// that can answer the question: supported models accepting its attachments
+func fallbackChainFor(req QuestionRequest, cfg config.FallbackConfig) fallbackChain {
+	chain := fallbackChain{attemptTimeout: cfg.AttemptTimeout}
+	for _, configured := range cfg.Chains {
+		if !strings.EqualFold(configured.Provider, req.LLMProvider.Provider) || configured.ModelID != req.LLMProvider.ModelID {
+			continue
+		}
+
+		for _, fallback := range configured.Fallbacks {
+			modelReq := req
+			modelReq.LLMProvider = LLMProvider{Provider: fallback.Provider, ModelID: fallback.ModelID}
+			if modelReq.LLMProvider == req.LLMProvider ||
+				!getLLMProviders().IsSupported(fallback.Provider, fallback.ModelID) ||
+				validateAttachmentTypes(modelReq) != nil {
+				continue
+			}
+			chain.models = append(chain.models, modelReq.LLMProvider)
+		}
+		break
+	}
+	return chain
+}
+
+// recordFallback returns the callback logging and counting a model giving way
+// to the next one of its chain
+func recordFallback(logger *log.Logger) func(from, to llm.Candidate, err error) {
+	return func(from, to llm.Candidate, err error) {
+		logger.Printf("%s %s failed, falling back to %s %s: %v", from.Provider, from.ModelID, to.Provider, to.ModelID, err)
+		observability.LLMFallbacksTotal.WithLabelValues(from.Provider, from.ModelID, to.Provider, to.ModelID).Inc()
+	}
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shaharia-lab/mcp-kit/internal/auth"
+	"github.com/shaharia-lab/mcp-kit/internal/observability"
+	"github.com/shaharia-lab/mcp-kit/internal/storage"
+)
+
+var (
+	// ErrGenerationInProgress is returned when a chat is asked a question while
+	// the answer to the previous one is still being generated
+	ErrGenerationInProgress = errors.New("an answer is already being generated for this chat")
+
+	// errGenerationCancelled is the cause of the context of a cancelled generation
+	errGenerationCancelled = errors.New("generation cancelled")
+)
+
+// GenerationRegistry keeps the cancel functions of the running generations,
+// keyed by chat, so they can be stopped from another request. The events of
+// streamed answers are kept as well, until resumeWindow after the answer is
+// complete, so clients can reconnect to them.
+type GenerationRegistry struct {
+	mu           sync.Mutex
+	cancels      map[uuid.UUID]context.CancelCauseFunc
+	events       map[uuid.UUID]*eventBuffer
+	resumeWindow time.Duration
+}
+
+// NewGenerationRegistry creates a new GenerationRegistry
+func NewGenerationRegistry(resumeWindow time.Duration) *GenerationRegistry {
+	return &GenerationRegistry{
+		cancels:      make(map[uuid.UUID]context.CancelCauseFunc),
+		events:       make(map[uuid.UUID]*eventBuffer),
+		resumeWindow: resumeWindow,
+	}
+}
+
+// Start registers a generation for the chat and returns the context it has to
+// run with. The returned function must be called once the generation is over.
+func (g *GenerationRegistry) Start(ctx context.Context, chatUUID uuid.UUID) (context.Context, func(), error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, running := g.cancels[chatUUID]; running {
+		return nil, nil, ErrGenerationInProgress
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	g.cancels[chatUUID] = cancel
+	// Events of the previous answer are outdated now
+	delete(g.events, chatUUID)
+
+	return ctx, func() {
+		g.mu.Lock()
+		delete(g.cancels, chatUUID)
+		g.mu.Unlock()
+		cancel(nil)
+	}, nil
+}
+
+// Cancel stops the running generation of the chat. It returns false when
+// nothing is being generated for the chat.
+func (g *GenerationRegistry) Cancel(chatUUID uuid.UUID) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	cancel, running := g.cancels[chatUUID]
+	if running {
+		cancel(errGenerationCancelled)
+	}
+	return running
+}
+
+// Stream makes the events of the running generation of the chat available to
+// clients reconnecting to it. They are dropped resumeWindow after the events
+// are closed.
+func (g *GenerationRegistry) Stream(chatUUID uuid.UUID, events *eventBuffer) {
+	g.mu.Lock()
+	g.events[chatUUID] = events
+	g.mu.Unlock()
+
+	go func() {
+		<-events.Done()
+		time.AfterFunc(g.resumeWindow, func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			if g.events[chatUUID] == events {
+				delete(g.events, chatUUID)
+			}
+		})
+	}()
+}
+
+// Events returns the events of the running or a recently finished streamed
+// answer of the chat
+func (g *GenerationRegistry) Events(chatUUID uuid.UUID) (*eventBuffer, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	events, ok := g.events[chatUUID]
+	return events, ok
+}
+
+// generationCancelled tells whether ctx has been cancelled through the GenerationRegistry
+func generationCancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errGenerationCancelled)
+}
+
+// CancelGenerationResponse is returned by CancelGenerationHandler
+type CancelGenerationResponse struct {
+	ChatUUID  uuid.UUID `json:"chat_uuid"`
+	Cancelled bool      `json:"cancelled"`
+}
+
+// CancelGenerationHandler stops the answer currently generated for a chat owned by the caller
+func CancelGenerationHandler(logger *log.Logger, historyStorage storage.ChatHistoryStorage, generations *GenerationRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := observability.StartSpan(r.Context(), "cancel_generation")
+		defer span.End()
+
+		chatUUID, ok := parseChatUUID(w, r, logger)
+		if !ok {
+			return
+		}
+
+		if _, err := historyStorage.GetOwnedChat(ctx, chatUUID, auth.SubjectFromContext(ctx)); err != nil {
+			if errors.Is(err, storage.ErrChatNotFound) {
+				http.Error(w, `{"error": "Chat not found"}`, http.StatusNotFound)
+				return
+			}
+			logger.Printf("Error getting chat: %v", err)
+			http.Error(w, `{"error": "Failed to get chat"}`, http.StatusInternalServerError)
+			return
+		}
+
+		if !generations.Cancel(chatUUID) {
+			http.Error(w, `{"error": "No answer is being generated for this chat"}`, http.StatusConflict)
+			return
+		}
+		observability.AddAttribute(ctx, "chat.uuid", chatUUID.String())
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(CancelGenerationResponse{
+			ChatUUID:  chatUUID,
+			Cancelled: true,
+		})
+	}
+}
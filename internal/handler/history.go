@@ -2,34 +2,56 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
-	"github.com/shaharia-lab/goai"
+	"github.com/shaharia-lab/mcp-kit/internal/auth"
+	"github.com/shaharia-lab/mcp-kit/internal/storage"
 )
 
-// ChatHistoryListsHandler Handler to list all chats
-func ChatHistoryListsHandler(logger *log.Logger, historyStorage goai.ChatHistoryStorage) http.HandlerFunc {
+// ChatHistoryListsHandler Handler to list the chats of the authenticated caller
+// page by page, see parseChatListQuery for the supported query parameters
+func ChatHistoryListsHandler(logger *log.Logger, historyStorage storage.ChatHistoryStorage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Fetch all chat histories from storage
-		chats, err := historyStorage.ListChatHistories(r.Context())
+		query, view, err := parseChatListQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		// Fetch the caller's chat histories from storage
+		owner := auth.SubjectFromContext(r.Context())
+		response := ChatListResponse{}
+		var next *storage.ChatCursor
+		if view == ChatListViewSummary {
+			var page storage.ChatSummaryPage
+			page, err = historyStorage.ListOwnedChatSummaries(r.Context(), owner, query)
+			response.Chats, next = page.Summaries, page.Next
+		} else {
+			var page storage.ChatPage
+			page, err = historyStorage.ListOwnedChatsPage(r.Context(), owner, query)
+			for i := range page.Chats {
+				page.Chats[i] = activeBranchOnly(page.Chats[i])
+			}
+			response.Chats, next = page.Chats, page.Next
+		}
 		if err != nil {
 			logger.Printf("Failed to retrieve chat histories: %v", err)
 			http.Error(w, `{"error": "Failed to retrieve chat histories"}`, http.StatusInternalServerError)
 			return
 		}
+		if next != nil {
+			response.NextCursor = encodeChatCursor(query, *next)
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 
-		response := struct {
-			Chats []goai.ChatHistory `json:"chats"`
-		}{
-			Chats: chats,
-		}
-
 		// Encode response and handle potential errors
 		if err := json.NewEncoder(w).Encode(response); err != nil {
 			logger.Printf("Error encoding chat list response: %v", err)
@@ -38,26 +60,17 @@ func ChatHistoryListsHandler(logger *log.Logger, historyStorage goai.ChatHistory
 	}
 }
 
-// GetChatHandler Handler to get a single chat by chatId
-func GetChatHandler(logger *log.Logger, historyStorage goai.ChatHistoryStorage) http.HandlerFunc {
+// GetChatHandler Handler to get a single chat by chatId. Chats owned by other
+// users are reported as not found.
+func GetChatHandler(logger *log.Logger, historyStorage storage.ChatHistoryStorage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Extract 'chatId' from URL parameters
-		chatUUID := chi.URLParam(r, "chatId")
-		if chatUUID == "" {
-			http.Error(w, `{"error": "Chat ID is required"}`, http.StatusBadRequest)
-			return
-		}
-
-		// Parse the provided Chat ID as UUID
-		parsedChatUUID, err := uuid.Parse(chatUUID)
-		if err != nil {
-			logger.Printf("Invalid chat UUID provided: %s", chatUUID)
-			http.Error(w, `{"error": "Invalid chat ID"}`, http.StatusBadRequest)
+		parsedChatUUID, ok := parseChatUUID(w, r, logger)
+		if !ok {
 			return
 		}
 
 		// Fetch the chat from storage by its UUID
-		chat, err := historyStorage.GetChat(r.Context(), parsedChatUUID)
+		chat, err := historyStorage.GetOwnedChat(r.Context(), parsedChatUUID, auth.SubjectFromContext(r.Context()))
 		if err != nil {
 			logger.Printf("Chat not found for UUID: %v, error: %v", parsedChatUUID, err)
 			http.Error(w, `{"error": "Chat not found"}`, http.StatusNotFound)
@@ -67,9 +80,199 @@ func GetChatHandler(logger *log.Logger, historyStorage goai.ChatHistoryStorage)
 		// Encode and return the chat as a JSON response
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		if err := json.NewEncoder(w).Encode(chat); err != nil {
+		if err := json.NewEncoder(w).Encode(ChatResponse{
+			Chat:  activeBranchOnly(*chat),
+			Usage: chat.Usage(),
+		}); err != nil {
 			logger.Printf("Error encoding chat response: %v", err)
 			http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
 		}
 	}
 }
+
+// ChatResponse is a chat returned by GetChatHandler together with the usage
+// totals of all its answers
+type ChatResponse struct {
+	storage.Chat
+	Usage storage.ChatUsage `json:"usage"`
+}
+
+// MaxChatTitleLength is the maximum number of characters allowed in a chat title
+const MaxChatTitleLength = 200
+
+// MaxBulkDeleteChats is the maximum number of chats that can be deleted in one request
+const MaxBulkDeleteChats = 100
+
+// UpdateChatRequest holds the chat attributes a client may change. Omitted fields are left untouched.
+type UpdateChatRequest struct {
+	Title    *string `json:"title"`
+	Archived *bool   `json:"archived"`
+	// Settings replace the settings follow-up questions inherit
+	Settings *storage.ChatSettings `json:"settings"`
+}
+
+// BulkDeleteChatsRequest lists the chats to delete in one request
+type BulkDeleteChatsRequest struct {
+	ChatUUIDs []uuid.UUID `json:"chat_uuids"`
+}
+
+// BulkDeleteChatsResponse reports which chats were deleted and which were not found
+type BulkDeleteChatsResponse struct {
+	Deleted  []uuid.UUID `json:"deleted"`
+	NotFound []uuid.UUID `json:"not_found"`
+}
+
+// DeleteChatHandler Handler to delete a single chat of the authenticated caller
+func DeleteChatHandler(logger *log.Logger, historyStorage storage.ChatHistoryStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parsedChatUUID, ok := parseChatUUID(w, r, logger)
+		if !ok {
+			return
+		}
+
+		deleted, err := historyStorage.DeleteOwnedChats(r.Context(), auth.SubjectFromContext(r.Context()), []uuid.UUID{parsedChatUUID})
+		if err != nil {
+			logger.Printf("Failed to delete chat %s: %v", parsedChatUUID, err)
+			http.Error(w, `{"error": "Failed to delete chat"}`, http.StatusInternalServerError)
+			return
+		}
+
+		if len(deleted) == 0 {
+			http.Error(w, `{"error": "Chat not found"}`, http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// UpdateChatHandler Handler to rename, (un)archive and/or change the settings of a chat of the authenticated caller
+func UpdateChatHandler(logger *log.Logger, historyStorage storage.ChatHistoryStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parsedChatUUID, ok := parseChatUUID(w, r, logger)
+		if !ok {
+			return
+		}
+
+		var req UpdateChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+			return
+		}
+
+		if err := validateUpdateChatRequest(&req); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		chat, err := historyStorage.UpdateOwnedChat(r.Context(), parsedChatUUID, auth.SubjectFromContext(r.Context()), storage.ChatUpdate{
+			Title:    req.Title,
+			Archived: req.Archived,
+			Settings: req.Settings,
+		})
+		if errors.Is(err, storage.ErrChatNotFound) {
+			http.Error(w, `{"error": "Chat not found"}`, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Printf("Failed to update chat %s: %v", parsedChatUUID, err)
+			http.Error(w, `{"error": "Failed to update chat"}`, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(activeBranchOnly(*chat)); err != nil {
+			logger.Printf("Error encoding chat response: %v", err)
+		}
+	}
+}
+
+// BulkDeleteChatsHandler Handler to delete several chats of the authenticated caller at once
+func BulkDeleteChatsHandler(logger *log.Logger, historyStorage storage.ChatHistoryStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req BulkDeleteChatsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+			return
+		}
+
+		if len(req.ChatUUIDs) == 0 {
+			http.Error(w, `{"error": "At least one chat ID is required"}`, http.StatusBadRequest)
+			return
+		}
+		if len(req.ChatUUIDs) > MaxBulkDeleteChats {
+			http.Error(w, fmt.Sprintf(`{"error": "At most %d chats can be deleted at once"}`, MaxBulkDeleteChats), http.StatusBadRequest)
+			return
+		}
+
+		deleted, err := historyStorage.DeleteOwnedChats(r.Context(), auth.SubjectFromContext(r.Context()), req.ChatUUIDs)
+		if err != nil {
+			logger.Printf("Failed to bulk delete chats: %v", err)
+			http.Error(w, `{"error": "Failed to delete chats"}`, http.StatusInternalServerError)
+			return
+		}
+
+		deletedSet := make(map[uuid.UUID]bool, len(deleted))
+		for _, id := range deleted {
+			deletedSet[id] = true
+		}
+
+		response := BulkDeleteChatsResponse{
+			Deleted:  deleted,
+			NotFound: []uuid.UUID{},
+		}
+		for _, id := range req.ChatUUIDs {
+			if !deletedSet[id] {
+				response.NotFound = append(response.NotFound, id)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			logger.Printf("Error encoding bulk delete response: %v", err)
+		}
+	}
+}
+
+func validateUpdateChatRequest(req *UpdateChatRequest) error {
+	if req.Title == nil && req.Archived == nil && req.Settings == nil {
+		return errors.New("nothing to update, provide title, archived and/or settings")
+	}
+	if req.Settings != nil {
+		if err := validateChatSettings(req.Settings); err != nil {
+			return err
+		}
+	}
+
+	if req.Title != nil {
+		title := strings.TrimSpace(*req.Title)
+		if len([]rune(title)) > MaxChatTitleLength {
+			return fmt.Errorf("title must be at most %d characters", MaxChatTitleLength)
+		}
+		req.Title = &title
+	}
+
+	return nil
+}
+
+// parseChatUUID extracts the chatId URL parameter and writes a 400 response when it is missing or malformed
+func parseChatUUID(w http.ResponseWriter, r *http.Request, logger *log.Logger) (uuid.UUID, bool) {
+	// Extract 'chatId' from URL parameters
+	chatUUID := chi.URLParam(r, "chatId")
+	if chatUUID == "" {
+		http.Error(w, `{"error": "Chat ID is required"}`, http.StatusBadRequest)
+		return uuid.Nil, false
+	}
+
+	// Parse the provided Chat ID as UUID
+	parsedChatUUID, err := uuid.Parse(chatUUID)
+	if err != nil {
+		logger.Printf("Invalid chat UUID provided: %s", chatUUID)
+		http.Error(w, `{"error": "Invalid chat ID"}`, http.StatusBadRequest)
+		return uuid.Nil, false
+	}
+
+	return parsedChatUUID, true
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// webhookAttempts is how many times a finished job is POSTed to its webhook
+	webhookAttempts = 3
+
+	// webhookRetryDelay is the delay before the second attempt, doubled for every further one
+	webhookRetryDelay = 2 * time.Second
+)
+
+// errWebhookAddress is returned for webhooks on addresses that aren't public
+var errWebhookAddress = errors.New("webhook_url must not point to a loopback, private or link-local address")
+
+// sharedAddressSpace is the carrier-grade NAT range, which netip doesn't count as private
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// newWebhookClient returns the client webhooks are delivered with. It only
+// connects to public addresses, checked after the host is resolved so a DNS
+// name can't point it into the server's network, and doesn't follow redirects.
+func newWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: refusePrivateAddresses,
+	}
+	return &http.Client{
+		Timeout: timeout,
+		// No proxy, the dialer would check the proxy's address instead of the webhook's
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return errors.New("webhook redirects are not followed")
+		},
+	}
+}
+
+// refusePrivateAddresses is the net.Dialer Control hook of newWebhookClient
+func refusePrivateAddresses(_, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !publicAddress(addrPort.Addr()) {
+		return errWebhookAddress
+	}
+	return nil
+}
+
+// publicAddress reports whether addr can be reached by webhooks
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() &&
+		!addr.IsPrivate() &&
+		!sharedAddressSpace.Contains(addr)
+}
+
+// newWebhookSecret returns a random secret to sign the webhook of a job with
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// deliverWebhook POSTs the finished job to its webhook until it answers with a
+// 2xx status. The request carries the time it was sent in
+// X-MKit-Webhook-Timestamp and, in X-MKit-Webhook-Signature, the hex encoded
+// HMAC-SHA256 of the timestamp, a dot and the body, keyed with the job's webhook secret.
+func (j *JobRunner) deliverWebhook(jobID uuid.UUID, job Job) {
+	webhookURL, secret := job.Webhook.URL, job.Webhook.Secret
+	// The state of the delivery itself isn't part of what is delivered
+	job.Webhook = nil
+	body, err := json.Marshal(job)
+	if err != nil {
+		j.logger.Printf("Failed to encode job %s for its webhook: %v", jobID, err)
+		return
+	}
+
+	delay := webhookRetryDelay
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		err = j.postWebhook(webhookURL, secret, jobID, body)
+
+		j.mu.Lock()
+		if stored, ok := j.jobs[jobID]; ok {
+			stored.Webhook.Attempts = attempt
+			switch {
+			case err == nil:
+				stored.Webhook.Status = webhookStatusDelivered
+				stored.Webhook.Error = ""
+			case attempt == webhookAttempts:
+				stored.Webhook.Status = webhookStatusFailed
+				stored.Webhook.Error = err.Error()
+			default:
+				stored.Webhook.Error = err.Error()
+			}
+		}
+		j.mu.Unlock()
+
+		if err == nil {
+			return
+		}
+		j.logger.Printf("Webhook of job %s failed, attempt %d of %d: %v", jobID, attempt, webhookAttempts, err)
+		if attempt < webhookAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+}
+
+func (j *JobRunner) postWebhook(webhookURL, secret string, jobID uuid.UUID, body []byte) error {
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-MKit-Job-ID", jobID.String())
+	req.Header.Set("X-MKit-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-MKit-Webhook-Signature", "sha256="+signWebhook(secret, timestamp, body))
+
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// signWebhook returns the signature of a webhook request, see deliverWebhook
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
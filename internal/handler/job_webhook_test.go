@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shaharia-lab/mcp-kit/internal/config"
+)
+
+func TestSignWebhook(t *testing.T) {
+	// echo -n '1700000000.{"id":"1"}' | openssl dgst -sha256 -hmac secret
+	const want = "086f6aff7bd084c98679825129c5a64dbad88c760016d6d2c0fb123f27951d54"
+
+	got := signWebhook("secret", 1700000000, []byte(`{"id":"1"}`))
+	if len(got) != 64 {
+		t.Fatalf("signWebhook() = %q, want a hex encoded SHA-256", got)
+	}
+	if got != want {
+		t.Errorf("signWebhook() = %q, want %q", got, want)
+	}
+	if other := signWebhook("other", 1700000000, []byte(`{"id":"1"}`)); other == got {
+		t.Error("signWebhook() doesn't depend on the secret")
+	}
+	if other := signWebhook("secret", 1700000001, []byte(`{"id":"1"}`)); other == got {
+		t.Error("signWebhook() doesn't depend on the timestamp")
+	}
+}
+
+func TestPostWebhookSignsTheRequest(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	jobID := uuid.New()
+
+	var got *http.Request
+	var gotBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	runner := newTestJobRunner(config.JobsConfig{Workers: 1, QueueSize: 1})
+	// The webhook client refuses the loopback address of the test server
+	runner.client = server.Client()
+
+	if err := runner.postWebhook(server.URL, "secret", jobID, body); err != nil {
+		t.Fatalf("postWebhook() error = %v", err)
+	}
+
+	if got.Header.Get("X-MKit-Job-ID") != jobID.String() {
+		t.Errorf("X-MKit-Job-ID = %q, want %q", got.Header.Get("X-MKit-Job-ID"), jobID)
+	}
+	timestamp, err := strconv.ParseInt(got.Header.Get("X-MKit-Webhook-Timestamp"), 10, 64)
+	if err != nil {
+		t.Fatalf("X-MKit-Webhook-Timestamp = %q: %v", got.Header.Get("X-MKit-Webhook-Timestamp"), err)
+	}
+	if want := "sha256=" + signWebhook("secret", timestamp, gotBody); got.Header.Get("X-MKit-Webhook-Signature") != want {
+		t.Errorf("X-MKit-Webhook-Signature = %q, want %q", got.Header.Get("X-MKit-Webhook-Signature"), want)
+	}
+}
+
+func TestPostWebhookFailsOnErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	runner := newTestJobRunner(config.JobsConfig{Workers: 1, QueueSize: 1})
+	runner.client = server.Client()
+
+	if err := runner.postWebhook(server.URL, "secret", uuid.New(), []byte(`{}`)); err == nil {
+		t.Error("postWebhook() error = nil for a 500 answer")
+	}
+}
+
+func TestWebhookClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the webhook reached a loopback address")
+	}))
+	defer server.Close()
+
+	resp, err := newWebhookClient(time.Second).Post(server.URL, "application/json", nil)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("Post() error = nil for a loopback address")
+	}
+	if !errors.Is(err, errWebhookAddress) {
+		t.Errorf("Post() error = %v, want %v", err, errWebhookAddress)
+	}
+}
+
+func TestWebhookClientDoesNotFollowRedirects(t *testing.T) {
+	client := newWebhookClient(time.Second)
+	req := httptest.NewRequest(http.MethodPost, "https://example.com/hook", nil)
+	if err := client.CheckRedirect(req, []*http.Request{req}); err == nil {
+		t.Error("CheckRedirect() = nil, want redirects to be refused")
+	}
+}
+
+func TestPublicAddress(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34":        true,
+		"2606:2800:220:1::":    true,
+		"127.0.0.1":            false,
+		"::1":                  false,
+		"::ffff:127.0.0.1":     false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"100.64.0.1":           false,
+		"169.254.169.254":      false,
+		"fe80::1":              false,
+		"fd00::1":              false,
+		"0.0.0.0":              false,
+		"224.0.0.1":            false,
+		"::ffff:93.184.216.34": true,
+	}
+	for address, want := range tests {
+		if got := publicAddress(netip.MustParseAddr(address)); got != want {
+			t.Errorf("publicAddress(%s) = %v, want %v", address, got, want)
+		}
+	}
+}
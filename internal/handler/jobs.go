@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/shaharia-lab/mcp-kit/internal/auth"
+	"github.com/shaharia-lab/mcp-kit/internal/config"
+	"github.com/shaharia-lab/mcp-kit/internal/observability"
+)
+
+// JobStatus is the state of an asynchronously answered question
+type JobStatus string
+
+// States of a Job
+const (
+	JobStatusQueued    JobStatus = "queued"
+	JobStatusRunning   JobStatus = "running"
+	JobStatusSucceeded JobStatus = "succeeded"
+	JobStatusFailed    JobStatus = "failed"
+	JobStatusCancelled JobStatus = "cancelled"
+)
+
+// ErrJobQueueFull is returned when a job is submitted while all workers are
+// busy and the queue is full
+var ErrJobQueueFull = errors.New("too many jobs are waiting, try again later")
+
+// JobRequest is a question to answer asynchronously
+type JobRequest struct {
+	QuestionRequest
+	// WebhookURL is sent the finished job, see JobRunner
+	WebhookURL string `json:"webhook_url,omitempty"`
+}
+
+// Job is a question answered in the background. Result is set once the answer
+// is generated, Error when it failed.
+type Job struct {
+	ID          uuid.UUID   `json:"id"`
+	Status      JobStatus   `json:"status"`
+	ChatUUID    uuid.UUID   `json:"chat_uuid"`
+	CreatedAt   time.Time   `json:"created_at"`
+	StartedAt   *time.Time  `json:"started_at,omitempty"`
+	CompletedAt *time.Time  `json:"completed_at,omitempty"`
+	Result      *Response   `json:"result,omitempty"`
+	Error       string      `json:"error,omitempty"`
+	Webhook     *JobWebhook `json:"webhook,omitempty"`
+
+	owner string
+	run   func() (Response, error)
+}
+
+// JobWebhook is the delivery of a finished job to the caller's webhook. Secret
+// signs the requests to it and is only returned when the job is created.
+type JobWebhook struct {
+	URL      string `json:"url"`
+	Secret   string `json:"secret,omitempty"`
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error,omitempty"`
+}
+
+// States of a JobWebhook
+const (
+	webhookStatusPending   = "pending"
+	webhookStatusDelivered = "delivered"
+	webhookStatusFailed    = "failed"
+)
+
+// JobRunner answers the questions of jobs on a fixed number of workers. Jobs
+// wait in a bounded queue for a free worker and are kept in memory until
+// the retention period after they finished. Finished jobs with a webhook URL
+// are POSTed to it, see deliverWebhook.
+type JobRunner struct {
+	mu     sync.Mutex
+	jobs   map[uuid.UUID]*Job
+	queue  chan *Job
+	slots  chan struct{}
+	cfg    config.JobsConfig
+	client *http.Client
+	logger *log.Logger
+}
+
+// NewJobRunner creates a new JobRunner and starts its workers
+func NewJobRunner(cfg config.JobsConfig, logger *log.Logger) *JobRunner {
+	queueSize := max(cfg.QueueSize, 1)
+	runner := &JobRunner{
+		jobs:   make(map[uuid.UUID]*Job),
+		queue:  make(chan *Job, queueSize),
+		slots:  make(chan struct{}, queueSize),
+		cfg:    cfg,
+		client: newWebhookClient(cfg.WebhookTimeout),
+		logger: logger,
+	}
+
+	for i := 0; i < max(cfg.Workers, 1); i++ {
+		go runner.work()
+	}
+	return runner
+}
+
+// reserve takes a place in the queue for a job about to be submitted. It
+// returns false when the queue is full.
+func (j *JobRunner) reserve() bool {
+	select {
+	case j.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// unreserve gives back a place taken with reserve for a job that isn't submitted
+func (j *JobRunner) unreserve() {
+	<-j.slots
+}
+
+// submit queues a job running run in the place taken with reserve and returns
+// a copy of it, the only one with the secret of its webhook
+func (j *JobRunner) submit(owner string, chatUUID uuid.UUID, webhook *JobWebhook, run func() (Response, error)) Job {
+	job := &Job{
+		ID:        uuid.New(),
+		Status:    JobStatusQueued,
+		ChatUUID:  chatUUID,
+		CreatedAt: time.Now().UTC(),
+		Webhook:   webhook,
+		owner:     owner,
+		run:       run,
+	}
+
+	j.mu.Lock()
+	j.jobs[job.ID] = job
+	snapshot := job.snapshot()
+	j.mu.Unlock()
+
+	observability.JobsQueued.Inc()
+	j.queue <- job
+	return snapshot
+}
+
+// Get returns a copy of the job if it is owned by owner
+func (j *JobRunner) Get(id uuid.UUID, owner string) (Job, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	job, ok := j.jobs[id]
+	if !ok || job.owner != owner {
+		return Job{}, false
+	}
+	snapshot := job.snapshot()
+	if snapshot.Webhook != nil {
+		snapshot.Webhook.Secret = ""
+	}
+	return snapshot, true
+}
+
+func (j *JobRunner) work() {
+	for job := range j.queue {
+		<-j.slots
+		observability.JobsQueued.Dec()
+		j.execute(job)
+	}
+}
+
+func (j *JobRunner) execute(job *Job) {
+	j.mu.Lock()
+	startedAt := time.Now().UTC()
+	job.Status = JobStatusRunning
+	job.StartedAt = &startedAt
+	j.mu.Unlock()
+
+	response, err := job.run()
+
+	j.mu.Lock()
+	completedAt := time.Now().UTC()
+	job.CompletedAt = &completedAt
+	job.run = nil
+	switch {
+	case err != nil:
+		_, failure := generationError(err)
+		job.Status = JobStatusFailed
+		job.Error = failure.Error
+		// An answer that doesn't match the response format is returned anyway
+		if response.ChatUUID != uuid.Nil {
+			job.Result = &response
+		}
+	case response.Cancelled:
+		job.Status = JobStatusCancelled
+		job.Result = &response
+	default:
+		job.Status = JobStatusSucceeded
+		job.Result = &response
+	}
+	finished := job.snapshot()
+	j.mu.Unlock()
+
+	observability.JobsTotal.WithLabelValues(string(finished.Status)).Inc()
+	if finished.Webhook != nil {
+		go j.deliverWebhook(job.ID, finished)
+	}
+
+	time.AfterFunc(j.cfg.Retention, func() {
+		j.mu.Lock()
+		delete(j.jobs, job.ID)
+		j.mu.Unlock()
+	})
+}
+
+// snapshot returns a copy of the job that is safe to use without the lock
+func (job *Job) snapshot() Job {
+	snapshot := *job
+	if job.Webhook != nil {
+		webhook := *job.Webhook
+		snapshot.Webhook = &webhook
+	}
+	return snapshot
+}
+
+// validateWebhookURL checks the webhook of a job. Hosts are resolved when the
+// webhook is delivered, see newWebhookClient, only IP addresses are checked here.
+func validateWebhookURL(webhookURL string, cfg config.JobsConfig) error {
+	if webhookURL == "" {
+		return nil
+	}
+	if !cfg.WebhooksEnabled {
+		return errors.New("webhooks are not enabled on this server")
+	}
+
+	parsed, err := url.Parse(webhookURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return errors.New("webhook_url must be an absolute http or https URL")
+	}
+	if addr, err := netip.ParseAddr(parsed.Hostname()); err == nil && !publicAddress(addr) {
+		return errWebhookAddress
+	}
+	return nil
+}
+
+// CreateJobHandler Handler to answer a question in the background. The question
+// is validated and saved right away, the answer is generated once a worker is
+// free and can be fetched from GetJobHandler.
+func CreateJobHandler(deps ChatDependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobs := deps.Jobs
+
+		var req JobRequest
+		attachments, err := decodeRequestWithAttachments(r, &req)
+		if err != nil {
+			writeErrorResponse(w, requestErrorStatus(err), err.Error(), err, r.Context())
+			return
+		}
+		req.Attachments = append(req.Attachments, attachments...)
+
+		if err := validateWebhookURL(req.WebhookURL, jobs.cfg); err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, err.Error(), err, r.Context())
+			return
+		}
+		var webhook *JobWebhook
+		if req.WebhookURL != "" {
+			secret, err := newWebhookSecret()
+			if err != nil {
+				writeErrorResponse(w, http.StatusInternalServerError, "Failed to create webhook secret", err, r.Context())
+				return
+			}
+			webhook = &JobWebhook{URL: req.WebhookURL, Secret: secret, Status: webhookStatusPending}
+		}
+
+		// The place in the queue is taken first, so a rejected job leaves no question behind
+		if !jobs.reserve() {
+			writeErrorResponse(w, http.StatusServiceUnavailable, ErrJobQueueFull.Error(), ErrJobQueueFull, r.Context())
+			return
+		}
+
+		reqCtx, err := prepareQuestion(r.Context(), req.QuestionRequest, deps, "create_job", false, nil)
+		if err != nil {
+			jobs.unreserve()
+			writeErrorResponse(w, requestErrorStatus(err), err.Error(), err, r.Context())
+			return
+		}
+
+		job := jobs.submit(auth.SubjectFromContext(r.Context()), reqCtx.chat.UUID, webhook, func() (Response, error) {
+			defer reqCtx.span.End()
+			defer reqCtx.release()
+
+			return answerQuestion(reqCtx, deps.StructuredOutput.MaxRetries)
+		})
+		observability.AddAttribute(reqCtx.ctx, "job.id", job.ID.String())
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", fmt.Sprintf("/api/v1/jobs/%s", job.ID))
+		w.WriteHeader(http.StatusAccepted)
+		if err := json.NewEncoder(w).Encode(job); err != nil {
+			deps.Logger.Printf("Error encoding job: %v", err)
+		}
+	}
+}
+
+// GetJobHandler Handler to get the status and the result of a job of the authenticated caller
+func GetJobHandler(logger *log.Logger, jobs *JobRunner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobID, err := uuid.Parse(chi.URLParam(r, "jobId"))
+		if err != nil {
+			logger.Printf("Invalid job ID provided: %s", chi.URLParam(r, "jobId"))
+			http.Error(w, `{"error": "Invalid job ID"}`, http.StatusBadRequest)
+			return
+		}
+
+		job, ok := jobs.Get(jobID, auth.SubjectFromContext(r.Context()))
+		if !ok {
+			http.Error(w, `{"error": "Job not found"}`, http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(job); err != nil {
+			logger.Printf("Error encoding job: %v", err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shaharia-lab/mcp-kit/internal/config"
+)
+
+func newTestJobRunner(cfg config.JobsConfig) *JobRunner {
+	return NewJobRunner(cfg, log.New(io.Discard, "", 0))
+}
+
+// waitFor polls cond until it holds or a second has passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestJobRunnerRejectsJobsWhenQueueIsFull(t *testing.T) {
+	runner := newTestJobRunner(config.JobsConfig{Workers: 1, QueueSize: 1, Retention: time.Minute})
+
+	unblock := make(chan struct{})
+	defer close(unblock)
+	blocked := func() (Response, error) {
+		<-unblock
+		return Response{}, nil
+	}
+
+	if !runner.reserve() {
+		t.Fatal("reserve() = false for the first job, want true")
+	}
+	running := runner.submit("owner", uuid.New(), nil, blocked)
+	waitFor(t, "the first job to run", func() bool {
+		job, _ := runner.Get(running.ID, "owner")
+		return job.Status == JobStatusRunning
+	})
+
+	if !runner.reserve() {
+		t.Fatal("reserve() = false while the queue is empty, want true")
+	}
+	queued := runner.submit("owner", uuid.New(), nil, blocked)
+	if queued.Status != JobStatusQueued {
+		t.Errorf("Status = %q, want %q", queued.Status, JobStatusQueued)
+	}
+
+	if runner.reserve() {
+		t.Fatal("reserve() = true while the queue is full, want false")
+	}
+}
+
+func TestJobRunnerUnreserveFreesThePlace(t *testing.T) {
+	runner := newTestJobRunner(config.JobsConfig{Workers: 1, QueueSize: 1, Retention: time.Minute})
+
+	if !runner.reserve() {
+		t.Fatal("reserve() = false, want true")
+	}
+	runner.unreserve()
+	if !runner.reserve() {
+		t.Fatal("reserve() = false after unreserve, want true")
+	}
+}
+
+func TestJobRunnerForgetsJobsAfterRetention(t *testing.T) {
+	runner := newTestJobRunner(config.JobsConfig{Workers: 1, QueueSize: 1, Retention: 20 * time.Millisecond})
+
+	runner.reserve()
+	job := runner.submit("owner", uuid.New(), nil, func() (Response, error) {
+		return Response{Answer: "42"}, nil
+	})
+
+	waitFor(t, "the job to succeed", func() bool {
+		got, _ := runner.Get(job.ID, "owner")
+		return got.Status == JobStatusSucceeded
+	})
+	got, _ := runner.Get(job.ID, "owner")
+	if got.Result == nil || got.Result.Answer != "42" {
+		t.Errorf("Result = %+v, want the answer 42", got.Result)
+	}
+
+	waitFor(t, "the job to be forgotten", func() bool {
+		_, ok := runner.Get(job.ID, "owner")
+		return !ok
+	})
+}
+
+func TestJobRunnerGetChecksTheOwner(t *testing.T) {
+	runner := newTestJobRunner(config.JobsConfig{Workers: 1, QueueSize: 1, Retention: time.Minute})
+
+	runner.reserve()
+	job := runner.submit("owner", uuid.New(), nil, func() (Response, error) {
+		return Response{}, nil
+	})
+
+	if _, ok := runner.Get(job.ID, "someone else"); ok {
+		t.Error("Get() found the job of another owner")
+	}
+	if _, ok := runner.Get(job.ID, "owner"); !ok {
+		t.Error("Get() didn't find the job of its owner")
+	}
+}
+
+func TestJobRunnerReturnsWebhookSecretOnlyOnSubmit(t *testing.T) {
+	runner := newTestJobRunner(config.JobsConfig{Workers: 1, QueueSize: 1, Retention: time.Minute})
+
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	runner.reserve()
+	webhook := &JobWebhook{URL: "https://example.com/hook", Secret: "secret", Status: webhookStatusPending}
+	job := runner.submit("owner", uuid.New(), webhook, func() (Response, error) {
+		<-unblock
+		return Response{}, nil
+	})
+	if job.Webhook == nil || job.Webhook.Secret != "secret" {
+		t.Fatalf("submit() Webhook = %+v, want the secret", job.Webhook)
+	}
+
+	got, _ := runner.Get(job.ID, "owner")
+	if got.Webhook == nil || got.Webhook.Secret != "" {
+		t.Errorf("Get() Webhook = %+v, want it without the secret", got.Webhook)
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	enabled := config.JobsConfig{WebhooksEnabled: true}
+
+	tests := []struct {
+		name    string
+		url     string
+		cfg     config.JobsConfig
+		wantErr bool
+	}{
+		{name: "no webhook", url: "", cfg: config.JobsConfig{}},
+		{name: "public host", url: "https://example.com/hook", cfg: enabled},
+		{name: "public address", url: "http://93.184.216.34/hook", cfg: enabled},
+		{name: "webhooks disabled", url: "https://example.com/hook", cfg: config.JobsConfig{}, wantErr: true},
+		{name: "other scheme", url: "ftp://example.com/hook", cfg: enabled, wantErr: true},
+		{name: "relative", url: "/hook", cfg: enabled, wantErr: true},
+		{name: "loopback", url: "http://127.0.0.1:8080/hook", cfg: enabled, wantErr: true},
+		{name: "IPv6 loopback", url: "http://[::1]/hook", cfg: enabled, wantErr: true},
+		{name: "private", url: "http://10.0.0.1/hook", cfg: enabled, wantErr: true},
+		{name: "link-local", url: "http://169.254.169.254/latest/meta-data", cfg: enabled, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateWebhookURL(tt.url, tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateWebhookURL(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
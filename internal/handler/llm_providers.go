@@ -6,6 +6,7 @@ import (
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/openai/openai-go"
+	"github.com/shaharia-lab/mcp-kit/internal/service/llm"
 )
 
 // Model represents an LLM model's information
@@ -13,6 +14,12 @@ type Model struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	ModelID     string `json:"modelId"`
+	// ContextWindow is the maximum number of tokens (input and output) the model accepts
+	ContextWindow int `json:"contextWindow"`
+	// AttachmentTypes are the content types of the files that can be attached to questions
+	AttachmentTypes []string `json:"attachmentTypes"`
+	// StructuredOutput is how answers in JSON are enforced, see QuestionRequest.ResponseFormat
+	StructuredOutput llm.StructuredOutputMode `json:"structuredOutput"`
 }
 
 // Provider represents an LLM provider and its available models
@@ -27,16 +34,22 @@ type SupportedLLMProviders struct {
 }
 
 func (s SupportedLLMProviders) IsSupported(providerName string, modelID string) bool {
+	_, ok := s.GetModel(providerName, modelID)
+	return ok
+}
+
+// GetModel returns the catalog entry of a provider's model
+func (s SupportedLLMProviders) GetModel(providerName string, modelID string) (Model, bool) {
 	for _, provider := range s.Providers {
 		if provider.Name == providerName {
 			for _, model := range provider.Models {
 				if model.ModelID == modelID {
-					return true
+					return model, true
 				}
 			}
 		}
 	}
-	return false
+	return Model{}, false
 }
 
 // getLLMProviders retrieves a list of supported LLM providers.
@@ -47,64 +60,100 @@ func getLLMProviders() SupportedLLMProviders {
 				Name: "Anthropic",
 				Models: []Model{
 					{
-						Name:        "Claude 3.5 Haiku Latest",
-						Description: "Fast and cost-effective model",
-						ModelID:     anthropic.ModelClaude3_5HaikuLatest,
+						Name:             "Claude 3.5 Haiku Latest",
+						Description:      "Fast and cost-effective model",
+						ModelID:          anthropic.ModelClaude3_5HaikuLatest,
+						ContextWindow:    200000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputTool,
 					},
 					{
-						Name:        "Claude 3.5 Haiku 2024-10-22",
-						Description: "Fast and cost-effective model",
-						ModelID:     anthropic.ModelClaude3_5Haiku20241022,
+						Name:             "Claude 3.5 Haiku 2024-10-22",
+						Description:      "Fast and cost-effective model",
+						ModelID:          anthropic.ModelClaude3_5Haiku20241022,
+						ContextWindow:    200000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputTool,
 					},
 					{
-						Name:        "Claude 3.7 Sonnet",
-						Description: "Most intelligent model from Anthropic",
-						ModelID:     anthropic.ModelClaude3_7SonnetLatest,
+						Name:             "Claude 3.7 Sonnet",
+						Description:      "Most intelligent model from Anthropic",
+						ModelID:          anthropic.ModelClaude3_7SonnetLatest,
+						ContextWindow:    200000,
+						AttachmentTypes:  visionAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputTool,
 					},
 					{
-						Name:        "Claude 3.5 Sonnet Latest",
-						Description: "Our most intelligent model",
-						ModelID:     anthropic.ModelClaude3_5SonnetLatest,
+						Name:             "Claude 3.5 Sonnet Latest",
+						Description:      "Our most intelligent model",
+						ModelID:          anthropic.ModelClaude3_5SonnetLatest,
+						ContextWindow:    200000,
+						AttachmentTypes:  visionAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputTool,
 					},
 					{
-						Name:        "Claude 3.5 Sonnet 2024-10-22",
-						Description: "Our most intelligent model",
-						ModelID:     anthropic.ModelClaude3_5Sonnet20241022,
+						Name:             "Claude 3.5 Sonnet 2024-10-22",
+						Description:      "Our most intelligent model",
+						ModelID:          anthropic.ModelClaude3_5Sonnet20241022,
+						ContextWindow:    200000,
+						AttachmentTypes:  visionAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputTool,
 					},
 					{
-						Name:        "Claude 3.5 Sonnet 2024-06-20",
-						Description: "Our previous most intelligent model",
-						ModelID:     anthropic.ModelClaude_3_5_Sonnet_20240620,
+						Name:             "Claude 3.5 Sonnet 2024-06-20",
+						Description:      "Our previous most intelligent model",
+						ModelID:          anthropic.ModelClaude_3_5_Sonnet_20240620,
+						ContextWindow:    200000,
+						AttachmentTypes:  visionAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputTool,
 					},
 					{
-						Name:        "Claude 3 Opus Latest",
-						Description: "Excels at writing and complex tasks",
-						ModelID:     anthropic.ModelClaude3OpusLatest,
+						Name:             "Claude 3 Opus Latest",
+						Description:      "Excels at writing and complex tasks",
+						ModelID:          anthropic.ModelClaude3OpusLatest,
+						ContextWindow:    200000,
+						AttachmentTypes:  visionAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputTool,
 					},
 					{
-						Name:        "Claude 3 Opus 2024-02-29",
-						Description: "Excels at writing and complex tasks",
-						ModelID:     anthropic.ModelClaude_3_Opus_20240229,
+						Name:             "Claude 3 Opus 2024-02-29",
+						Description:      "Excels at writing and complex tasks",
+						ModelID:          anthropic.ModelClaude_3_Opus_20240229,
+						ContextWindow:    200000,
+						AttachmentTypes:  visionAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputTool,
 					},
 					{
-						Name:        "Claude 3 Sonnet 2024-02-29",
-						Description: "Balance of speed and intelligence",
-						ModelID:     anthropic.ModelClaude_3_Sonnet_20240229,
+						Name:             "Claude 3 Sonnet 2024-02-29",
+						Description:      "Balance of speed and intelligence",
+						ModelID:          anthropic.ModelClaude_3_Sonnet_20240229,
+						ContextWindow:    200000,
+						AttachmentTypes:  visionAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputTool,
 					},
 					{
-						Name:        "Claude 3 Haiku 2024-03-07",
-						Description: "Our previous fast and cost-effective",
-						ModelID:     anthropic.ModelClaude_3_Haiku_20240307,
+						Name:             "Claude 3 Haiku 2024-03-07",
+						Description:      "Our previous fast and cost-effective",
+						ModelID:          anthropic.ModelClaude_3_Haiku_20240307,
+						ContextWindow:    200000,
+						AttachmentTypes:  visionAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputTool,
 					},
 					{
-						Name:        "Claude 2.1",
-						Description: "Powerful language model for general-purpose tasks",
-						ModelID:     anthropic.ModelClaude_2_1,
+						Name:             "Claude 2.1",
+						Description:      "Powerful language model for general-purpose tasks",
+						ModelID:          anthropic.ModelClaude_2_1,
+						ContextWindow:    200000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "Claude 2.0",
-						Description: "Advanced language model optimized for reliability and thoughtful responses",
-						ModelID:     anthropic.ModelClaude_2_0,
+						Name:             "Claude 2.0",
+						Description:      "Advanced language model optimized for reliability and thoughtful responses",
+						ModelID:          anthropic.ModelClaude_2_0,
+						ContextWindow:    100000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 				},
 			},
@@ -112,34 +161,52 @@ func getLLMProviders() SupportedLLMProviders {
 				Name: "OpenAI",
 				Models: []Model{
 					{
-						Name:        "GPT-4o Latest",
-						Description: "Latest GPT-4o model",
-						ModelID:     openai.ChatModelChatgpt4oLatest,
+						Name:             "GPT-4o Latest",
+						Description:      "Latest GPT-4o model",
+						ModelID:          openai.ChatModelChatgpt4oLatest,
+						ContextWindow:    128000,
+						AttachmentTypes:  visionAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "GPT-4o Mini",
-						Description: "Optimized GPT-4o Mini model",
-						ModelID:     openai.ChatModelGPT4oMini,
+						Name:             "GPT-4o Mini",
+						Description:      "Optimized GPT-4o Mini model",
+						ModelID:          openai.ChatModelGPT4oMini,
+						ContextWindow:    128000,
+						AttachmentTypes:  visionAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputJSONSchema,
 					},
 					{
-						Name:        "GPT-4",
-						Description: "Standard GPT-4 model",
-						ModelID:     openai.ChatModelGPT4,
+						Name:             "GPT-4",
+						Description:      "Standard GPT-4 model",
+						ModelID:          openai.ChatModelGPT4,
+						ContextWindow:    8192,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "GPT-4 Turbo",
-						Description: "Most capable GPT-4 model for various tasks",
-						ModelID:     openai.ChatModelGPT4Turbo,
+						Name:             "GPT-4 Turbo",
+						Description:      "Most capable GPT-4 model for various tasks",
+						ModelID:          openai.ChatModelGPT4Turbo,
+						ContextWindow:    128000,
+						AttachmentTypes:  visionAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputJSONObject,
 					},
 					{
-						Name:        "GPT-3.5 Turbo",
-						Description: "Efficient model balancing performance and speed",
-						ModelID:     openai.ChatModelGPT3_5Turbo,
+						Name:             "GPT-3.5 Turbo",
+						Description:      "Efficient model balancing performance and speed",
+						ModelID:          openai.ChatModelGPT3_5Turbo,
+						ContextWindow:    16385,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputJSONObject,
 					},
 					{
-						Name:        "GPT-4.5 Preview",
-						Description: "Last GPT-4.5 model from OpenAI",
-						ModelID:     openai.ChatModelGPT4_5Preview,
+						Name:             "GPT-4.5 Preview",
+						Description:      "Last GPT-4.5 model from OpenAI",
+						ModelID:          openai.ChatModelGPT4_5Preview,
+						ContextWindow:    128000,
+						AttachmentTypes:  visionAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputJSONSchema,
 					},
 				},
 			},
@@ -147,114 +214,180 @@ func getLLMProviders() SupportedLLMProviders {
 				Name: "Amazon Bedrock",
 				Models: []Model{
 					{
-						Name:        "Claude 3 Haiku 2024-03-07",
-						Description: "Optimized for quick, detailed responses",
-						ModelID:     "anthropic.claude-3-haiku-20240307-v1:0",
+						Name:             "Claude 3 Haiku 2024-03-07",
+						Description:      "Optimized for quick, detailed responses",
+						ModelID:          "anthropic.claude-3-haiku-20240307-v1:0",
+						ContextWindow:    200000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "Claude 3 Opus 2024-02-29",
-						Description: "Excels at writing and complex tasks",
-						ModelID:     "anthropic.claude-3-opus-20240229-v1:0",
+						Name:             "Claude 3 Opus 2024-02-29",
+						Description:      "Excels at writing and complex tasks",
+						ModelID:          "anthropic.claude-3-opus-20240229-v1:0",
+						ContextWindow:    200000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "Claude 3 Sonnet 2024-02-29",
-						Description: "Balanced performance and intelligence",
-						ModelID:     "anthropic.claude-3-sonnet-20240229-v1:0",
+						Name:             "Claude 3 Sonnet 2024-02-29",
+						Description:      "Balanced performance and intelligence",
+						ModelID:          "anthropic.claude-3-sonnet-20240229-v1:0",
+						ContextWindow:    200000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "Claude 3.5 Haiku 2024-10-22",
-						Description: "Our most recent fast and cost-effective model",
-						ModelID:     "anthropic.claude-3-5-haiku-20241022-v1:0",
+						Name:             "Claude 3.5 Haiku 2024-10-22",
+						Description:      "Our most recent fast and cost-effective model",
+						ModelID:          "anthropic.claude-3-5-haiku-20241022-v1:0",
+						ContextWindow:    200000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "Claude 3.5 Sonnet 2024-10-22",
-						Description: "Intelligent and fine-tuned for deep tasks",
-						ModelID:     "anthropic.claude-3-5-sonnet-20241022-v2:0",
+						Name:             "Claude 3.5 Sonnet 2024-10-22",
+						Description:      "Intelligent and fine-tuned for deep tasks",
+						ModelID:          "anthropic.claude-3-5-sonnet-20241022-v2:0",
+						ContextWindow:    200000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "Claude 3.5 Sonnet 2024-06-20",
-						Description: "Balanced for intelligent and previous updates",
-						ModelID:     "anthropic.claude-3-5-sonnet-20240620-v1:0",
+						Name:             "Claude 3.5 Sonnet 2024-06-20",
+						Description:      "Balanced for intelligent and previous updates",
+						ModelID:          "anthropic.claude-3-5-sonnet-20240620-v1:0",
+						ContextWindow:    200000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "Claude 3.7 Sonnet",
-						Description: "Latest best model from Anthropic",
-						ModelID:     "anthropic.claude-3-7-sonnet-20250219-v1:0",
+						Name:             "Claude 3.7 Sonnet",
+						Description:      "Latest best model from Anthropic",
+						ModelID:          "anthropic.claude-3-7-sonnet-20250219-v1:0",
+						ContextWindow:    200000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "Titan Text G1 - Express",
-						Description: "Amazon's express text model for versatile use cases",
-						ModelID:     "amazon.titan-text-express-v1",
+						Name:             "Titan Text G1 - Express",
+						Description:      "Amazon's express text model for versatile use cases",
+						ModelID:          "amazon.titan-text-express-v1",
+						ContextWindow:    8192,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "Cohere: Command R+",
-						Description: "Advanced command response model",
-						ModelID:     "cohere.command-r-plus-v1:0",
+						Name:             "Cohere: Command R+",
+						Description:      "Advanced command response model",
+						ModelID:          "cohere.command-r-plus-v1:0",
+						ContextWindow:    128000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "Cohere: Command R",
-						Description: "Command-response optimized model",
-						ModelID:     "cohere.command-r-v1:0",
+						Name:             "Cohere: Command R",
+						Description:      "Command-response optimized model",
+						ModelID:          "cohere.command-r-v1:0",
+						ContextWindow:    128000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "Llama 3 8B Instruct",
-						Description: "Meta's mid-range instruct model",
-						ModelID:     "meta.llama3-8b-instruct-v1:0",
+						Name:             "Llama 3 8B Instruct",
+						Description:      "Meta's mid-range instruct model",
+						ModelID:          "meta.llama3-8b-instruct-v1:0",
+						ContextWindow:    8192,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "Llama 3 70B Instruct",
-						Description: "Meta's large instruct model",
-						ModelID:     "meta.llama3-70b-instruct-v1:0",
+						Name:             "Llama 3 70B Instruct",
+						Description:      "Meta's large instruct model",
+						ModelID:          "meta.llama3-70b-instruct-v1:0",
+						ContextWindow:    8192,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "Llama 3.1 8B Instruct",
-						Description: "Updated 8B instruct model by Meta",
-						ModelID:     "meta.llama3-1-8b-instruct-v1:0",
+						Name:             "Llama 3.1 8B Instruct",
+						Description:      "Updated 8B instruct model by Meta",
+						ModelID:          "meta.llama3-1-8b-instruct-v1:0",
+						ContextWindow:    128000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "Llama 3.1 70B Instruct",
-						Description: "Updated comprehensive instruct model by Meta",
-						ModelID:     "meta.llama3-1-70b-instruct-v1:0",
+						Name:             "Llama 3.1 70B Instruct",
+						Description:      "Updated comprehensive instruct model by Meta",
+						ModelID:          "meta.llama3-1-70b-instruct-v1:0",
+						ContextWindow:    128000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "Llama 3.1 405B Instruct",
-						Description: "Meta's groundbreaking large instruct model",
-						ModelID:     "meta.llama3-1-405b-instruct-v1:0",
+						Name:             "Llama 3.1 405B Instruct",
+						Description:      "Meta's groundbreaking large instruct model",
+						ModelID:          "meta.llama3-1-405b-instruct-v1:0",
+						ContextWindow:    128000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "Llama 3.2 1B Instruct",
-						Description: "Compact instruct model for lightweight tasks",
-						ModelID:     "meta.llama3-2-1b-instruct-v1:0",
+						Name:             "Llama 3.2 1B Instruct",
+						Description:      "Compact instruct model for lightweight tasks",
+						ModelID:          "meta.llama3-2-1b-instruct-v1:0",
+						ContextWindow:    128000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "Llama 3.2 3B Instruct",
-						Description: "Balanced model for intelligence and agility",
-						ModelID:     "meta.llama3-2-3b-instruct-v1:0",
+						Name:             "Llama 3.2 3B Instruct",
+						Description:      "Balanced model for intelligence and agility",
+						ModelID:          "meta.llama3-2-3b-instruct-v1:0",
+						ContextWindow:    128000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "Llama 3.2 11B Instruct",
-						Description: "High-precision instruct model at 11B scale",
-						ModelID:     "meta.llama3-2-11b-instruct-v1:0",
+						Name:             "Llama 3.2 11B Instruct",
+						Description:      "High-precision instruct model at 11B scale",
+						ModelID:          "meta.llama3-2-11b-instruct-v1:0",
+						ContextWindow:    128000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "Llama 3.2 90B Instruct",
-						Description: "Meta's premier 90B-scale instruct model",
-						ModelID:     "meta.llama3-2-90b-instruct-v1:0",
+						Name:             "Llama 3.2 90B Instruct",
+						Description:      "Meta's premier 90B-scale instruct model",
+						ModelID:          "meta.llama3-2-90b-instruct-v1:0",
+						ContextWindow:    128000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "Llama 3.3 70B Instruct",
-						Description: "Meta's latest iteration of 70B instruct",
-						ModelID:     "meta.llama3-3-70b-instruct-v1:0",
+						Name:             "Llama 3.3 70B Instruct",
+						Description:      "Meta's latest iteration of 70B instruct",
+						ModelID:          "meta.llama3-3-70b-instruct-v1:0",
+						ContextWindow:    128000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "Mistral 7B Instruct",
-						Description: "Compact yet powerful instruct model by MistralAI",
-						ModelID:     "mistral.mistral-7b-instruct-v0:2",
+						Name:             "Mistral 7B Instruct",
+						Description:      "Compact yet powerful instruct model by MistralAI",
+						ModelID:          "mistral.mistral-7b-instruct-v0:2",
+						ContextWindow:    32000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 					{
-						Name:        "Mistral Large (24.02)",
-						Description: "Latest large model optimized by MistralAI",
-						ModelID:     "mistral.mistral-large-2402-v1:0",
+						Name:             "Mistral Large (24.02)",
+						Description:      "Latest large model optimized by MistralAI",
+						ModelID:          "mistral.mistral-large-2402-v1:0",
+						ContextWindow:    32000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 				},
 			},
@@ -262,14 +395,20 @@ func getLLMProviders() SupportedLLMProviders {
 				Name: "DeepSeek",
 				Models: []Model{
 					{
-						Name:        "DeepSeek Chat",
-						Description: "Conversational AI model optimized for interactive chats",
-						ModelID:     "deepseek-chat",
+						Name:             "DeepSeek Chat",
+						Description:      "Conversational AI model optimized for interactive chats",
+						ModelID:          "deepseek-chat",
+						ContextWindow:    64000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputJSONObject,
 					},
 					{
-						Name:        "DeepSeek Reasoner",
-						Description: "Advanced reasoning model for analytical tasks",
-						ModelID:     "deepseek-reasoner",
+						Name:             "DeepSeek Reasoner",
+						Description:      "Advanced reasoning model for analytical tasks",
+						ModelID:          "deepseek-reasoner",
+						ContextWindow:    64000,
+						AttachmentTypes:  textAttachmentTypes,
+						StructuredOutput: llm.StructuredOutputPrompt,
 					},
 				},
 			},
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shaharia-lab/mcp-kit/internal/config"
+	"github.com/shaharia-lab/mcp-kit/internal/observability"
+	"github.com/shaharia-lab/mcp-kit/internal/service/llm"
+)
+
+// ErrorResponse is the body of a failed answer. Type and RetryAfter are set
+// when the model's provider failed, see generationError.
+type ErrorResponse struct {
+	Error string         `json:"error"`
+	Type  llm.ErrorClass `json:"type,omitempty"`
+	// RetryAfter is how many seconds to wait before asking again
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+type providerErrorResponse struct {
+	status  int
+	message string
+}
+
+// providerErrors are the status and message of each class of provider error.
+// Rejected credentials are the server's fault, not the caller's.
+var providerErrors = map[llm.ErrorClass]providerErrorResponse{
+	llm.ErrorClassRateLimit:      {http.StatusTooManyRequests, "The model's provider is rate limiting requests, try again later"},
+	llm.ErrorClassOverloaded:     {http.StatusBadGateway, "The model's provider is overloaded or unavailable, try again later"},
+	llm.ErrorClassAuth:           {http.StatusBadGateway, "The model's provider rejected the server's credentials"},
+	llm.ErrorClassBadRequest:     {http.StatusBadRequest, "The model's provider rejected the request"},
+	llm.ErrorClassContextTooLong: {http.StatusRequestEntityTooLarge, "The conversation is too long for the model's context window"},
+}
+
+// generationError returns the status and the body of a failed generation.
+// Errors that don't come from the provider are internal errors.
+func generationError(err error) (int, ErrorResponse) {
+	details, ok := llm.ClassifyError(err)
+	if !ok {
+		return http.StatusInternalServerError, ErrorResponse{Error: err.Error()}
+	}
+
+	response := providerErrors[details.Class]
+	return response.status, ErrorResponse{
+		Error:      response.message,
+		Type:       details.Class,
+		RetryAfter: int(math.Ceil(details.RetryAfter.Seconds())),
+	}
+}
+
+// writeGenerationError writes the error of a failed generation, see generationError
+func writeGenerationError(w http.ResponseWriter, err error, ctx context.Context) {
+	status, response := generationError(err)
+	observability.AddAttribute(ctx, "error", err.Error())
+	if response.Type != "" {
+		observability.AddAttribute(ctx, "error.class", string(response.Type))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if response.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(response.RetryAfter))
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+func retryPolicy(cfg config.RetryConfig) llm.RetryPolicy {
+	return llm.RetryPolicy{
+		MaxAttempts:  cfg.MaxAttempts,
+		InitialDelay: cfg.InitialDelay,
+		MaxDelay:     cfg.MaxDelay,
+	}
+}
+
+// recordRetries returns the callback logging and counting the retried calls to model
+func recordRetries(model LLMProvider, logger *log.Logger) func(llm.ErrorDetails, error, time.Duration) {
+	return func(details llm.ErrorDetails, err error, delay time.Duration) {
+		logger.Printf("%s %s failed with %s, retrying in %s: %v", model.Provider, model.ModelID, details.Class, delay, err)
+		observability.LLMRetriesTotal.WithLabelValues(model.Provider, model.ModelID, string(details.Class)).Inc()
+	}
+}
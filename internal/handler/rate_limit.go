@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/shaharia-lab/mcp-kit/internal/config"
+	"golang.org/x/time/rate"
+)
+
+// ProviderRateLimiter spaces out the requests sent to the providers that have
+// a rate limit configured, one request being one call to the provider's API.
+// Other providers are not limited.
+type ProviderRateLimiter struct {
+	limiters map[string]*rate.Limiter
+}
+
+// NewProviderRateLimiter creates a new ProviderRateLimiter
+func NewProviderRateLimiter(limits map[string]config.RateLimitConfig) *ProviderRateLimiter {
+	limiters := make(map[string]*rate.Limiter)
+	for provider, limit := range limits {
+		if limit.RequestsPerMinute <= 0 {
+			continue
+		}
+		limiters[strings.ToLower(provider)] = rate.NewLimiter(rate.Limit(float64(limit.RequestsPerMinute)/60), max(limit.Burst, 1))
+	}
+	return &ProviderRateLimiter{limiters: limiters}
+}
+
+// Wait blocks until a request may be sent to the provider or ctx is done
+func (l *ProviderRateLimiter) Wait(ctx context.Context, provider string) error {
+	if l == nil {
+		return nil
+	}
+
+	limiter, ok := l.limiters[strings.ToLower(provider)]
+	if !ok {
+		return nil
+	}
+	return limiter.Wait(ctx)
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/shaharia-lab/goai"
+	"github.com/shaharia-lab/mcp-kit/internal/observability"
+	"github.com/shaharia-lab/mcp-kit/internal/service/llm"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// Types of ResponseFormat
+const (
+	ResponseFormatJSONSchema = "json_schema"
+	ResponseFormatJSONObject = "json_object"
+)
+
+// ErrInvalidStructuredOutput is returned when the answer doesn't match the
+// response format after all retries
+var ErrInvalidStructuredOutput = errors.New("answer doesn't match the response format")
+
+// responseFormatName is what providers accept as the name of a schema
+var responseFormatName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// ResponseFormat asks for the answer as JSON. The json_schema type requires an
+// answer matching Schema, json_object any JSON object. The parsed answer is
+// returned as Response.Output.
+type ResponseFormat struct {
+	Type   string          `json:"type"`
+	Name   string          `json:"name,omitempty"`
+	Schema json.RawMessage `json:"schema,omitempty"`
+}
+
+// validateResponseFormat checks the response format and compiles its schema
+func validateResponseFormat(format *ResponseFormat) error {
+	if format == nil {
+		return nil
+	}
+
+	switch format.Type {
+	case ResponseFormatJSONSchema:
+		if len(format.Schema) == 0 {
+			return errors.New("response_format.schema is required for json_schema")
+		}
+	case ResponseFormatJSONObject:
+		if len(format.Schema) != 0 {
+			return errors.New("response_format.schema is only allowed for json_schema")
+		}
+	default:
+		return fmt.Errorf("unsupported response_format.type %q", format.Type)
+	}
+
+	if format.Name != "" && !responseFormatName.MatchString(format.Name) {
+		return errors.New("response_format.name must be at most 64 letters, digits, underscores or dashes")
+	}
+
+	_, err := format.compile()
+	return err
+}
+
+// compile returns the schema answers are validated against
+func (f *ResponseFormat) compile() (*gojsonschema.Schema, error) {
+	if f.Type == ResponseFormatJSONObject {
+		return gojsonschema.NewSchema(gojsonschema.NewStringLoader(`{"type": "object"}`))
+	}
+
+	var schema map[string]any
+	if err := json.Unmarshal(f.Schema, &schema); err != nil {
+		return nil, fmt.Errorf("response_format.schema must be a JSON object: %w", err)
+	}
+	// Remote references would make the server fetch arbitrary URLs
+	if err := checkLocalRefs(schema); err != nil {
+		return nil, err
+	}
+
+	compiled, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(schema))
+	if err != nil {
+		return nil, fmt.Errorf("invalid response_format.schema: %w", err)
+	}
+	return compiled, nil
+}
+
+func checkLocalRefs(v any) error {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if ref, ok := value.(string); ok && key == "$ref" && !strings.HasPrefix(ref, "#") {
+				return fmt.Errorf("response_format.schema may only reference definitions in the schema, not %q", ref)
+			}
+			if err := checkLocalRefs(value); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, value := range v {
+			if err := checkLocalRefs(value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// llmResponseFormat returns the response format for the LLM provider, which
+// enforces it as far as the model supports
+func llmResponseFormat(req QuestionRequest) *llm.ResponseFormat {
+	if req.ResponseFormat == nil {
+		return nil
+	}
+
+	model, _ := getLLMProviders().GetModel(req.LLMProvider.Provider, req.LLMProvider.ModelID)
+	return &llm.ResponseFormat{
+		Mode:   model.StructuredOutput,
+		Name:   req.ResponseFormat.Name,
+		Schema: req.ResponseFormat.Schema,
+	}
+}
+
+// withResponseFormatInstruction asks for JSON in the last user message, for the
+// models that only follow the prompt and to name the schema in JSON mode
+func withResponseFormatInstruction(messages []goai.LLMMessage, format *ResponseFormat) []goai.LLMMessage {
+	if format == nil {
+		return messages
+	}
+
+	instruction := "Answer with a JSON object only, without Markdown or any other text."
+	if format.Type == ResponseFormatJSONSchema {
+		instruction = fmt.Sprintf("Answer with JSON only, without Markdown or any other text. The answer must match this JSON Schema:\n%s", format.Schema)
+	}
+
+	messages = slices.Clone(messages)
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == goai.UserRole {
+			messages[i].Text += "\n\n" + instruction
+			break
+		}
+	}
+	return messages
+}
+
+// parseStructuredOutput returns the answer as JSON if it matches the response
+// format. A Markdown code block around the JSON is removed.
+func parseStructuredOutput(format *ResponseFormat, answer string) (json.RawMessage, error) {
+	text := strings.TrimSpace(answer)
+	if strings.HasPrefix(text, "```") && strings.HasSuffix(text, "```") {
+		text = strings.TrimPrefix(strings.TrimLeft(text, "`"), "json")
+		text = strings.TrimSpace(strings.TrimRight(text, "`"))
+	}
+	if !json.Valid([]byte(text)) {
+		return nil, fmt.Errorf("%w: the answer is not valid JSON", ErrInvalidStructuredOutput)
+	}
+
+	schema, err := format.compile()
+	if err != nil {
+		return nil, err
+	}
+	result, err := schema.Validate(gojsonschema.NewStringLoader(text))
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate the answer: %w", err)
+	}
+	if !result.Valid() {
+		var problems []string
+		for _, problem := range result.Errors() {
+			problems = append(problems, problem.String())
+		}
+		return nil, fmt.Errorf("%w: %s", ErrInvalidStructuredOutput, strings.Join(problems, "; "))
+	}
+
+	return json.RawMessage(text), nil
+}
+
+// generateStructuredOutput validates the answer against the response format
+// and asks the model to correct an invalid one, up to maxRetries times. The
+// returned response is the last answer with the tokens of all attempts.
+func generateStructuredOutput(ctx context.Context, reqCtx *chatRequestContext, response goai.LLMResponse, maxRetries int) (goai.LLMResponse, json.RawMessage, error) {
+	messages, images := reqCtx.messages, reqCtx.images
+	inputTokens, outputTokens := response.TotalInputToken, response.TotalOutputToken
+
+	for attempt := 0; ; attempt++ {
+		output, err := parseStructuredOutput(reqCtx.req.ResponseFormat, response.Text)
+		if err == nil || !errors.Is(err, ErrInvalidStructuredOutput) || attempt == maxRetries {
+			observability.AddAttribute(ctx, "structured_output.retries", attempt)
+			observability.AddAttribute(ctx, "structured_output.valid", err == nil)
+			response.TotalInputToken, response.TotalOutputToken = inputTokens, outputTokens
+			return response, output, err
+		}
+
+		messages = append(slices.Clip(messages),
+			goai.LLMMessage{Role: goai.AssistantRole, Text: response.Text},
+			goai.LLMMessage{Role: goai.UserRole, Text: fmt.Sprintf("Your answer was rejected: %v. Answer again with the corrected JSON only.", err)},
+		)
+		// The correction has no images of its own
+		images = append(slices.Clip(images), nil)
+
+		correction, err := correctionCompletion(ctx, reqCtx, messages, images)
+		if err != nil {
+			return response, nil, err
+		}
+		response, err = correction.Generate(ctx, messages)
+		if err != nil {
+			return response, nil, err
+		}
+		inputTokens += response.TotalInputToken
+		outputTokens += response.TotalOutputToken
+	}
+}
+
+// correctionCompletion sets up the request asking for a corrected structured
+// answer. It goes to the model that gave the invalid answer, without falling
+// back to others, and has no tools, so the tool calls of the answer are never
+// repeated.
+func correctionCompletion(ctx context.Context, reqCtx *chatRequestContext, messages []goai.LLMMessage, images [][]llm.Image) (*goai.LLMRequest, error) {
+	req := reqCtx.req
+	req.LLMProvider = reqCtx.answeredBy()
+	req.SelectedTools = nil
+
+	provider, err := buildLLMProvider(ctx, req, messages, images, reqCtx.calls)
+	if err != nil {
+		return nil, err
+	}
+	return goai.NewLLMRequest(goai.NewRequestConfig(prepareLLMRequestOptions(req)...), provider), nil
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/shaharia-lab/mcp-kit/internal/auth"
+	"github.com/shaharia-lab/mcp-kit/internal/observability"
+	"github.com/shaharia-lab/mcp-kit/internal/storage"
+)
+
+// DefaultSearchLimit is the number of search hits returned when no limit is given
+const DefaultSearchLimit = 20
+
+// MaxSearchLimit is the maximum number of search hits returned at once
+const MaxSearchLimit = 100
+
+// SearchChatsResponse lists the messages matching a search query, best matches first
+type SearchChatsResponse struct {
+	Query string              `json:"query"`
+	Hits  []storage.SearchHit `json:"hits"`
+}
+
+// SearchChatsHandler Handler to search the messages of all chats of the authenticated caller
+func SearchChatsHandler(logger *log.Logger, searcher storage.ChatSearcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := observability.StartSpan(r.Context(), "search_chats")
+		defer span.End()
+
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		if query == "" {
+			http.Error(w, `{"error": "Search query q is required"}`, http.StatusBadRequest)
+			return
+		}
+
+		limit := DefaultSearchLimit
+		if rawLimit := r.URL.Query().Get("limit"); rawLimit != "" {
+			parsed, err := strconv.Atoi(rawLimit)
+			if err != nil || parsed < 1 || parsed > MaxSearchLimit {
+				http.Error(w, fmt.Sprintf(`{"error": "limit must be between 1 and %d"}`, MaxSearchLimit), http.StatusBadRequest)
+				return
+			}
+			limit = parsed
+		}
+
+		hits, err := searcher.SearchOwnedChats(ctx, auth.SubjectFromContext(ctx), query, limit)
+		if err != nil {
+			logger.Printf("Failed to search chats: %v", err)
+			http.Error(w, `{"error": "Failed to search chats"}`, http.StatusInternalServerError)
+			return
+		}
+		observability.AddAttribute(ctx, "search.hits", len(hits))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(SearchChatsResponse{Query: query, Hits: hits}); err != nil {
+			logger.Printf("Error encoding search response: %v", err)
+		}
+	}
+}
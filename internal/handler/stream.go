@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shaharia-lab/mcp-kit/internal/service/llm"
+	"github.com/shaharia-lab/mcp-kit/internal/storage"
+)
+
+// Event types sent on the chat stream
+const (
+	StreamEventMessageDelta = "message.delta"
+	StreamEventMessageDone  = "message.done"
+	StreamEventError        = "error"
+	StreamEventUsage        = "usage"
+
+	StreamEventToolCallStarted  = "tool_call.started"
+	StreamEventToolCallFinished = "tool_call.finished"
+)
+
+const (
+	contentTypeNDJSON      = "application/json"
+	contentTypeEventStream = "text/event-stream"
+)
+
+// StreamDelta is the payload of a message.delta event
+type StreamDelta struct {
+	Content string `json:"content"`
+}
+
+// StreamDone is the payload of a message.done event. Cancelled is set when the
+// generation has been stopped before the answer was complete.
+type StreamDone struct {
+	ChatUUID     string               `json:"chat_uuid"`
+	Cancelled    bool                 `json:"cancelled,omitempty"`
+	Usage        *StreamUsage         `json:"usage,omitempty"`
+	LatencyMs    int64                `json:"latency_ms,omitempty"`
+	FinishReason storage.FinishReason `json:"finish_reason,omitempty"`
+	// Provider and Model generated the answer, see Response
+	Provider string `json:"provider,omitempty"`
+	Model    string `json:"model,omitempty"`
+}
+
+// StreamError is the payload of an error event
+type StreamError struct {
+	Error string `json:"error"`
+	// Type and RetryAfter are set when the model's provider failed, see
+	// ErrorResponse. Status is what a synchronous answer would have failed with.
+	Type       llm.ErrorClass `json:"type,omitempty"`
+	Status     int            `json:"status,omitempty"`
+	RetryAfter int            `json:"retry_after,omitempty"`
+}
+
+// StreamUsage is the payload of a usage event. Providers don't report the
+// input tokens while streaming, so the token counts are estimates.
+type StreamUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// messageUsage converts the usage for saving it with the answer
+func (u StreamUsage) messageUsage() *storage.MessageUsage {
+	return &storage.MessageUsage{
+		InputTokens:  u.InputTokens,
+		OutputTokens: u.OutputTokens,
+	}
+}
+
+// streamEvent is an event waiting to be written to the stream
+type streamEvent struct {
+	name string
+	data any
+}
+
+// eventWriter receives the typed events of a streamed answer
+type eventWriter interface {
+	WriteEvent(event string, data any) error
+}
+
+// streamWriter writes typed events of a streamed answer in a wire format.
+// Implementations are safe for concurrent use so heartbeats can be written
+// from a separate goroutine.
+type streamWriter interface {
+	eventWriter
+	WriteHeartbeat() error
+	ContentType() string
+}
+
+// newStreamWriter picks the stream format from the Accept header of the request.
+// Server-Sent Events are used when the client asks for text/event-stream,
+// newline delimited JSON otherwise. SSE event IDs continue after lastEventID.
+func newStreamWriter(r *http.Request, w http.ResponseWriter, flusher http.Flusher, lastEventID int) streamWriter {
+	if acceptsEventStream(r.Header.Values("Accept")) {
+		return &sseStreamWriter{w: w, flusher: flusher, lastID: lastEventID}
+	}
+	return &ndjsonStreamWriter{w: w, flusher: flusher}
+}
+
+func acceptsEventStream(accept []string) bool {
+	for _, header := range accept {
+		for _, value := range strings.Split(header, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(value))
+			if err != nil || mediaType != contentTypeEventStream {
+				continue
+			}
+			if q, ok := params["q"]; ok && strings.Trim(q, "0.") == "" {
+				continue
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// startHeartbeat writes a heartbeat to stream every interval. The returned
+// function stops the heartbeats and waits for a pending write to finish.
+func startHeartbeat(stream streamWriter, interval time.Duration) func() {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	ticker := time.NewTicker(interval)
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := stream.WriteHeartbeat(); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(stop)
+		<-stopped
+	}
+}
+
+// sseStreamWriter writes Server-Sent Events with incremental event IDs
+type sseStreamWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	lastID  int
+}
+
+// WriteEvent implements streamWriter interface
+func (s *sseStreamWriter) WriteEvent(event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %w", event, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	if _, err := fmt.Fprintf(s.w, "id: %d\nevent: %s\ndata: %s\n\n", s.lastID, event, payload); err != nil {
+		return fmt.Errorf("error writing response: %w", err)
+	}
+
+	s.flusher.Flush()
+	return nil
+}
+
+// WriteHeartbeat implements streamWriter interface. Comments are ignored by
+// SSE clients but keep proxies from closing an idle connection.
+func (s *sseStreamWriter) WriteHeartbeat() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := fmt.Fprint(s.w, ": heartbeat\n\n"); err != nil {
+		return fmt.Errorf("error writing heartbeat: %w", err)
+	}
+
+	s.flusher.Flush()
+	return nil
+}
+
+// ContentType implements streamWriter interface
+func (s *sseStreamWriter) ContentType() string {
+	return contentTypeEventStream
+}
+
+// ndjsonStreamWriter writes the original newline delimited JSON format where
+// every line is a chunk of the answer. Tool calls are sent as chunks without
+// content with the event type in meta_key, other events without an equivalent
+// in this format are skipped.
+type ndjsonStreamWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+type ndjsonChunk struct {
+	Content      string               `json:"content"`
+	MetaKey      string               `json:"meta_key,omitempty"`
+	ToolCall     *ToolCallEvent       `json:"tool_call,omitempty"`
+	Error        string               `json:"error,omitempty"`
+	ErrorType    llm.ErrorClass       `json:"error_type,omitempty"`
+	Status       int                  `json:"status,omitempty"`
+	RetryAfter   int                  `json:"retry_after,omitempty"`
+	Cancelled    bool                 `json:"cancelled,omitempty"`
+	Usage        *StreamUsage         `json:"usage,omitempty"`
+	LatencyMs    int64                `json:"latency_ms,omitempty"`
+	FinishReason storage.FinishReason `json:"finish_reason,omitempty"`
+	Done         bool                 `json:"done,omitempty"`
+}
+
+// WriteEvent implements streamWriter interface
+func (s *ndjsonStreamWriter) WriteEvent(event string, data any) error {
+	var chunk ndjsonChunk
+	switch payload := data.(type) {
+	case StreamDelta:
+		chunk.Content = payload.Content
+	case StreamDone:
+		chunk.Cancelled = payload.Cancelled
+		chunk.Usage = payload.Usage
+		chunk.LatencyMs = payload.LatencyMs
+		chunk.FinishReason = payload.FinishReason
+		chunk.Done = true
+	case StreamError:
+		chunk.Error = payload.Error
+		chunk.ErrorType = payload.Type
+		chunk.Status = payload.Status
+		chunk.RetryAfter = payload.RetryAfter
+		chunk.Done = true
+	case ToolCallEvent:
+		chunk.MetaKey = event
+		chunk.ToolCall = &payload
+	default:
+		return nil
+	}
+
+	chunkData, err := json.Marshal(chunk)
+	if err != nil {
+		return fmt.Errorf("failed to marshal stream chunk: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := fmt.Fprintf(s.w, "%s\n", chunkData); err != nil {
+		return fmt.Errorf("error writing response: %w", err)
+	}
+
+	s.flusher.Flush()
+	return nil
+}
+
+// WriteHeartbeat implements streamWriter interface. Newline delimited JSON has
+// no comments, so there is nothing to write.
+func (s *ndjsonStreamWriter) WriteHeartbeat() error {
+	return nil
+}
+
+// ContentType implements streamWriter interface
+func (s *ndjsonStreamWriter) ContentType() string {
+	return contentTypeNDJSON
+}
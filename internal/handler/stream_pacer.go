@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/shaharia-lab/goai"
+	"github.com/shaharia-lab/mcp-kit/internal/config"
+)
+
+// effectiveStreamSettings caps the stream settings of a request at the server side maximums
+func effectiveStreamSettings(settings StreamSettings, cfg config.StreamingConfig) StreamSettings {
+	if cfg.MaxChunkSize > 0 && settings.ChunkSize > cfg.MaxChunkSize {
+		settings.ChunkSize = cfg.MaxChunkSize
+	}
+	if maxDelayMs := int(cfg.MaxDelay.Milliseconds()); settings.DelayMs > maxDelayMs {
+		settings.DelayMs = maxDelayMs
+	}
+	return settings
+}
+
+func validateStreamSettings(settings StreamSettings) error {
+	if settings.ChunkSize < 0 {
+		return errors.New("stream_settings.chunk_size cannot be negative")
+	}
+	if settings.DelayMs < 0 {
+		return errors.New("stream_settings.delay_ms cannot be negative")
+	}
+	return nil
+}
+
+// deltaPacer re-chunks the text generated by the provider into message.delta
+// events of ChunkSize characters, waiting DelayMs between two events. A zero
+// ChunkSize keeps the chunks as the provider sends them.
+type deltaPacer struct {
+	ctx      context.Context
+	stream   eventWriter
+	settings StreamSettings
+	pending  []rune
+	sent     int
+}
+
+func newDeltaPacer(ctx context.Context, stream eventWriter, settings StreamSettings) *deltaPacer {
+	return &deltaPacer{
+		ctx:      ctx,
+		stream:   stream,
+		settings: settings,
+	}
+}
+
+// Write queues text and sends every complete chunk
+func (p *deltaPacer) Write(text string) error {
+	if p.settings.ChunkSize == 0 {
+		return p.send(text)
+	}
+
+	p.pending = append(p.pending, []rune(text)...)
+	for len(p.pending) >= p.settings.ChunkSize {
+		chunk := string(p.pending[:p.settings.ChunkSize])
+		p.pending = p.pending[p.settings.ChunkSize:]
+		if err := p.send(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush sends the queued text that doesn't fill a complete chunk
+func (p *deltaPacer) Flush() error {
+	if len(p.pending) == 0 {
+		return nil
+	}
+
+	chunk := string(p.pending)
+	p.pending = nil
+	return p.send(chunk)
+}
+
+func (p *deltaPacer) send(chunk string) error {
+	if p.sent > 0 && p.settings.DelayMs > 0 {
+		select {
+		case <-time.After(time.Duration(p.settings.DelayMs) * time.Millisecond):
+		case <-p.ctx.Done():
+			// The rest of a cancelled answer is sent right away
+		}
+	}
+
+	p.sent++
+	return p.stream.WriteEvent(StreamEventMessageDelta, StreamDelta{Content: chunk})
+}
+
+// timedResponse is a response of the provider and when it arrived
+type timedResponse struct {
+	goai.StreamingLLMResponse
+	receivedAt time.Time
+}
+
+// receiveStream reads the responses of the provider as soon as they arrive and
+// queues them, so neither the provider nor the time the responses are stamped
+// with wait for the pacing of the answer. The returned function stops the
+// relay and lets the provider run into the end of its stream without blocking
+// on us.
+func receiveStream(streamChan <-chan goai.StreamingLLMResponse) (<-chan timedResponse, func()) {
+	received := make(chan timedResponse)
+	stop := make(chan struct{})
+
+	go func() {
+		defer close(received)
+		var queue []timedResponse
+		for streamChan != nil || len(queue) > 0 {
+			// A nil channel keeps the select from sending while the queue is empty
+			var next chan<- timedResponse
+			var head timedResponse
+			if len(queue) > 0 {
+				next, head = received, queue[0]
+			}
+
+			select {
+			case resp, ok := <-streamChan:
+				if !ok {
+					streamChan = nil
+					continue
+				}
+				queue = append(queue, timedResponse{StreamingLLMResponse: resp, receivedAt: time.Now()})
+			case next <- head:
+				queue = queue[1:]
+			case <-stop:
+				if streamChan != nil {
+					for range streamChan {
+					}
+				}
+				return
+			}
+		}
+	}()
+
+	return received, func() { close(stop) }
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shaharia-lab/goai"
+)
+
+func TestReceiveStreamDoesNotWaitForTheReader(t *testing.T) {
+	streamChan := make(chan goai.StreamingLLMResponse)
+	responses, stop := receiveStream(streamChan)
+	defer stop()
+
+	// The provider finishes although nothing has been read yet
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		streamChan <- goai.StreamingLLMResponse{Text: "Hello"}
+		streamChan <- goai.StreamingLLMResponse{Text: " world"}
+		streamChan <- goai.StreamingLLMResponse{Done: true}
+		close(streamChan)
+	}()
+
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("provider blocked on the reader")
+	}
+	time.Sleep(10 * time.Millisecond)
+	readAt := time.Now()
+
+	var texts []string
+	for resp := range responses {
+		if !resp.receivedAt.Before(readAt) {
+			t.Errorf("response %+v stamped at %v, when it was read instead of when it arrived", resp.StreamingLLMResponse, resp.receivedAt)
+		}
+		texts = append(texts, resp.Text)
+	}
+	if len(texts) != 3 || texts[0] != "Hello" || texts[1] != " world" {
+		t.Errorf("responses = %q, want the provider's in order", texts)
+	}
+}
+
+func TestReceiveStreamDrainsTheProviderOnceStopped(t *testing.T) {
+	streamChan := make(chan goai.StreamingLLMResponse)
+	_, stop := receiveStream(streamChan)
+	stop()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			streamChan <- goai.StreamingLLMResponse{Text: "more"}
+		}
+		close(streamChan)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("provider blocked after the relay was stopped")
+	}
+}
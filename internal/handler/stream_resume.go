@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/shaharia-lab/mcp-kit/internal/auth"
+	"github.com/shaharia-lab/mcp-kit/internal/observability"
+	"github.com/shaharia-lab/mcp-kit/internal/storage"
+)
+
+// errEventsClosed is returned when writing to the events of a finished answer
+var errEventsClosed = errors.New("stream events are closed")
+
+// eventBuffer records the events of a streamed answer so the generation
+// doesn't depend on the connection that asked for it. Any number of
+// connections relay the events, a reconnecting client continues where it left
+// off. The SSE event ID of an event is its position in the buffer, starting at 1.
+type eventBuffer struct {
+	mu                sync.Mutex
+	events            []streamEvent
+	changed           chan struct{}
+	done              chan struct{}
+	truncatedMessages int
+}
+
+func newEventBuffer(truncatedMessages int) *eventBuffer {
+	return &eventBuffer{
+		changed:           make(chan struct{}),
+		done:              make(chan struct{}),
+		truncatedMessages: truncatedMessages,
+	}
+}
+
+// WriteEvent implements eventWriter interface
+func (b *eventBuffer) WriteEvent(event string, data any) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	select {
+	case <-b.done:
+		return errEventsClosed
+	default:
+	}
+
+	b.events = append(b.events, streamEvent{name: event, data: data})
+	close(b.changed)
+	b.changed = make(chan struct{})
+	return nil
+}
+
+// Close marks the answer as complete
+func (b *eventBuffer) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	select {
+	case <-b.done:
+	default:
+		close(b.done)
+		close(b.changed)
+	}
+}
+
+// Done returns a channel that is closed once the answer is complete
+func (b *eventBuffer) Done() <-chan struct{} {
+	return b.done
+}
+
+// after returns the events following the first n events, whether these are
+// the last ones, and a channel closed when more events arrive
+func (b *eventBuffer) after(n int) ([]streamEvent, bool, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n = min(max(n, 0), len(b.events))
+	select {
+	case <-b.done:
+		return b.events[n:], true, b.changed
+	default:
+		return b.events[n:], false, b.changed
+	}
+}
+
+// relayEvents writes the events of the buffer following the first n ones to
+// stream as they arrive, until the answer is complete or ctx is done
+func relayEvents(ctx context.Context, events *eventBuffer, stream streamWriter, n int) error {
+	for {
+		pending, complete, changed := events.after(n)
+		for _, event := range pending {
+			if err := stream.WriteEvent(event.name, event.data); err != nil {
+				return err
+			}
+		}
+		n += len(pending)
+
+		if complete {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ResumeStreamHandler Handler to reconnect to the answer currently streamed for
+// a chat of the authenticated caller. The events following the Last-Event-ID
+// header are replayed before the live output, all events without it.
+func ResumeStreamHandler(logger *log.Logger, historyStorage storage.ChatHistoryStorage, generations *GenerationRegistry, heartbeatInterval time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := observability.StartSpan(r.Context(), "resume_stream")
+		defer span.End()
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		chatUUID, ok := parseChatUUID(w, r, logger)
+		if !ok {
+			return
+		}
+
+		lastEventID := 0
+		if header := r.Header.Get("Last-Event-ID"); header != "" {
+			parsed, err := strconv.Atoi(header)
+			if err != nil || parsed < 0 {
+				http.Error(w, `{"error": "Invalid Last-Event-ID"}`, http.StatusBadRequest)
+				return
+			}
+			lastEventID = parsed
+		}
+
+		if _, err := historyStorage.GetOwnedChat(ctx, chatUUID, auth.SubjectFromContext(ctx)); err != nil {
+			if errors.Is(err, storage.ErrChatNotFound) {
+				http.Error(w, `{"error": "Chat not found"}`, http.StatusNotFound)
+				return
+			}
+			logger.Printf("Error getting chat: %v", err)
+			http.Error(w, `{"error": "Failed to get chat"}`, http.StatusInternalServerError)
+			return
+		}
+
+		events, ok := generations.Events(chatUUID)
+		if !ok {
+			http.Error(w, `{"error": "No answer is being streamed for this chat"}`, http.StatusNotFound)
+			return
+		}
+		observability.AddAttribute(ctx, "stream.last_event_id", lastEventID)
+
+		stream := newStreamWriter(r, w, flusher, lastEventID)
+		if err := setupStreamingHeaders(w, stream.ContentType(), chatUUID.String(), events.truncatedMessages); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		stopHeartbeat := startHeartbeat(stream, heartbeatInterval)
+		defer stopHeartbeat()
+
+		if err := relayEvents(ctx, events, stream, lastEventID); err != nil && ctx.Err() == nil {
+			logger.Printf("Failed to resume stream of chat %s: %v", chatUUID, err)
+		}
+	}
+}
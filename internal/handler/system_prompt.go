@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/shaharia-lab/goai"
+	"github.com/shaharia-lab/mcp-kit/internal/config"
+	"github.com/shaharia-lab/mcp-kit/internal/storage"
+)
+
+// ErrSystemPromptForbidden is returned for chats created with a system prompt
+// or persona while overrides are disabled
+var ErrSystemPromptForbidden = errors.New("system prompt overrides are disabled")
+
+// Persona is a system prompt configured on the server that chats can be created with
+type Persona struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// PersonasResponse lists the personas and the limits of custom system prompts
+type PersonasResponse struct {
+	AllowOverrides bool      `json:"allow_overrides"`
+	MaxLength      int       `json:"max_length"`
+	Personas       []Persona `json:"personas"`
+}
+
+// PersonasHandler Handler to list the personas chats can be created with
+func PersonasHandler(cfg config.SystemPromptConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := PersonasResponse{
+			AllowOverrides: cfg.AllowOverrides,
+			MaxLength:      cfg.MaxLength,
+			Personas:       []Persona{},
+		}
+		if cfg.AllowOverrides {
+			for name, persona := range cfg.Personas {
+				response.Personas = append(response.Personas, Persona{Name: name, Description: persona.Description})
+			}
+			sort.Slice(response.Personas, func(i, j int) bool {
+				return response.Personas[i].Name < response.Personas[j].Name
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// chatOptions returns the system prompt a new chat is created with: the one
+// configured for the persona, or the caller's own prompt within the length limit
+func chatOptions(systemPrompt, persona string, cfg config.SystemPromptConfig) (storage.ChatOptions, error) {
+	if systemPrompt == "" && persona == "" {
+		return storage.ChatOptions{}, nil
+	}
+	if !cfg.AllowOverrides {
+		return storage.ChatOptions{}, ErrSystemPromptForbidden
+	}
+
+	if persona != "" {
+		if systemPrompt != "" {
+			return storage.ChatOptions{}, errors.New("only one of system_prompt and persona can be set")
+		}
+		configured, ok := cfg.Personas[persona]
+		if !ok {
+			return storage.ChatOptions{}, fmt.Errorf("unknown persona %q", persona)
+		}
+		return storage.ChatOptions{SystemPrompt: configured.Prompt, Persona: persona}, nil
+	}
+
+	if strings.TrimSpace(systemPrompt) == "" {
+		return storage.ChatOptions{}, errors.New("system_prompt cannot be blank")
+	}
+	if cfg.MaxLength > 0 && utf8.RuneCountInString(systemPrompt) > cfg.MaxLength {
+		return storage.ChatOptions{}, fmt.Errorf("system_prompt cannot be longer than %d characters", cfg.MaxLength)
+	}
+	return storage.ChatOptions{SystemPrompt: systemPrompt}, nil
+}
+
+// chatSystemPrompt returns the system prompt the chat is answered with. Chats
+// created with their own prompt or a persona fall back to the prompt templates
+// while overrides are disabled.
+func chatSystemPrompt(chat *storage.Chat, cfg config.SystemPromptConfig) string {
+	if !cfg.AllowOverrides {
+		return ""
+	}
+	return chat.SystemPrompt
+}
+
+// systemPromptMessage returns the system message carrying the chat's own
+// system prompt and/or the summary of its older messages
+func systemPromptMessage(systemPrompt, summary string) goai.LLMMessage {
+	sections := make([]string, 0, 2)
+	if systemPrompt != "" {
+		sections = append(sections, systemPrompt)
+	}
+	if summary != "" {
+		sections = append(sections, "# Summary of the earlier conversation\nFor context only, answer the latest question.\n\n"+summary)
+	}
+	return goai.LLMMessage{Role: goai.SystemRole, Text: strings.Join(sections, "\n\n")}
+}
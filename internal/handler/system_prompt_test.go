@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shaharia-lab/goai"
+	"github.com/shaharia-lab/mcp-kit/internal/config"
+	"github.com/shaharia-lab/mcp-kit/internal/storage"
+)
+
+func TestChatSystemPromptFallsBackToTemplatesOnceOverridesAreDisabled(t *testing.T) {
+	chat := &storage.Chat{SystemPrompt: "You are a pirate."}
+
+	if got := chatSystemPrompt(chat, config.SystemPromptConfig{AllowOverrides: true}); got != chat.SystemPrompt {
+		t.Errorf("chatSystemPrompt() = %q with overrides allowed, want the chat's own %q", got, chat.SystemPrompt)
+	}
+	if got := chatSystemPrompt(chat, config.SystemPromptConfig{AllowOverrides: false}); got != "" {
+		t.Errorf("chatSystemPrompt() = %q with overrides disabled, want the prompt templates", got)
+	}
+}
+
+func TestChatOptionsRefusesNewPromptsOnceOverridesAreDisabled(t *testing.T) {
+	cfg := config.SystemPromptConfig{
+		AllowOverrides: false,
+		Personas:       map[string]config.PersonaConfig{"pirate": {Prompt: "You are a pirate."}},
+	}
+	for _, tt := range []struct{ systemPrompt, persona string }{
+		{systemPrompt: "You are a pirate."},
+		{persona: "pirate"},
+	} {
+		if _, err := chatOptions(tt.systemPrompt, tt.persona, cfg); err != ErrSystemPromptForbidden {
+			t.Errorf("chatOptions(%q, %q) error = %v, want %v", tt.systemPrompt, tt.persona, err, ErrSystemPromptForbidden)
+		}
+	}
+	if _, err := chatOptions("", "", cfg); err != nil {
+		t.Errorf("chatOptions() error = %v for a chat without its own prompt", err)
+	}
+}
+
+func TestSystemPromptMessageCarriesTheSummary(t *testing.T) {
+	tests := []struct {
+		name, systemPrompt, summary string
+		want                        []string
+	}{
+		{name: "system prompt", systemPrompt: "You are a pirate.", want: []string{"You are a pirate."}},
+		{name: "summary", summary: "Talked about Tokyo", want: []string{"# Summary of the earlier conversation", "Talked about Tokyo"}},
+		{name: "both", systemPrompt: "You are a pirate.", summary: "Talked about Tokyo", want: []string{"You are a pirate.\n\n# Summary", "Talked about Tokyo"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := systemPromptMessage(tt.systemPrompt, tt.summary)
+			if got.Role != goai.SystemRole {
+				t.Errorf("role = %q, want %q", got.Role, goai.SystemRole)
+			}
+			if !strings.HasPrefix(got.Text, tt.want[0]) {
+				t.Errorf("text = %q, want it to start with %q", got.Text, tt.want[0])
+			}
+			if !strings.HasSuffix(got.Text, tt.want[len(tt.want)-1]) {
+				t.Errorf("text = %q, want it to end with %q", got.Text, tt.want[len(tt.want)-1])
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/shaharia-lab/goai"
+	"github.com/shaharia-lab/goai/mcp"
+	"github.com/shaharia-lab/mcp-kit/internal/service/llm"
+	"github.com/shaharia-lab/mcp-kit/internal/storage"
+)
+
+// MaxToolResultPreviewLength is the number of characters of a tool result sent
+// to the client in the tool_call.finished event
+const MaxToolResultPreviewLength = 2000
+
+// ToolCallEvent is the payload of the tool_call.started and tool_call.finished
+// events. The result related fields are only set once the call has finished.
+type ToolCallEvent struct {
+	CallID          int64           `json:"call_id"`
+	Name            string          `json:"name"`
+	Arguments       json.RawMessage `json:"arguments,omitempty"`
+	DurationMs      int64           `json:"duration_ms,omitempty"`
+	Result          string          `json:"result,omitempty"`
+	ResultTruncated bool            `json:"result_truncated,omitempty"`
+	IsError         bool            `json:"is_error,omitempty"`
+	Error           string          `json:"error,omitempty"`
+}
+
+// toolCallObserver is notified right before and after the model calls a tool
+type toolCallObserver func(event string, call ToolCallEvent)
+
+// toolCallRecorder keeps the finished tool calls of an answer so they can be
+// saved with it, passing every event on to the next observer if there is one
+type toolCallRecorder struct {
+	mu    sync.Mutex
+	calls []storage.ToolCall
+	next  toolCallObserver
+}
+
+func newToolCallRecorder(next toolCallObserver) *toolCallRecorder {
+	return &toolCallRecorder{next: next}
+}
+
+func (r *toolCallRecorder) observe(event string, call ToolCallEvent) {
+	if event == StreamEventToolCallFinished {
+		r.mu.Lock()
+		r.calls = append(r.calls, storage.ToolCall{
+			Name:            call.Name,
+			Arguments:       call.Arguments,
+			Result:          call.Result,
+			ResultTruncated: call.ResultTruncated,
+			IsError:         call.IsError,
+			Error:           call.Error,
+			DurationMs:      call.DurationMs,
+		})
+		r.mu.Unlock()
+	}
+
+	if r.next != nil {
+		r.next(event, call)
+	}
+}
+
+// Calls returns the tool calls finished so far
+func (r *toolCallRecorder) Calls() []storage.ToolCall {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]storage.ToolCall(nil), r.calls...)
+}
+
+// observeToolCalls returns a tools provider offering the allowed tools of
+// toolsProvider that notifies observer, if set, about every call it executes.
+// The errors of the tools are returned as llm.ToolError, so they aren't taken
+// for errors of the provider.
+func observeToolCalls(ctx context.Context, toolsProvider *goai.ToolsProvider, allowedTools []string, observer toolCallObserver) (*goai.ToolsProvider, error) {
+	tools, err := toolsProvider.ListTools(ctx, allowedTools)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tools: %w", err)
+	}
+
+	var calls atomic.Int64
+	observed := make([]mcp.Tool, 0, len(tools))
+	for _, tool := range tools {
+		tool.Handler = func(ctx context.Context, params mcp.CallToolParams) (mcp.CallToolResult, error) {
+			call := ToolCallEvent{
+				CallID:    calls.Add(1),
+				Name:      params.Name,
+				Arguments: params.Arguments,
+			}
+			if observer != nil {
+				observer(StreamEventToolCallStarted, call)
+			}
+
+			start := time.Now()
+			result, err := toolsProvider.ExecuteTool(ctx, params)
+
+			call.DurationMs = time.Since(start).Milliseconds()
+			call.Result, call.ResultTruncated = toolResultPreview(result)
+			call.IsError = result.IsError
+			if err != nil {
+				call.IsError = true
+				call.Error = err.Error()
+				err = &llm.ToolError{Tool: params.Name, Err: err}
+			}
+			if observer != nil {
+				observer(StreamEventToolCallFinished, call)
+			}
+
+			return result, err
+		}
+		observed = append(observed, tool)
+	}
+
+	provider := goai.NewToolsProvider()
+	if err := provider.AddTools(observed); err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// toolResultPreview joins the text content of a tool result and cuts it to
+// MaxToolResultPreviewLength characters
+func toolResultPreview(result mcp.CallToolResult) (string, bool) {
+	var texts []string
+	for _, content := range result.Content {
+		if content.Text != "" {
+			texts = append(texts, content.Text)
+		}
+	}
+	preview := strings.Join(texts, "\n")
+
+	if utf8.RuneCountInString(preview) <= MaxToolResultPreviewLength {
+		return preview, false
+	}
+	return string([]rune(preview)[:MaxToolResultPreviewLength]), true
+}
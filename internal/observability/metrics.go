@@ -26,17 +26,17 @@ var (
 	TokensInputTotal = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "llm_input_tokens_total",
-			Help: "Total number of input tokens processed by the LLM",
+			Help: "Total number of input tokens processed by the LLM, estimated for streamed completions",
 		},
-		[]string{"provider", "model"},
+		[]string{"provider", "model", "estimated"},
 	)
 
 	TokensOutputTotal = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "llm_output_tokens_total",
-			Help: "Total number of output tokens generated by the LLM",
+			Help: "Total number of output tokens generated by the LLM, estimated for streamed completions",
 		},
-		[]string{"provider", "model"},
+		[]string{"provider", "model", "estimated"},
 	)
 
 	ToolsUsageTotal = promauto.NewCounterVec(
@@ -73,4 +73,53 @@ var (
 		},
 		[]string{"provider", "model"},
 	)
+
+	LLMStreamTimeToFirstToken = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "llm_stream_time_to_first_token_seconds",
+			Help:    "Time from starting a streamed LLM completion until its first text arrives",
+			Buckets: []float64{0.1, 0.25, 0.5, 1.0, 2.0, 5.0, 10.0, 30.0},
+		},
+		[]string{"provider", "model"},
+	)
+
+	LLMStreamChunks = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "llm_stream_chunks_per_response",
+			Help:    "Number of text chunks received from the LLM per streamed completion",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+		},
+		[]string{"provider", "model"},
+	)
+
+	LLMFallbacksTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "llm_fallbacks_total",
+			Help: "Total number of times a failed model gave way to the next model of its fallback chain",
+		},
+		[]string{"provider", "model", "fallback_provider", "fallback_model"},
+	)
+
+	LLMRetriesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "llm_retries_total",
+			Help: "Total number of retried LLM provider calls by error class",
+		},
+		[]string{"provider", "model", "class"},
+	)
+
+	JobsQueued = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "jobs_queued",
+			Help: "Number of asynchronous chat jobs waiting for a worker",
+		},
+	)
+
+	JobsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "jobs_total",
+			Help: "Total number of finished asynchronous chat jobs",
+		},
+		[]string{"status"},
+	)
 )
@@ -4,9 +4,14 @@ package llm
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/anthropics/anthropic-sdk-go"
+	anthropicoption "github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
 	"github.com/openai/openai-go/option"
@@ -16,6 +21,24 @@ import (
 type ProviderConfig struct {
 	Provider string
 	ModelID  string
+	// Images are sent with the user message of their key, the position among
+	// the user messages of every request counting from 0. Only the Anthropic
+	// and OpenAI providers support them.
+	Images map[int][]Image
+	// ResponseFormat asks for an answer in JSON, enforced as far as the
+	// provider supports its Mode. Only synchronous responses support it.
+	ResponseFormat *ResponseFormat
+	// System is the system prompt of the messages, which goai leaves out of
+	// synchronous Anthropic requests
+	System string
+	// Retries is how the calls to the provider's API are retried. The SDKs
+	// don't retry on their own, so the zero value doesn't retry at all.
+	Retries RetryPolicy
+	// OnRetry, if set, is called before a call is retried
+	OnRetry func(details ErrorDetails, err error, delay time.Duration)
+	// RateLimit, if set, is waited for before every call to the provider's
+	// API, retries included
+	RateLimit func(ctx context.Context) error
 }
 
 type LLMBuilder struct {
@@ -29,55 +52,80 @@ func NewLLMBuilder(ctx context.Context) *LLMBuilder {
 func (b *LLMBuilder) BuildProvider(config ProviderConfig) (goai.LLMProvider, error) {
 	switch strings.ToLower(config.Provider) {
 	case "anthropic":
-		return b.buildAnthropicProvider(config.ModelID)
+		return b.buildAnthropicProvider(config)
 	case "openai":
-		return b.buildOpenAIProvider(config.ModelID)
+		return b.buildOpenAIProvider(config)
 	case "deepseek":
-		return b.buildDeepSeekProvider(config.ModelID)
+		return b.buildDeepSeekProvider(config)
 	case "amazon bedrock":
-		return b.buildBedrockProvider(config.ModelID)
+		return b.buildBedrockProvider(config)
 	default:
 		return nil, fmt.Errorf("unsupported LLM provider: %s", config.Provider)
 	}
 }
 
-func (b *LLMBuilder) buildAnthropicProvider(modelID string) (goai.LLMProvider, error) {
+func (b *LLMBuilder) buildAnthropicProvider(config ProviderConfig) (goai.LLMProvider, error) {
 	apiKey := os.Getenv("ANTHROPIC_API_KEY")
 	if apiKey == "" {
 		return nil, fmt.Errorf("ANTHROPIC_API_KEY is required")
 	}
 
+	sdk := anthropic.NewClient(anthropicoption.WithAPIKey(apiKey), anthropicoption.WithMaxRetries(0))
+	client := &anthropicClient{
+		AnthropicClientProvider: &anthropicSDKClient{messages: sdk.Messages},
+		retrier:                 newRetrier(config),
+		images:                  config.Images,
+		responseFormat:          config.ResponseFormat,
+		system:                  config.System,
+	}
+
 	return goai.NewAnthropicLLMProvider(goai.AnthropicProviderConfig{
-		Client: goai.NewAnthropicClient(apiKey),
-		Model:  modelID,
+		Client: client,
+		Model:  config.ModelID,
 	}), nil
 }
 
-func (b *LLMBuilder) buildOpenAIProvider(modelID string) (goai.LLMProvider, error) {
+func (b *LLMBuilder) buildOpenAIProvider(config ProviderConfig) (goai.LLMProvider, error) {
 	apiKey := os.Getenv("OPENAI_API_KEY")
 	if apiKey == "" {
 		return nil, fmt.Errorf("OPENAI_API_KEY is required")
 	}
 
+	client := &openAIClient{
+		OpenAIClientProvider: goai.NewOpenAIClient(apiKey, option.WithMaxRetries(0)),
+		retrier:              newRetrier(config),
+		images:               config.Images,
+		responseFormat:       config.ResponseFormat,
+	}
+
 	return goai.NewOpenAILLMProvider(goai.OpenAIProviderConfig{
-		Client: goai.NewOpenAIClient(apiKey),
-		Model:  modelID,
+		Client: client,
+		Model:  config.ModelID,
 	}), nil
 }
 
-func (b *LLMBuilder) buildDeepSeekProvider(modelID string) (goai.LLMProvider, error) {
+func (b *LLMBuilder) buildDeepSeekProvider(config ProviderConfig) (goai.LLMProvider, error) {
 	apiKey := os.Getenv("DEEP_SEEK_API_KEY")
 	if apiKey == "" {
 		return nil, fmt.Errorf("DEEP_SEEK_API_KEY is required")
 	}
 
+	client := &openAIClient{
+		OpenAIClientProvider: goai.NewOpenAIClient(apiKey, option.WithBaseURL("https://api.deepseek.com/v1/"), option.WithMaxRetries(0)),
+		retrier:              newRetrier(config),
+		responseFormat:       config.ResponseFormat,
+	}
+
 	return goai.NewOpenAILLMProvider(goai.OpenAIProviderConfig{
-		Client: goai.NewOpenAIClient(apiKey, option.WithBaseURL("https://api.deepseek.com/v1/")),
-		Model:  modelID,
+		Client: client,
+		Model:  config.ModelID,
 	}), nil
 }
 
-func (b *LLMBuilder) buildBedrockProvider(modelID string) (goai.LLMProvider, error) {
+// buildBedrockProvider builds a Bedrock provider. goai takes the AWS client
+// itself, so its calls are retried by the AWS SDK's retryer set up with the
+// retry policy, without Retry-After and OnRetry.
+func (b *LLMBuilder) buildBedrockProvider(providerConfig ProviderConfig) (goai.LLMProvider, error) {
 	apiKey := os.Getenv("AMAZON_BEDROCK_API_KEY")
 	if apiKey == "" {
 		return nil, fmt.Errorf("AMAZON_BEDROCK_API_KEY is required")
@@ -88,8 +136,37 @@ func (b *LLMBuilder) buildBedrockProvider(modelID string) (goai.LLMProvider, err
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
+	client := bedrockruntime.NewFromConfig(awsConfig, func(o *bedrockruntime.Options) {
+		policy := providerConfig.Retries
+		o.Retryer = retry.NewStandard(func(s *retry.StandardOptions) {
+			s.MaxAttempts = max(policy.MaxAttempts, 1)
+			if policy.MaxDelay > 0 {
+				s.MaxBackoff = policy.MaxDelay
+				s.Backoff = retry.NewExponentialJitterBackoff(policy.MaxDelay)
+			}
+		})
+		if providerConfig.RateLimit != nil {
+			o.HTTPClient = &rateLimitedHTTPClient{HTTPClient: o.HTTPClient, wait: providerConfig.RateLimit}
+		}
+	})
+
 	return goai.NewBedrockLLMProvider(goai.BedrockProviderConfig{
-		Client: bedrockruntime.NewFromConfig(awsConfig),
-		Model:  modelID,
+		Client: client,
+		Model:  providerConfig.ModelID,
 	}), nil
 }
+
+// rateLimitedHTTPClient waits for the rate limit before every request of the
+// AWS client, see ProviderConfig.RateLimit
+type rateLimitedHTTPClient struct {
+	bedrockruntime.HTTPClient
+	wait func(ctx context.Context) error
+}
+
+// Do implements bedrockruntime.HTTPClient interface
+func (c *rateLimitedHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	if err := c.wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return c.HTTPClient.Do(req)
+}
@@ -0,0 +1,141 @@
+package llm
+
+import (
+	"context"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	anthropicstream "github.com/anthropics/anthropic-sdk-go/packages/ssestream"
+	"github.com/openai/openai-go"
+	openaistream "github.com/openai/openai-go/packages/ssestream"
+	"github.com/shaharia-lab/goai"
+)
+
+// anthropicSDKClient is goai.AnthropicClient on a client configured by us,
+// which goai.NewAnthropicClient doesn't allow
+type anthropicSDKClient struct {
+	messages *anthropic.MessageService
+}
+
+// CreateMessage implements goai.AnthropicClientProvider interface
+func (c *anthropicSDKClient) CreateMessage(ctx context.Context, params anthropic.MessageNewParams) (*anthropic.Message, error) {
+	return c.messages.New(ctx, params)
+}
+
+// CreateStreamingMessage implements goai.AnthropicClientProvider interface
+func (c *anthropicSDKClient) CreateStreamingMessage(ctx context.Context, params anthropic.MessageNewParams) *anthropicstream.Stream[anthropic.MessageStreamEvent] {
+	return c.messages.NewStreaming(ctx, params)
+}
+
+// anthropicClient adds what goai doesn't send to every request: the system
+// prompt, the images of the user messages and the structured output tool.
+// Every call is made by the retrier.
+type anthropicClient struct {
+	goai.AnthropicClientProvider
+	retrier        *retrier
+	system         string
+	images         map[int][]Image
+	responseFormat *ResponseFormat
+}
+
+// CreateMessage implements goai.AnthropicClientProvider interface
+func (c *anthropicClient) CreateMessage(ctx context.Context, params anthropic.MessageNewParams) (*anthropic.Message, error) {
+	params, err := c.withResponseFormat(c.withImages(c.withSystem(params)))
+	if err != nil {
+		return nil, err
+	}
+
+	var message *anthropic.Message
+	err = c.retrier.do(ctx, func() (err error) {
+		message, err = c.AnthropicClientProvider.CreateMessage(ctx, params)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	markAnswering(ctx)
+	return message, c.answerFromTool(message)
+}
+
+// CreateStreamingMessage implements goai.AnthropicClientProvider interface.
+// Structured output is only supported by CreateMessage.
+func (c *anthropicClient) CreateStreamingMessage(ctx context.Context, params anthropic.MessageNewParams) *anthropicstream.Stream[anthropic.MessageStreamEvent] {
+	params = c.withImages(c.withSystem(params))
+
+	// A stream fails right away when the request did
+	var stream *anthropicstream.Stream[anthropic.MessageStreamEvent]
+	err := c.retrier.do(ctx, func() error {
+		if stream != nil {
+			stream.Close()
+		}
+		stream = c.AnthropicClientProvider.CreateStreamingMessage(ctx, params)
+		return stream.Err()
+	})
+	if stream == nil {
+		return anthropicstream.NewStream[anthropic.MessageStreamEvent](nil, err)
+	}
+	if err == nil {
+		markAnswering(ctx)
+	}
+	return stream
+}
+
+// withSystem sets the system prompt unless goai did
+func (c *anthropicClient) withSystem(params anthropic.MessageNewParams) anthropic.MessageNewParams {
+	if c.system == "" || params.System.Present {
+		return params
+	}
+
+	params.System = anthropic.F([]anthropic.TextBlockParam{anthropic.NewTextBlock(c.system)})
+	return params
+}
+
+// openAIClient adds what goai doesn't send to every request: the images of the
+// user messages and the response format. Every call is made by the retrier.
+type openAIClient struct {
+	goai.OpenAIClientProvider
+	retrier        *retrier
+	images         map[int][]Image
+	responseFormat *ResponseFormat
+}
+
+// CreateCompletion implements goai.OpenAIClientProvider interface
+func (c *openAIClient) CreateCompletion(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	params, err := c.withResponseFormat(c.withImages(params))
+	if err != nil {
+		return nil, err
+	}
+
+	var completion *openai.ChatCompletion
+	err = c.retrier.do(ctx, func() (err error) {
+		completion, err = c.OpenAIClientProvider.CreateCompletion(ctx, params)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	markAnswering(ctx)
+	return completion, nil
+}
+
+// CreateStreamingCompletion implements goai.OpenAIClientProvider interface.
+// Structured output is only supported by CreateCompletion.
+func (c *openAIClient) CreateStreamingCompletion(ctx context.Context, params openai.ChatCompletionNewParams) *openaistream.Stream[openai.ChatCompletionChunk] {
+	params = c.withImages(params)
+
+	// A stream fails right away when the request did
+	var stream *openaistream.Stream[openai.ChatCompletionChunk]
+	err := c.retrier.do(ctx, func() error {
+		if stream != nil {
+			stream.Close()
+		}
+		stream = c.OpenAIClientProvider.CreateStreamingCompletion(ctx, params)
+		return stream.Err()
+	})
+	if stream == nil {
+		return openaistream.NewStream[openai.ChatCompletionChunk](nil, err)
+	}
+	if err == nil {
+		markAnswering(ctx)
+	}
+	return stream
+}
@@ -0,0 +1,192 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go"
+)
+
+// ErrorClass is the kind of failure of a call to a provider
+type ErrorClass string
+
+// Classes of provider errors, see ClassifyError
+const (
+	ErrorClassRateLimit ErrorClass = "rate_limit"
+	// ErrorClassOverloaded is a provider that is overloaded, unavailable or
+	// didn't answer in time
+	ErrorClassOverloaded ErrorClass = "overloaded"
+	// ErrorClassAuth is a provider rejecting the server's credentials
+	ErrorClassAuth           ErrorClass = "auth"
+	ErrorClassBadRequest     ErrorClass = "bad_request"
+	ErrorClassContextTooLong ErrorClass = "context_too_long"
+)
+
+// Retryable tells whether another attempt may not run into the same error
+func (c ErrorClass) Retryable() bool {
+	return c == ErrorClassRateLimit || c == ErrorClassOverloaded
+}
+
+// ToolError is the error of a tool the model called. Its cause may well be an
+// HTTP or API error, but it is never the provider's.
+type ToolError struct {
+	Tool string
+	Err  error
+}
+
+func (e *ToolError) Error() string {
+	return fmt.Sprintf("tool %s failed: %v", e.Tool, e.Err)
+}
+
+func (e *ToolError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorDetails describes an error returned by a provider. RetryAfter is how
+// long the provider asked to wait before the next request, 0 if it didn't say.
+type ErrorDetails struct {
+	Class      ErrorClass
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+// awsErrorStatuses are the statuses of Bedrock's errors, which come without
+// one when they are sent in the middle of a stream
+var awsErrorStatuses = map[string]int{
+	"ThrottlingException":         http.StatusTooManyRequests,
+	"ServiceUnavailableException": http.StatusServiceUnavailable,
+	"ModelNotReadyException":      http.StatusServiceUnavailable,
+	"InternalServerException":     http.StatusInternalServerError,
+	"ModelTimeoutException":       http.StatusRequestTimeout,
+	"AccessDeniedException":       http.StatusForbidden,
+	"ValidationException":         http.StatusBadRequest,
+}
+
+// contextTooLongMessages are how the providers report a prompt that doesn't
+// fit into the model's context window
+var contextTooLongMessages = []string{
+	"prompt is too long",
+	"context_length_exceeded",
+	"maximum context length",
+	"input is too long",
+	"too many input tokens",
+}
+
+// ClassifyError describes an error returned by a provider. It returns false
+// for errors that don't come from a provider, e.g. a cancelled request or a
+// ToolError.
+func ClassifyError(err error) (ErrorDetails, bool) {
+	var toolErr *ToolError
+	if errors.As(err, &toolErr) {
+		return ErrorDetails{}, false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorDetails{Class: ErrorClassOverloaded}, true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorDetails{Class: ErrorClassOverloaded}, true
+	}
+
+	status, ok := statusCode(err)
+	if !ok {
+		return ErrorDetails{}, false
+	}
+	return ErrorDetails{Class: errorClass(status, err), StatusCode: status, RetryAfter: retryAfter(err)}, true
+}
+
+// IsRetryable tells whether the error of a provider is temporary: the provider
+// is rate limiting, overloaded or unavailable, or the request timed out
+func IsRetryable(err error) bool {
+	details, ok := ClassifyError(err)
+	return ok && details.Class.Retryable()
+}
+
+func errorClass(status int, err error) ErrorClass {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return ErrorClassRateLimit
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrorClassAuth
+	case status == http.StatusRequestEntityTooLarge || contextTooLong(err):
+		return ErrorClassContextTooLong
+	// Includes Anthropic's 529 of an overloaded API
+	case status == http.StatusRequestTimeout || status >= http.StatusInternalServerError:
+		return ErrorClassOverloaded
+	case status >= http.StatusBadRequest:
+		return ErrorClassBadRequest
+	default:
+		return ErrorClassOverloaded
+	}
+}
+
+func contextTooLong(err error) bool {
+	message := strings.ToLower(err.Error())
+	for _, marker := range contextTooLongMessages {
+		if strings.Contains(message, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// statusCode returns the HTTP status of an error returned by a provider's API
+func statusCode(err error) (int, bool) {
+	var anthropicErr *anthropic.Error
+	if errors.As(err, &anthropicErr) {
+		return anthropicErr.StatusCode, true
+	}
+	var openAIErr *openai.Error
+	if errors.As(err, &openAIErr) {
+		return openAIErr.StatusCode, true
+	}
+	// Errors of the AWS SDK, used for Bedrock
+	var responseErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &responseErr) {
+		return responseErr.HTTPStatusCode(), true
+	}
+	var apiErr interface{ ErrorCode() string }
+	if errors.As(err, &apiErr) {
+		status, ok := awsErrorStatuses[apiErr.ErrorCode()]
+		return status, ok
+	}
+	return 0, false
+}
+
+// retryAfter returns the delay asked for in the Retry-After header of the
+// response of a failed request, or in the retry-after-ms header some
+// providers send with it
+func retryAfter(err error) time.Duration {
+	var response *http.Response
+	var anthropicErr *anthropic.Error
+	var openAIErr *openai.Error
+	switch {
+	case errors.As(err, &anthropicErr):
+		response = anthropicErr.Response
+	case errors.As(err, &openAIErr):
+		response = openAIErr.Response
+	}
+	if response == nil {
+		return 0
+	}
+
+	if ms, err := strconv.Atoi(response.Header.Get("Retry-After-Ms")); err == nil && ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	value := response.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
@@ -0,0 +1,229 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go"
+)
+
+// anthropicError returns the error the Anthropic SDK returns for a response
+// with status, header and the JSON body
+func anthropicError(t *testing.T, status int, header http.Header, body string) *anthropic.Error {
+	t.Helper()
+	err := &anthropic.Error{
+		StatusCode: status,
+		Request:    httptest.NewRequest(http.MethodPost, "https://api.anthropic.com/v1/messages", nil),
+		Response:   &http.Response{StatusCode: status, Header: header},
+	}
+	if body != "" {
+		if unmarshalErr := err.UnmarshalJSON([]byte(body)); unmarshalErr != nil {
+			t.Fatalf("UnmarshalJSON() error = %v", unmarshalErr)
+		}
+	}
+	return err
+}
+
+func openAIError(status int, header http.Header) *openai.Error {
+	return &openai.Error{
+		StatusCode: status,
+		Request:    httptest.NewRequest(http.MethodPost, "https://api.openai.com/v1/chat/completions", nil),
+		Response:   &http.Response{StatusCode: status, Header: header},
+	}
+}
+
+// awsError is an error of the AWS SDK sent in the middle of a stream
+type awsError struct{ code string }
+
+func (e awsError) Error() string     { return e.code }
+func (e awsError) ErrorCode() string { return e.code }
+
+// awsResponseError is an error of the AWS SDK with the status of its response
+type awsResponseError struct{ status int }
+
+func (e awsResponseError) Error() string       { return fmt.Sprintf("status %d", e.status) }
+func (e awsResponseError) HTTPStatusCode() int { return e.status }
+
+// timeoutError is a net.Error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		wantOK         bool
+		wantClass      ErrorClass
+		wantStatus     int
+		wantRetryAfter time.Duration
+	}{
+		{
+			name:       "anthropic rate limit",
+			err:        anthropicError(t, http.StatusTooManyRequests, http.Header{"Retry-After": {"7"}}, ""),
+			wantOK:     true,
+			wantClass:  ErrorClassRateLimit,
+			wantStatus: http.StatusTooManyRequests,
+			// Retry-After is in seconds
+			wantRetryAfter: 7 * time.Second,
+		},
+		{
+			name:       "anthropic overloaded",
+			err:        anthropicError(t, 529, http.Header{}, ""),
+			wantOK:     true,
+			wantClass:  ErrorClassOverloaded,
+			wantStatus: 529,
+		},
+		{
+			name:       "anthropic prompt too long",
+			err:        anthropicError(t, http.StatusBadRequest, http.Header{}, `{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long: 210000 tokens > 200000 maximum"}}`),
+			wantOK:     true,
+			wantClass:  ErrorClassContextTooLong,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "anthropic bad request",
+			err:        anthropicError(t, http.StatusBadRequest, http.Header{}, `{"type":"error","error":{"type":"invalid_request_error","message":"max_tokens: must be positive"}}`),
+			wantOK:     true,
+			wantClass:  ErrorClassBadRequest,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "openai rate limit with retry-after-ms",
+			err:            openAIError(http.StatusTooManyRequests, http.Header{"Retry-After-Ms": {"1500"}, "Retry-After": {"2"}}),
+			wantOK:         true,
+			wantClass:      ErrorClassRateLimit,
+			wantStatus:     http.StatusTooManyRequests,
+			wantRetryAfter: 1500 * time.Millisecond,
+		},
+		{
+			name:       "openai auth",
+			err:        openAIError(http.StatusUnauthorized, http.Header{}),
+			wantOK:     true,
+			wantClass:  ErrorClassAuth,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "openai unavailable",
+			err:        fmt.Errorf("wrapped: %w", openAIError(http.StatusServiceUnavailable, http.Header{})),
+			wantOK:     true,
+			wantClass:  ErrorClassOverloaded,
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "request entity too large",
+			err:        openAIError(http.StatusRequestEntityTooLarge, http.Header{}),
+			wantOK:     true,
+			wantClass:  ErrorClassContextTooLong,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "bedrock throttling in a stream",
+			err:        awsError{code: "ThrottlingException"},
+			wantOK:     true,
+			wantClass:  ErrorClassRateLimit,
+			wantStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:       "bedrock access denied",
+			err:        awsError{code: "AccessDeniedException"},
+			wantOK:     true,
+			wantClass:  ErrorClassAuth,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "bedrock response",
+			err:        awsResponseError{status: http.StatusInternalServerError},
+			wantOK:     true,
+			wantClass:  ErrorClassOverloaded,
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:   "unknown bedrock code",
+			err:    awsError{code: "SomethingNewException"},
+			wantOK: false,
+		},
+		{
+			name:      "deadline exceeded",
+			err:       fmt.Errorf("calling the provider: %w", context.DeadlineExceeded),
+			wantOK:    true,
+			wantClass: ErrorClassOverloaded,
+		},
+		{
+			name:      "network timeout",
+			err:       timeoutError{},
+			wantOK:    true,
+			wantClass: ErrorClassOverloaded,
+		},
+		{
+			name:   "cancelled",
+			err:    context.Canceled,
+			wantOK: false,
+		},
+		{
+			name:   "plain error",
+			err:    errors.New("something went wrong"),
+			wantOK: false,
+		},
+		{
+			name:   "tool failing with an API error",
+			err:    fmt.Errorf("error executing tool 'search': %w", &ToolError{Tool: "search", Err: openAIError(http.StatusTooManyRequests, http.Header{})}),
+			wantOK: false,
+		},
+		{
+			name:   "tool timing out",
+			err:    &ToolError{Tool: "fetch", Err: context.DeadlineExceeded},
+			wantOK: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details, ok := ClassifyError(tt.err)
+			if ok != tt.wantOK {
+				t.Fatalf("ClassifyError() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if details.Class != tt.wantClass {
+				t.Errorf("Class = %q, want %q", details.Class, tt.wantClass)
+			}
+			if details.StatusCode != tt.wantStatus {
+				t.Errorf("StatusCode = %d, want %d", details.StatusCode, tt.wantStatus)
+			}
+			if details.RetryAfter != tt.wantRetryAfter {
+				t.Errorf("RetryAfter = %v, want %v", details.RetryAfter, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "rate limit", err: openAIError(http.StatusTooManyRequests, http.Header{}), want: true},
+		{name: "overloaded", err: anthropicError(t, 529, http.Header{}, ""), want: true},
+		{name: "bad request", err: openAIError(http.StatusBadRequest, http.Header{}), want: false},
+		{name: "auth", err: openAIError(http.StatusForbidden, http.Header{}), want: false},
+		{name: "tool error", err: &ToolError{Tool: "search", Err: openAIError(http.StatusTooManyRequests, http.Header{})}, want: false},
+		{name: "cancelled", err: context.Canceled, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
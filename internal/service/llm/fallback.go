@@ -0,0 +1,226 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shaharia-lab/goai"
+)
+
+// errNoCandidates is returned by a FallbackProvider without candidates
+var errNoCandidates = errors.New("no model to answer with")
+
+// Candidate is a model a FallbackProvider can answer with
+type Candidate struct {
+	Provider string
+	ModelID  string
+	LLM      goai.LLMProvider
+}
+
+// FallbackProvider answers with the first of its candidates that doesn't fail
+// with a retryable error, see IsRetryable. Every candidate but the last is given
+// up when its first call to the provider's API doesn't succeed within
+// attemptTimeout. Once a call has succeeded the model may have called tools,
+// which must not run a second time, so the candidate is never given up after
+// that, see attempt.
+type FallbackProvider struct {
+	candidates     []Candidate
+	attemptTimeout time.Duration
+	// onFallback is called before the next candidate is tried
+	onFallback func(from, to Candidate, err error)
+
+	mu       sync.Mutex
+	answered int
+}
+
+// NewFallbackProvider creates a new FallbackProvider trying candidates in order.
+// A zero attemptTimeout lets every attempt take as long as the request.
+func NewFallbackProvider(candidates []Candidate, attemptTimeout time.Duration, onFallback func(from, to Candidate, err error)) *FallbackProvider {
+	return &FallbackProvider{
+		candidates:     candidates,
+		attemptTimeout: attemptTimeout,
+		onFallback:     onFallback,
+	}
+}
+
+// Answered returns the candidate that answered the last request, the first
+// candidate until then
+func (p *FallbackProvider) Answered() Candidate {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.candidates) == 0 {
+		return Candidate{}
+	}
+	return p.candidates[p.answered]
+}
+
+// GetResponse implements goai.LLMProvider interface
+func (p *FallbackProvider) GetResponse(ctx context.Context, messages []goai.LLMMessage, config goai.LLMRequestConfig) (goai.LLMResponse, error) {
+	for i, candidate := range p.candidates {
+		attempt, attemptCtx := p.startAttempt(ctx, i)
+		response, err := candidate.LLM.GetResponse(attemptCtx, messages, config)
+		err = attempt.end(err)
+
+		if err == nil {
+			p.setAnswered(i)
+			return response, nil
+		}
+		if !p.fallBack(ctx, i, attempt, err) {
+			return response, err
+		}
+	}
+	return goai.LLMResponse{}, errNoCandidates
+}
+
+// GetStreamingResponse implements goai.LLMProvider interface
+func (p *FallbackProvider) GetStreamingResponse(ctx context.Context, messages []goai.LLMMessage, config goai.LLMRequestConfig) (<-chan goai.StreamingLLMResponse, error) {
+	for i, candidate := range p.candidates {
+		attempt, attemptCtx := p.startAttempt(ctx, i)
+		stream, err := candidate.LLM.GetStreamingResponse(attemptCtx, messages, config)
+		if err != nil {
+			if err = attempt.end(err); p.fallBack(ctx, i, attempt, err) {
+				continue
+			}
+			return nil, err
+		}
+
+		// Providers report most errors as the first response of the stream
+		first, received := <-stream
+		if first.Error != nil {
+			first.Error = attempt.end(first.Error)
+			if p.fallBack(ctx, i, attempt, first.Error) {
+				go drain(stream)
+				continue
+			}
+		}
+
+		attempt.succeed()
+		p.setAnswered(i)
+		return forwardStream(first, received, stream, attempt.cancel), nil
+	}
+	return nil, errNoCandidates
+}
+
+// startAttempt starts the attempt of the i-th candidate, which is given up
+// after the attempt timeout unless it is the last one
+func (p *FallbackProvider) startAttempt(ctx context.Context, i int) (*attempt, context.Context) {
+	a := &attempt{}
+	ctx, a.cancel = context.WithCancel(context.WithValue(ctx, attemptKey{}, a))
+	if p.attemptTimeout > 0 && i < len(p.candidates)-1 {
+		a.timer = time.AfterFunc(p.attemptTimeout, a.expire)
+	}
+	return a, ctx
+}
+
+// fallBack tells whether the candidate after the i-th one is tried after its
+// attempt failed with err. Nothing is tried once the model answered, or once
+// the request itself is cancelled or timed out.
+func (p *FallbackProvider) fallBack(ctx context.Context, i int, a *attempt, err error) bool {
+	if i == len(p.candidates)-1 || a.isAnswering() || ctx.Err() != nil || !IsRetryable(err) {
+		return false
+	}
+	if p.onFallback != nil {
+		p.onFallback(p.candidates[i], p.candidates[i+1], err)
+	}
+	return true
+}
+
+func (p *FallbackProvider) setAnswered(i int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.answered = i
+}
+
+type attemptKey struct{}
+
+// attempt is the attempt of a candidate of a FallbackProvider. The clients of
+// the candidate mark it as answering once a call to the provider's API
+// succeeded, see markAnswering, which stops its timeout.
+type attempt struct {
+	cancel context.CancelFunc
+	timer  *time.Timer
+
+	mu        sync.Mutex
+	answering bool
+	timedOut  bool
+}
+
+// markAnswering marks the attempt of ctx, if any, as answering
+func markAnswering(ctx context.Context) {
+	if a, ok := ctx.Value(attemptKey{}).(*attempt); ok {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if !a.timedOut {
+			a.answering = true
+		}
+	}
+}
+
+// expire gives up the attempt unless it is answering
+func (a *attempt) expire() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.answering {
+		a.timedOut = true
+		a.cancel()
+	}
+}
+
+// succeed stops the timeout of an attempt that has started to answer
+func (a *attempt) succeed() {
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.answering = true
+}
+
+func (a *attempt) isAnswering() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.answering
+}
+
+// end ends the failed or synchronous attempt and returns err, a timeout error
+// if the attempt was given up
+func (a *attempt) end(err error) error {
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	a.cancel()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.timedOut && err != nil {
+		return fmt.Errorf("no response within the attempt timeout: %w", context.DeadlineExceeded)
+	}
+	return err
+}
+
+// forwardStream returns a stream of the first response, if one was received,
+// followed by the rest of stream. cancel is called once stream is closed.
+func forwardStream(first goai.StreamingLLMResponse, received bool, stream <-chan goai.StreamingLLMResponse, cancel context.CancelFunc) <-chan goai.StreamingLLMResponse {
+	forwarded := make(chan goai.StreamingLLMResponse)
+	go func() {
+		defer close(forwarded)
+		defer cancel()
+
+		if received {
+			forwarded <- first
+		}
+		for response := range stream {
+			forwarded <- response
+		}
+	}()
+	return forwarded
+}
+
+// drain lets a provider run into the end of an abandoned stream without blocking
+func drain(stream <-chan goai.StreamingLLMResponse) {
+	for range stream {
+	}
+}
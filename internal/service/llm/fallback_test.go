@@ -0,0 +1,307 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shaharia-lab/goai"
+)
+
+// fakeLLM is a provider answering with respond, or streaming what stream returns
+type fakeLLM struct {
+	calls   atomic.Int32
+	respond func(ctx context.Context) (goai.LLMResponse, error)
+	stream  func(ctx context.Context) (<-chan goai.StreamingLLMResponse, error)
+}
+
+func (f *fakeLLM) GetResponse(ctx context.Context, _ []goai.LLMMessage, _ goai.LLMRequestConfig) (goai.LLMResponse, error) {
+	f.calls.Add(1)
+	return f.respond(ctx)
+}
+
+func (f *fakeLLM) GetStreamingResponse(ctx context.Context, _ []goai.LLMMessage, _ goai.LLMRequestConfig) (<-chan goai.StreamingLLMResponse, error) {
+	f.calls.Add(1)
+	return f.stream(ctx)
+}
+
+func answering(text string) *fakeLLM {
+	return &fakeLLM{respond: func(ctx context.Context) (goai.LLMResponse, error) {
+		markAnswering(ctx)
+		return goai.LLMResponse{Text: text}, nil
+	}}
+}
+
+func failing(err error) *fakeLLM {
+	return &fakeLLM{respond: func(context.Context) (goai.LLMResponse, error) {
+		return goai.LLMResponse{}, err
+	}}
+}
+
+// streaming is a provider streaming responses
+func streaming(responses ...goai.StreamingLLMResponse) *fakeLLM {
+	return &fakeLLM{stream: func(context.Context) (<-chan goai.StreamingLLMResponse, error) {
+		stream := make(chan goai.StreamingLLMResponse)
+		go func() {
+			defer close(stream)
+			for _, response := range responses {
+				stream <- response
+			}
+		}()
+		return stream, nil
+	}}
+}
+
+func candidates(llms ...*fakeLLM) []Candidate {
+	result := make([]Candidate, len(llms))
+	for i, llm := range llms {
+		result[i] = Candidate{Provider: "fake", ModelID: string(rune('a' + i)), LLM: llm}
+	}
+	return result
+}
+
+// fallbacks records the fallbacks of a FallbackProvider
+type fallbacks struct {
+	from, to []string
+	errs     []error
+}
+
+func (f *fallbacks) record(from, to Candidate, err error) {
+	f.from = append(f.from, from.ModelID)
+	f.to = append(f.to, to.ModelID)
+	f.errs = append(f.errs, err)
+}
+
+func collect(stream <-chan goai.StreamingLLMResponse) (string, error) {
+	var text string
+	var err error
+	for response := range stream {
+		text += response.Text
+		if response.Error != nil {
+			err = response.Error
+		}
+	}
+	return text, err
+}
+
+func TestFallbackProviderFallsBackOnRetryableErrors(t *testing.T) {
+	rateLimited := retryableError("")
+	first, second := failing(rateLimited), answering("from b")
+	var recorded fallbacks
+	provider := NewFallbackProvider(candidates(first, second), 0, recorded.record)
+
+	response, err := provider.GetResponse(context.Background(), nil, goai.LLMRequestConfig{})
+	if err != nil {
+		t.Fatalf("GetResponse() error = %v", err)
+	}
+	if response.Text != "from b" {
+		t.Errorf("Text = %q, want the answer of the second candidate", response.Text)
+	}
+	if got := provider.Answered().ModelID; got != "b" {
+		t.Errorf("Answered() = %q, want b", got)
+	}
+	if len(recorded.from) != 1 || recorded.from[0] != "a" || recorded.to[0] != "b" || !errors.Is(recorded.errs[0], rateLimited) {
+		t.Errorf("fallbacks = %+v, want one from a to b for the rate limit", recorded)
+	}
+}
+
+func TestFallbackProviderDoesNotFallBack(t *testing.T) {
+	tests := []struct {
+		name  string
+		first *fakeLLM
+		err   error
+	}{
+		{name: "bad request", first: failing(errBadRequest), err: errBadRequest},
+		{name: "tool error", first: failing(&ToolError{Tool: "search", Err: retryableError("")})},
+		{
+			// A tool may have run, it must not run again for the next candidate
+			name: "after the model answered",
+			first: &fakeLLM{respond: func(ctx context.Context) (goai.LLMResponse, error) {
+				markAnswering(ctx)
+				return goai.LLMResponse{}, retryableError("")
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			second := answering("from b")
+			provider := NewFallbackProvider(candidates(tt.first, second), 0, nil)
+
+			_, err := provider.GetResponse(context.Background(), nil, goai.LLMRequestConfig{})
+			if err == nil {
+				t.Fatal("GetResponse() error = nil, want the error of the first candidate")
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("GetResponse() error = %v, want %v", err, tt.err)
+			}
+			if second.calls.Load() != 0 {
+				t.Error("the second candidate was asked")
+			}
+		})
+	}
+}
+
+func TestFallbackProviderDoesNotFallBackOnceRequestIsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	first := &fakeLLM{respond: func(context.Context) (goai.LLMResponse, error) {
+		cancel()
+		return goai.LLMResponse{}, retryableError("")
+	}}
+	second := answering("from b")
+	provider := NewFallbackProvider(candidates(first, second), 0, nil)
+
+	if _, err := provider.GetResponse(ctx, nil, goai.LLMRequestConfig{}); err == nil {
+		t.Error("GetResponse() error = nil, want the error of the first candidate")
+	}
+	if second.calls.Load() != 0 {
+		t.Error("the second candidate was asked for a cancelled request")
+	}
+}
+
+func TestFallbackProviderGivesUpSlowAttempts(t *testing.T) {
+	slow := &fakeLLM{respond: func(ctx context.Context) (goai.LLMResponse, error) {
+		<-ctx.Done()
+		return goai.LLMResponse{}, ctx.Err()
+	}}
+	var recorded fallbacks
+	provider := NewFallbackProvider(candidates(slow, answering("from b")), 10*time.Millisecond, recorded.record)
+
+	response, err := provider.GetResponse(context.Background(), nil, goai.LLMRequestConfig{})
+	if err != nil {
+		t.Fatalf("GetResponse() error = %v", err)
+	}
+	if response.Text != "from b" {
+		t.Errorf("Text = %q, want the answer of the second candidate", response.Text)
+	}
+	if len(recorded.errs) != 1 || !errors.Is(recorded.errs[0], context.DeadlineExceeded) {
+		t.Errorf("fallbacks = %+v, want one for the attempt timeout", recorded)
+	}
+}
+
+func TestFallbackProviderKeepsAnsweringAttempts(t *testing.T) {
+	// Past the attempt timeout, but the model answered before it
+	slowToolLoop := &fakeLLM{respond: func(ctx context.Context) (goai.LLMResponse, error) {
+		markAnswering(ctx)
+		time.Sleep(30 * time.Millisecond)
+		if ctx.Err() != nil {
+			return goai.LLMResponse{}, ctx.Err()
+		}
+		return goai.LLMResponse{Text: "from a"}, nil
+	}}
+	second := answering("from b")
+	provider := NewFallbackProvider(candidates(slowToolLoop, second), 5*time.Millisecond, nil)
+
+	response, err := provider.GetResponse(context.Background(), nil, goai.LLMRequestConfig{})
+	if err != nil {
+		t.Fatalf("GetResponse() error = %v", err)
+	}
+	if response.Text != "from a" || second.calls.Load() != 0 {
+		t.Errorf("Text = %q, want the answer of the first candidate only", response.Text)
+	}
+}
+
+func TestFallbackProviderDoesNotTimeOutTheLastCandidate(t *testing.T) {
+	slow := &fakeLLM{respond: func(ctx context.Context) (goai.LLMResponse, error) {
+		time.Sleep(30 * time.Millisecond)
+		if ctx.Err() != nil {
+			return goai.LLMResponse{}, ctx.Err()
+		}
+		return goai.LLMResponse{Text: "slow"}, nil
+	}}
+	provider := NewFallbackProvider(candidates(slow), 5*time.Millisecond, nil)
+
+	if _, err := provider.GetResponse(context.Background(), nil, goai.LLMRequestConfig{}); err != nil {
+		t.Errorf("GetResponse() error = %v, want the last candidate to take its time", err)
+	}
+}
+
+func TestFallbackProviderWithoutCandidates(t *testing.T) {
+	provider := NewFallbackProvider(nil, 0, nil)
+
+	if got := provider.Answered(); got != (Candidate{}) {
+		t.Errorf("Answered() = %+v, want the zero Candidate", got)
+	}
+	if _, err := provider.GetResponse(context.Background(), nil, goai.LLMRequestConfig{}); !errors.Is(err, errNoCandidates) {
+		t.Errorf("GetResponse() error = %v, want %v", err, errNoCandidates)
+	}
+	if _, err := provider.GetStreamingResponse(context.Background(), nil, goai.LLMRequestConfig{}); !errors.Is(err, errNoCandidates) {
+		t.Errorf("GetStreamingResponse() error = %v, want %v", err, errNoCandidates)
+	}
+}
+
+func TestFallbackProviderStreamFallsBackOnFirstError(t *testing.T) {
+	tests := []struct {
+		name  string
+		first *fakeLLM
+	}{
+		{name: "error as first response", first: streaming(goai.StreamingLLMResponse{Error: retryableError(""), Done: true})},
+		{
+			name: "error creating the stream",
+			first: &fakeLLM{stream: func(context.Context) (<-chan goai.StreamingLLMResponse, error) {
+				return nil, openAIError(http.StatusServiceUnavailable, http.Header{})
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			second := streaming(goai.StreamingLLMResponse{Text: "from "}, goai.StreamingLLMResponse{Text: "b"}, goai.StreamingLLMResponse{Done: true})
+			provider := NewFallbackProvider(candidates(tt.first, second), 0, nil)
+
+			stream, err := provider.GetStreamingResponse(context.Background(), nil, goai.LLMRequestConfig{})
+			if err != nil {
+				t.Fatalf("GetStreamingResponse() error = %v", err)
+			}
+			text, err := collect(stream)
+			if err != nil || text != "from b" {
+				t.Errorf("stream = %q, %v, want the stream of the second candidate", text, err)
+			}
+			if got := provider.Answered().ModelID; got != "b" {
+				t.Errorf("Answered() = %q, want b", got)
+			}
+		})
+	}
+}
+
+func TestFallbackProviderStreamKeepsStartedStreams(t *testing.T) {
+	rateLimited := retryableError("")
+	first := streaming(goai.StreamingLLMResponse{Text: "from a"}, goai.StreamingLLMResponse{Error: rateLimited, Done: true})
+	second := streaming(goai.StreamingLLMResponse{Text: "from b"})
+	provider := NewFallbackProvider(candidates(first, second), 0, nil)
+
+	stream, err := provider.GetStreamingResponse(context.Background(), nil, goai.LLMRequestConfig{})
+	if err != nil {
+		t.Fatalf("GetStreamingResponse() error = %v", err)
+	}
+	text, err := collect(stream)
+	if text != "from a" || !errors.Is(err, rateLimited) {
+		t.Errorf("stream = %q, %v, want the first candidate's text and error", text, err)
+	}
+	if second.calls.Load() != 0 {
+		t.Error("the second candidate was asked after the first one started to stream")
+	}
+}
+
+func TestFallbackProviderStreamGivesUpSlowFirstResponses(t *testing.T) {
+	slow := &fakeLLM{stream: func(ctx context.Context) (<-chan goai.StreamingLLMResponse, error) {
+		stream := make(chan goai.StreamingLLMResponse)
+		go func() {
+			defer close(stream)
+			<-ctx.Done()
+			stream <- goai.StreamingLLMResponse{Error: ctx.Err(), Done: true}
+		}()
+		return stream, nil
+	}}
+	second := streaming(goai.StreamingLLMResponse{Text: "from b"})
+	provider := NewFallbackProvider(candidates(slow, second), 10*time.Millisecond, nil)
+
+	stream, err := provider.GetStreamingResponse(context.Background(), nil, goai.LLMRequestConfig{})
+	if err != nil {
+		t.Fatalf("GetStreamingResponse() error = %v", err)
+	}
+	if text, err := collect(stream); err != nil || text != "from b" {
+		t.Errorf("stream = %q, %v, want the stream of the second candidate", text, err)
+	}
+}
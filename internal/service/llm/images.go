@@ -0,0 +1,71 @@
+package llm
+
+import (
+	"encoding/base64"
+	"slices"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go"
+)
+
+// Image is a picture sent to the model together with a user message
+type Image struct {
+	MediaType string
+	Data      []byte
+}
+
+// withImages adds the images to the user messages, as goai only sends the text of messages
+func (c *anthropicClient) withImages(params anthropic.MessageNewParams) anthropic.MessageNewParams {
+	if len(c.images) == 0 {
+		return params
+	}
+
+	// Requests are built from the same messages again after tool calls, so
+	// they are copied rather than changed in place
+	messages := slices.Clone(params.Messages.Value)
+	n := 0
+	for i, msg := range messages {
+		if msg.Role.Value != anthropic.MessageParamRoleUser {
+			continue
+		}
+		if images := c.images[n]; len(images) > 0 {
+			content := slices.Clone(msg.Content.Value)
+			for _, image := range images {
+				content = append(content, anthropic.NewImageBlockBase64(image.MediaType, base64.StdEncoding.EncodeToString(image.Data)))
+			}
+			messages[i].Content = anthropic.F(content)
+		}
+		n++
+	}
+
+	params.Messages = anthropic.F(messages)
+	return params
+}
+
+// withImages adds the images to the user messages, as goai only sends the text of messages
+func (c *openAIClient) withImages(params openai.ChatCompletionNewParams) openai.ChatCompletionNewParams {
+	if len(c.images) == 0 {
+		return params
+	}
+
+	messages := slices.Clone(params.Messages.Value)
+	n := 0
+	for i, msg := range messages {
+		userMessage, ok := msg.(openai.ChatCompletionUserMessageParam)
+		if !ok {
+			continue
+		}
+		if images := c.images[n]; len(images) > 0 {
+			parts := slices.Clone(userMessage.Content.Value)
+			for _, image := range images {
+				parts = append(parts, openai.ImagePart("data:"+image.MediaType+";base64,"+base64.StdEncoding.EncodeToString(image.Data)))
+			}
+			userMessage.Content = openai.F(parts)
+			messages[i] = userMessage
+		}
+		n++
+	}
+
+	params.Messages = openai.F(messages)
+	return params
+}
@@ -0,0 +1,179 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go"
+)
+
+// StructuredOutputMode is how a model is made to answer in JSON
+type StructuredOutputMode string
+
+const (
+	// StructuredOutputJSONSchema uses the provider's JSON Schema response format
+	StructuredOutputJSONSchema StructuredOutputMode = "json_schema"
+	// StructuredOutputJSONObject uses the provider's JSON mode, the schema is
+	// only given in the prompt
+	StructuredOutputJSONObject StructuredOutputMode = "json_object"
+	// StructuredOutputTool forces the model to call a tool that takes the answer as input
+	StructuredOutputTool StructuredOutputMode = "tool"
+	// StructuredOutputPrompt only asks for JSON in the prompt
+	StructuredOutputPrompt StructuredOutputMode = "prompt"
+)
+
+// DefaultResponseFormatName names the schema when the request doesn't
+const DefaultResponseFormatName = "response"
+
+// wrappedAnswerProperty holds answers that aren't objects in the input of the
+// structured output tool, as tool inputs must be objects
+const wrappedAnswerProperty = "answer"
+
+// ResponseFormat asks the model for an answer in JSON. Schema is the JSON
+// Schema of the answer, any JSON object is accepted without it. The answer
+// still has to be validated, not every mode guarantees a valid one.
+type ResponseFormat struct {
+	Mode   StructuredOutputMode
+	Name   string
+	Schema json.RawMessage
+}
+
+func (f *ResponseFormat) name() string {
+	if f.Name == "" {
+		return DefaultResponseFormatName
+	}
+	return f.Name
+}
+
+// schema returns the schema of the answer, an object by default
+func (f *ResponseFormat) schema() (map[string]any, error) {
+	if len(f.Schema) == 0 {
+		return map[string]any{"type": "object"}, nil
+	}
+
+	var schema map[string]any
+	if err := json.Unmarshal(f.Schema, &schema); err != nil {
+		return nil, fmt.Errorf("invalid response schema: %w", err)
+	}
+	return schema, nil
+}
+
+// toolInputSchema returns the input schema of the structured output tool and
+// whether the answer is wrapped into an object
+func (f *ResponseFormat) toolInputSchema() (map[string]any, bool, error) {
+	schema, err := f.schema()
+	if err != nil {
+		return nil, false, err
+	}
+	if schema["type"] == "object" {
+		return schema, false, nil
+	}
+
+	return map[string]any{
+		"type":       "object",
+		"properties": map[string]any{wrappedAnswerProperty: schema},
+		"required":   []string{wrappedAnswerProperty},
+	}, true, nil
+}
+
+// withResponseFormat offers the model a tool taking the answer as input and
+// makes it call a tool. With no other tools the structured output tool is
+// forced, otherwise the model may use the other tools before answering.
+func (c *anthropicClient) withResponseFormat(params anthropic.MessageNewParams) (anthropic.MessageNewParams, error) {
+	if c.responseFormat == nil || c.responseFormat.Mode != StructuredOutputTool {
+		return params, nil
+	}
+
+	schema, _, err := c.responseFormat.toolInputSchema()
+	if err != nil {
+		return params, err
+	}
+
+	tools := slices.Clone(params.Tools.Value)
+	tools = append(tools, anthropic.ToolParam{
+		Name:        anthropic.F(c.responseFormat.name()),
+		Description: anthropic.F("Respond to the user. The input is the complete answer."),
+		InputSchema: anthropic.F[any](schema),
+	})
+	params.Tools = anthropic.F(tools)
+
+	if len(tools) == 1 {
+		params.ToolChoice = anthropic.F[anthropic.ToolChoiceUnionParam](anthropic.ToolChoiceToolParam{
+			Type: anthropic.F(anthropic.ToolChoiceToolTypeTool),
+			Name: anthropic.F(c.responseFormat.name()),
+		})
+	} else {
+		params.ToolChoice = anthropic.F[anthropic.ToolChoiceUnionParam](anthropic.ToolChoiceAnyParam{
+			Type: anthropic.F(anthropic.ToolChoiceAnyTypeAny),
+		})
+	}
+	return params, nil
+}
+
+// answerFromTool turns the call of the structured output tool into a text
+// block with its input, so goai takes it as the answer instead of running a tool
+func (c *anthropicClient) answerFromTool(message *anthropic.Message) error {
+	if c.responseFormat == nil || c.responseFormat.Mode != StructuredOutputTool {
+		return nil
+	}
+
+	_, wrapped, err := c.responseFormat.toolInputSchema()
+	if err != nil {
+		return err
+	}
+
+	for i, block := range message.Content {
+		if block.Type != anthropic.ContentBlockTypeToolUse || block.Name != c.responseFormat.name() {
+			continue
+		}
+
+		answer := block.Input
+		if wrapped {
+			var input map[string]json.RawMessage
+			if err := json.Unmarshal(block.Input, &input); err != nil {
+				return fmt.Errorf("invalid structured output: %w", err)
+			}
+			answer = input[wrappedAnswerProperty]
+		}
+
+		// Blocks only get their variant when they are unmarshalled
+		raw, err := json.Marshal(map[string]string{"type": "text", "text": string(answer)})
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(raw, &message.Content[i]); err != nil {
+			return fmt.Errorf("failed to convert structured output: %w", err)
+		}
+	}
+	return nil
+}
+
+// withResponseFormat sets the response format of the request. A JSON Schema
+// without schema falls back to the JSON mode.
+func (c *openAIClient) withResponseFormat(params openai.ChatCompletionNewParams) (openai.ChatCompletionNewParams, error) {
+	if c.responseFormat == nil {
+		return params, nil
+	}
+
+	switch {
+	case c.responseFormat.Mode == StructuredOutputJSONSchema && len(c.responseFormat.Schema) > 0:
+		schema, err := c.responseFormat.schema()
+		if err != nil {
+			return params, err
+		}
+		params.ResponseFormat = openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](openai.ResponseFormatJSONSchemaParam{
+			Type: openai.F(openai.ResponseFormatJSONSchemaTypeJSONSchema),
+			JSONSchema: openai.F(openai.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:   openai.F(c.responseFormat.name()),
+				Schema: openai.F[any](schema),
+			}),
+		})
+	case c.responseFormat.Mode == StructuredOutputJSONSchema || c.responseFormat.Mode == StructuredOutputJSONObject:
+		params.ResponseFormat = openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](openai.ResponseFormatJSONObjectParam{
+			Type: openai.F(openai.ResponseFormatJSONObjectTypeJSONObject),
+		})
+	}
+	return params, nil
+}
@@ -0,0 +1,100 @@
+package llm
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy is how often and how long the calls to a provider's API are
+// retried. The delay doubles from InitialDelay with every attempt, up to
+// MaxDelay. The zero RetryPolicy doesn't retry.
+type RetryPolicy struct {
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+// retrier makes the calls of a client to a provider's API. Calls failing with
+// a retryable error, see ErrorClass.Retryable, are retried with exponential
+// backoff and jitter. The delay a provider asks for with Retry-After is waited
+// instead, and the error is returned right away when that is longer than
+// MaxDelay. Only single calls are retried, never the tool loop around them.
+type retrier struct {
+	policy RetryPolicy
+	// wait, if set, is called before every call, see ProviderConfig.RateLimit
+	wait func(ctx context.Context) error
+	// onRetry, if set, is called before waiting delay for the next attempt
+	onRetry func(details ErrorDetails, err error, delay time.Duration)
+}
+
+// newRetrier creates the retrier of the clients of a provider built for config
+func newRetrier(config ProviderConfig) *retrier {
+	return &retrier{
+		policy:  config.Retries,
+		wait:    config.RateLimit,
+		onRetry: config.OnRetry,
+	}
+}
+
+// do makes call, and again as long as it fails with a retryable error
+func (r *retrier) do(ctx context.Context, call func() error) error {
+	for attempt := 1; ; attempt++ {
+		if r.wait != nil {
+			if err := r.wait(ctx); err != nil {
+				return err
+			}
+		}
+
+		err := call()
+		if err == nil {
+			return nil
+		}
+		delay, ok := r.backoff(ctx, attempt, err)
+		if !ok || !sleep(ctx, delay) {
+			return err
+		}
+	}
+}
+
+// backoff returns how long to wait for the attempt after attempt, which failed
+// with err. It returns false when err isn't retried.
+func (r *retrier) backoff(ctx context.Context, attempt int, err error) (time.Duration, bool) {
+	if attempt >= r.policy.MaxAttempts || ctx.Err() != nil {
+		return 0, false
+	}
+	details, ok := ClassifyError(err)
+	if !ok || !details.Class.Retryable() {
+		return 0, false
+	}
+
+	delay := details.RetryAfter
+	if delay == 0 {
+		delay = r.policy.InitialDelay << (attempt - 1)
+		if delay <= 0 || delay > r.policy.MaxDelay {
+			delay = r.policy.MaxDelay
+		}
+		// Half of the delay is random, so requests failing together don't retry together
+		delay = delay/2 + rand.N(delay/2+1)
+	} else if delay > r.policy.MaxDelay {
+		return 0, false
+	}
+
+	if r.onRetry != nil {
+		r.onRetry(details, err, delay)
+	}
+	return delay, true
+}
+
+// sleep returns after delay, or false once ctx is done
+func sleep(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
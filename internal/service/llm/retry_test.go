@@ -0,0 +1,168 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var errBadRequest = openAIError(http.StatusBadRequest, http.Header{})
+
+// retryableError is a rate limit error asking to wait retryAfter
+func retryableError(retryAfter string) error {
+	header := http.Header{}
+	if retryAfter != "" {
+		header.Set("Retry-After-Ms", retryAfter)
+	}
+	return openAIError(http.StatusTooManyRequests, header)
+}
+
+// failingCall returns a call failing with errs in turn, then succeeding, and
+// the number of times it was made
+func failingCall(errs ...error) (func() error, *int) {
+	calls := 0
+	return func() error {
+		calls++
+		if calls <= len(errs) {
+			return errs[calls-1]
+		}
+		return nil
+	}, &calls
+}
+
+func TestRetrierRetriesRetryableErrors(t *testing.T) {
+	var delays []time.Duration
+	r := &retrier{
+		policy: RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond},
+		onRetry: func(details ErrorDetails, err error, delay time.Duration) {
+			if details.Class != ErrorClassRateLimit {
+				t.Errorf("onRetry() Class = %q, want %q", details.Class, ErrorClassRateLimit)
+			}
+			delays = append(delays, delay)
+		},
+	}
+
+	call, calls := failingCall(retryableError(""), retryableError(""))
+	if err := r.do(context.Background(), call); err != nil {
+		t.Fatalf("do() error = %v", err)
+	}
+	if *calls != 3 {
+		t.Errorf("calls = %d, want 3", *calls)
+	}
+	if len(delays) != 2 {
+		t.Fatalf("onRetry() called %d times, want 2", len(delays))
+	}
+	// Equal jitter: half of the doubled delay is fixed
+	if delays[0] < 500*time.Microsecond || delays[0] > time.Millisecond {
+		t.Errorf("first delay = %v, want between 0.5ms and 1ms", delays[0])
+	}
+	if delays[1] < time.Millisecond || delays[1] > 2*time.Millisecond {
+		t.Errorf("second delay = %v, want between 1ms and 2ms", delays[1])
+	}
+}
+
+func TestRetrierGivesUpAfterMaxAttempts(t *testing.T) {
+	r := &retrier{policy: RetryPolicy{MaxAttempts: 2, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}}
+
+	rateLimited := retryableError("")
+	call, calls := failingCall(rateLimited, rateLimited, rateLimited)
+	if err := r.do(context.Background(), call); !errors.Is(err, rateLimited) {
+		t.Errorf("do() error = %v, want %v", err, rateLimited)
+	}
+	if *calls != 2 {
+		t.Errorf("calls = %d, want 2", *calls)
+	}
+}
+
+func TestRetrierDoesNotRetry(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		err    error
+	}{
+		{name: "bad request", policy: policy, err: errBadRequest},
+		{name: "tool error", policy: policy, err: &ToolError{Tool: "search", Err: retryableError("")}},
+		{name: "unknown error", policy: policy, err: errors.New("boom")},
+		{name: "zero policy", policy: RetryPolicy{}, err: retryableError("")},
+		{name: "retry-after longer than max delay", policy: policy, err: retryableError("5000")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &retrier{policy: tt.policy}
+			call, calls := failingCall(tt.err)
+			if err := r.do(context.Background(), call); !errors.Is(err, tt.err) {
+				t.Errorf("do() error = %v, want %v", err, tt.err)
+			}
+			if *calls != 1 {
+				t.Errorf("calls = %d, want 1", *calls)
+			}
+		})
+	}
+}
+
+func TestRetrierWaitsForRetryAfter(t *testing.T) {
+	var delay time.Duration
+	r := &retrier{
+		policy:  RetryPolicy{MaxAttempts: 2, InitialDelay: time.Hour, MaxDelay: time.Hour},
+		onRetry: func(_ ErrorDetails, _ error, d time.Duration) { delay = d },
+	}
+
+	call, _ := failingCall(retryableError("3"))
+	if err := r.do(context.Background(), call); err != nil {
+		t.Fatalf("do() error = %v", err)
+	}
+	if delay != 3*time.Millisecond {
+		t.Errorf("delay = %v, want the 3ms asked for", delay)
+	}
+}
+
+func TestRetrierStopsWhenContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &retrier{
+		policy: RetryPolicy{MaxAttempts: 3, InitialDelay: time.Hour, MaxDelay: time.Hour},
+		// Cancelled while waiting for the second attempt
+		onRetry: func(ErrorDetails, error, time.Duration) { cancel() },
+	}
+
+	rateLimited := retryableError("")
+	call, calls := failingCall(rateLimited, rateLimited)
+	if err := r.do(ctx, call); !errors.Is(err, rateLimited) {
+		t.Errorf("do() error = %v, want %v", err, rateLimited)
+	}
+	if *calls != 1 {
+		t.Errorf("calls = %d, want 1", *calls)
+	}
+}
+
+func TestRetrierWaitsForRateLimitBeforeEveryCall(t *testing.T) {
+	waits := 0
+	r := &retrier{
+		policy: RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond},
+		wait: func(context.Context) error {
+			waits++
+			return nil
+		},
+	}
+
+	call, _ := failingCall(retryableError(""))
+	if err := r.do(context.Background(), call); err != nil {
+		t.Fatalf("do() error = %v", err)
+	}
+	if waits != 2 {
+		t.Errorf("waits = %d, want one for each of the 2 calls", waits)
+	}
+
+	errLimited := errors.New("rate limit wait cancelled")
+	r.wait = func(context.Context) error { return errLimited }
+	call, calls := failingCall()
+	if err := r.do(context.Background(), call); !errors.Is(err, errLimited) {
+		t.Errorf("do() error = %v, want %v", err, errLimited)
+	}
+	if *calls != 0 {
+		t.Errorf("calls = %d, want none once waiting failed", *calls)
+	}
+}
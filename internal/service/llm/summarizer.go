@@ -0,0 +1,69 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/shaharia-lab/goai"
+	"github.com/shaharia-lab/mcp-kit/internal/config"
+)
+
+const summarizerSystemPrompt = `You maintain a running summary of a conversation between a user and an AI assistant.
+Merge the previous summary (if any) with the new messages into a single updated summary.
+- Keep facts, decisions, names, numbers, file paths and open questions
+- Mention which tools were used and what they returned when it matters for later questions
+- Drop greetings, filler and formatting
+- Write in plain prose, at most a few short paragraphs
+Respond with the updated summary only.`
+
+// Summarizer condenses chat messages that no longer fit into a model's context
+// window into a rolling summary.
+type Summarizer struct {
+	cfg config.SummarizationConfig
+}
+
+// NewSummarizer creates a new Summarizer. It returns nil when summarization is disabled.
+func NewSummarizer(cfg config.SummarizationConfig) *Summarizer {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &Summarizer{cfg: cfg}
+}
+
+// Summarize merges previousSummary with messages into an updated summary
+func (s *Summarizer) Summarize(ctx context.Context, previousSummary string, messages []goai.LLMMessage) (string, error) {
+	provider, err := NewLLMBuilder(ctx).BuildProvider(ProviderConfig{
+		Provider: s.cfg.Provider,
+		ModelID:  s.cfg.ModelID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to build summarization provider: %w", err)
+	}
+
+	var transcript strings.Builder
+	if previousSummary != "" {
+		transcript.WriteString("Previous summary:\n")
+		transcript.WriteString(previousSummary)
+		transcript.WriteString("\n\n")
+	}
+	transcript.WriteString("New messages:\n")
+	for _, msg := range messages {
+		fmt.Fprintf(&transcript, "[%s]: %s\n", msg.Role, msg.Text)
+	}
+
+	request := goai.NewLLMRequest(goai.NewRequestConfig(
+		goai.WithMaxToken(s.cfg.MaxTokens),
+		goai.WithTemperature(0.2),
+	), provider)
+
+	response, err := request.Generate(ctx, []goai.LLMMessage{
+		{Role: goai.SystemRole, Text: summarizerSystemPrompt},
+		{Role: goai.UserRole, Text: transcript.String()},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to summarize chat history: %w", err)
+	}
+
+	return strings.TrimSpace(response.Text), nil
+}
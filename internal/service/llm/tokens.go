@@ -0,0 +1,40 @@
+package llm
+
+import (
+	"unicode/utf8"
+
+	"github.com/shaharia-lab/goai"
+)
+
+// messageOverheadTokens approximates the tokens providers spend on the role and
+// separators of every message on top of its text
+const messageOverheadTokens = 4
+
+// TokenEstimator approximates how many tokens a text occupies in a model's context window
+type TokenEstimator interface {
+	EstimateTokens(text string) int
+}
+
+// HeuristicTokenEstimator estimates tokens without a model specific tokenizer,
+// assuming roughly four characters per token which holds well enough for
+// English text and code across the supported providers.
+type HeuristicTokenEstimator struct{}
+
+// NewHeuristicTokenEstimator creates a new HeuristicTokenEstimator
+func NewHeuristicTokenEstimator() HeuristicTokenEstimator {
+	return HeuristicTokenEstimator{}
+}
+
+// EstimateTokens implements TokenEstimator interface
+func (HeuristicTokenEstimator) EstimateTokens(text string) int {
+	chars := utf8.RuneCountInString(text)
+	if chars == 0 {
+		return 0
+	}
+	return (chars + 3) / 4
+}
+
+// EstimateMessageTokens estimates the tokens a single message occupies including its overhead
+func EstimateMessageTokens(estimator TokenEstimator, msg goai.LLMMessage) int {
+	return estimator.EstimateTokens(msg.Text) + messageOverheadTokens
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shaharia-lab/goai"
+)
+
+func TestAttachmentsGoAlongWithTheirMessage(t *testing.T) {
+	s := testStorages(t)["sqlite"].(*SQLChatHistoryStorage)
+	ctx := context.Background()
+	chat, err := s.CreateOwnedChat(ctx, "alice", ChatOptions{})
+	if err != nil {
+		t.Fatalf("CreateOwnedChat() error = %v", err)
+	}
+	msg, err := s.AddChatMessage(ctx, chat.UUID, Message{
+		ChatHistoryMessage: goai.ChatHistoryMessage{LLMMessage: goai.LLMMessage{Role: goai.UserRole, Text: "What's in it?"}},
+		Attachments:        []Attachment{{Name: "notes.txt", ContentType: "text/plain", Size: 5, Data: []byte("notes")}},
+	})
+	if err != nil {
+		t.Fatalf("AddChatMessage() error = %v", err)
+	}
+
+	attachments, err := s.GetAttachments(ctx, chat.UUID, []int64{msg.Attachments[0].ID})
+	if err != nil {
+		t.Fatalf("GetAttachments() error = %v", err)
+	}
+	if len(attachments) != 1 || string(attachments[0].Data) != "notes" {
+		t.Fatalf("attachments = %+v, want notes.txt with its data", attachments)
+	}
+
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM chat_messages WHERE id = ?`, msg.ID); err != nil {
+		t.Fatalf("deleting the message: %v", err)
+	}
+	var remaining int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM chat_attachments`).Scan(&remaining); err != nil {
+		t.Fatalf("counting attachments: %v", err)
+	}
+	if remaining != 0 {
+		t.Errorf("attachments left = %d, want none once their message is deleted", remaining)
+	}
+}
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shaharia-lab/goai"
+)
+
+// MessageStatus tells whether a message has been generated completely
+type MessageStatus string
+
+const (
+	// MessageStatusComplete is the status of every message that wasn't interrupted
+	MessageStatusComplete MessageStatus = ""
+
+	// MessageStatusCancelled marks a partial, possibly empty assistant message whose generation was cancelled
+	MessageStatusCancelled MessageStatus = "cancelled"
+
+	// MessageStatusInvalidOutput marks an assistant message that still didn't match
+	// the requested response format after the retries
+	MessageStatusInvalidOutput MessageStatus = "invalid_output"
+)
+
+// FinishReason tells why the generation of an answer ended. It is empty
+// when the provider didn't report it.
+type FinishReason string
+
+const (
+	// FinishReasonCancelled is an answer whose generation was cancelled
+	FinishReasonCancelled FinishReason = "cancelled"
+)
+
+// Message is a chat message together with the metadata mcp-kit keeps about it
+// on top of goai.ChatHistoryMessage.
+type Message struct {
+	goai.ChatHistoryMessage
+	ID       int64         `json:"id"`
+	ParentID int64         `json:"parent_id"`
+	Status   MessageStatus `json:"status,omitempty"`
+	// Provider and Model generated an answer
+	Provider  string        `json:"provider,omitempty"`
+	Model     string        `json:"model,omitempty"`
+	ToolCalls []ToolCall    `json:"tool_calls,omitempty"`
+	Usage     *MessageUsage `json:"usage,omitempty"`
+	// Attachments are the files sent along with a question
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// LatencyMs is how long generating the answer took
+	LatencyMs    int64        `json:"latency_ms,omitempty"`
+	FinishReason FinishReason `json:"finish_reason,omitempty"`
+	// Settings are the ones an answer was generated with
+	Settings *ChatSettings `json:"settings,omitempty"`
+}
+
+// ChatSettings are the model, tools and parameters answers are generated
+// with. Nil temperature and top p and zero max tokens and top k use the
+// defaults of the server.
+type ChatSettings struct {
+	Provider      string   `json:"provider"`
+	ModelID       string   `json:"model_id"`
+	SelectedTools []string `json:"selected_tools"`
+	Temperature   *float64 `json:"temperature,omitempty"`
+	MaxTokens     int64    `json:"max_tokens,omitempty"`
+	TopP          *float64 `json:"top_p,omitempty"`
+	TopK          int64    `json:"top_k,omitempty"`
+}
+
+// ToolCall is a tool the model called while generating an answer. Long
+// results are cut, ResultTruncated tells whether Result is complete.
+type ToolCall struct {
+	Name            string          `json:"name"`
+	Arguments       json.RawMessage `json:"arguments,omitempty"`
+	Result          string          `json:"result,omitempty"`
+	ResultTruncated bool            `json:"result_truncated,omitempty"`
+	IsError         bool            `json:"is_error,omitempty"`
+	Error           string          `json:"error,omitempty"`
+	DurationMs      int64           `json:"duration_ms"`
+}
+
+// Attachment is a file sent along with a question. Messages only keep its
+// metadata, Data is set on attachments being added and on the ones returned by
+// ChatHistoryStorage.GetAttachments. It is base64 encoded in JSON.
+type Attachment struct {
+	ID          int64  `json:"id,omitempty"`
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	Data        []byte `json:"data,omitempty"`
+}
+
+// MessageUsage is the token usage of generating an answer
+type MessageUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// ChatUsage sums up the usage of all answers of a chat, including the ones on
+// inactive branches
+type ChatUsage struct {
+	Answers      int   `json:"answers"`
+	InputTokens  int   `json:"input_tokens"`
+	OutputTokens int   `json:"output_tokens"`
+	LatencyMs    int64 `json:"latency_ms"`
+}
+
+// Chat is a conversation together with the metadata mcp-kit keeps about it
+// on top of goai.ChatHistory.
+type Chat struct {
+	UUID      uuid.UUID `json:"uuid"`
+	Messages  []Message `json:"messages"`
+	CreatedAt time.Time `json:"created_at"`
+	Owner     string    `json:"owner,omitempty"`
+	Title     string    `json:"title"`
+	Archived  bool      `json:"archived"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Summary condenses the oldest SummarizedMessages non-system messages of
+	// the active branch which no longer fit into the model's context window
+	Summary            string `json:"summary,omitempty"`
+	SummarizedMessages int    `json:"summarized_messages,omitempty"`
+	// ActiveMessageID is the last message of the active branch
+	ActiveMessageID int64 `json:"active_message_id"`
+	// SystemPrompt replaces the server's prompt templates for every answer
+	// of the chat. Persona names the configured persona it was taken from,
+	// it is empty for a prompt written by the creator of the chat.
+	SystemPrompt string `json:"system_prompt,omitempty"`
+	Persona      string `json:"persona,omitempty"`
+	// Settings are inherited by the questions that don't set their own, nil
+	// for chats created before settings were kept
+	Settings *ChatSettings `json:"settings,omitempty"`
+}
+
+// ChatOptions are the attributes a chat is created with
+type ChatOptions struct {
+	SystemPrompt string
+	Persona      string
+	Settings     *ChatSettings
+}
+
+// History returns the active branch of the conversation as a goai.ChatHistory without the metadata
+func (c *Chat) History() *goai.ChatHistory {
+	branch := c.ActiveBranch()
+	messages := make([]goai.ChatHistoryMessage, 0, len(branch))
+	for _, msg := range branch {
+		messages = append(messages, msg.ChatHistoryMessage)
+	}
+
+	return &goai.ChatHistory{
+		UUID:      c.UUID,
+		Messages:  messages,
+		CreatedAt: c.CreatedAt,
+	}
+}
+
+// Usage sums up the token usage and latency of the answers of the conversation
+func (c *Chat) Usage() ChatUsage {
+	var usage ChatUsage
+	for _, msg := range c.Messages {
+		if msg.Role != goai.AssistantRole {
+			continue
+		}
+		usage.Answers++
+		usage.LatencyMs += msg.LatencyMs
+		if msg.Usage != nil {
+			usage.InputTokens += msg.Usage.InputTokens
+			usage.OutputTokens += msg.Usage.OutputTokens
+		}
+	}
+	return usage
+}
+
+// ChatUpdate holds the chat attributes to change. Nil fields are left untouched.
+type ChatUpdate struct {
+	Title    *string
+	Archived *bool
+	Settings *ChatSettings
+}
+
+// ChatHistoryStorage extends goai.ChatHistoryStorage with per-user ownership
+// and chat lifecycle management. Chats created through the plain goai.ChatHistoryStorage methods have no owner.
+type ChatHistoryStorage interface {
+	goai.ChatHistoryStorage
+	ChatSearcher
+
+	// AddChatMessage adds a new message together with its metadata to the end
+	// of the active branch of an existing conversation. ID and ParentID of the
+	// message and the IDs of its attachments are assigned by the storage, the
+	// stored message is returned without the data of the attachments.
+	AddChatMessage(ctx context.Context, uuid uuid.UUID, message Message) (Message, error)
+
+	// GetAttachments returns the attachments of a conversation with the given
+	// IDs together with their data, in the order of ids. Returns
+	// ErrAttachmentNotFound if one of them doesn't belong to the conversation.
+	GetAttachments(ctx context.Context, uuid uuid.UUID, ids []int64) ([]Attachment, error)
+
+	// SetActiveMessage moves the end of the active branch to the given message,
+	// or to the start of the conversation for RootMessageID. Adding a message
+	// afterwards starts a new branch. Returns ErrMessageNotFound if the message
+	// doesn't belong to the conversation.
+	SetActiveMessage(ctx context.Context, uuid uuid.UUID, messageID int64) error
+
+	// CreateOwnedChat initializes a new chat conversation stamped with its owner
+	CreateOwnedChat(ctx context.Context, owner string, options ChatOptions) (*Chat, error)
+
+	// GetOwnedChat retrieves a conversation by its ChatUUID if it belongs to
+	// owner and returns ErrChatNotFound otherwise
+	GetOwnedChat(ctx context.Context, uuid uuid.UUID, owner string) (*Chat, error)
+
+	// ListOwnedChats returns all conversations belonging to owner
+	ListOwnedChats(ctx context.Context, owner string) ([]Chat, error)
+
+	// ListOwnedChatsPage returns the page of the conversations belonging to
+	// owner selected by query
+	ListOwnedChatsPage(ctx context.Context, owner string, query ChatListQuery) (ChatPage, error)
+
+	// ListOwnedChatSummaries returns the summaries of the page of the
+	// conversations belonging to owner selected by query, without loading
+	// their messages
+	ListOwnedChatSummaries(ctx context.Context, owner string, query ChatListQuery) (ChatSummaryPage, error)
+
+	// UpdateOwnedChat changes the title, archived flag and/or settings of a
+	// conversation belonging to owner and returns the updated conversation
+	UpdateOwnedChat(ctx context.Context, uuid uuid.UUID, owner string, update ChatUpdate) (*Chat, error)
+
+	// SetChatSummary replaces the rolling summary of a conversation, which
+	// covered its first basedOn non-system messages, with the summary of its
+	// first summarizedMessages. Returns ErrSummaryOutdated if the stored summary
+	// no longer covers basedOn messages.
+	SetChatSummary(ctx context.Context, uuid uuid.UUID, summary string, summarizedMessages, basedOn int) error
+
+	// ResetChatSummary removes the rolling summary of a conversation
+	ResetChatSummary(ctx context.Context, uuid uuid.UUID) error
+
+	// DeleteOwnedChats removes the given conversations belonging to owner and
+	// returns the UUIDs that were actually deleted
+	DeleteOwnedChats(ctx context.Context, owner string, uuids []uuid.UUID) ([]uuid.UUID, error)
+}
+
+// orderedAttachments returns the attachments found for ids in their order, or
+// ErrAttachmentNotFound if one is missing
+func orderedAttachments(found map[int64]Attachment, ids []int64) ([]Attachment, error) {
+	attachments := make([]Attachment, 0, len(ids))
+	for _, id := range ids {
+		attachment, ok := found[id]
+		if !ok {
+			return nil, fmt.Errorf("%w: %d", ErrAttachmentNotFound, id)
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
+}
@@ -0,0 +1,432 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shaharia-lab/goai"
+)
+
+// MemoryChatHistoryStorage is an in-memory implementation of ChatHistoryStorage.
+// Everything is lost when the process exits.
+type MemoryChatHistoryStorage struct {
+	chats            map[uuid.UUID]*Chat
+	attachments      map[int64]memoryAttachment
+	lastMessageID    int64
+	lastAttachmentID int64
+	mu               sync.RWMutex
+}
+
+// memoryAttachment is an attachment with its data and the chat it belongs to
+type memoryAttachment struct {
+	Attachment
+	chatUUID uuid.UUID
+}
+
+// NewMemoryChatHistoryStorage creates a new instance of MemoryChatHistoryStorage
+func NewMemoryChatHistoryStorage() *MemoryChatHistoryStorage {
+	return &MemoryChatHistoryStorage{
+		chats:       make(map[uuid.UUID]*Chat),
+		attachments: make(map[int64]memoryAttachment),
+	}
+}
+
+// CreateChat initializes a new chat conversation without an owner
+func (s *MemoryChatHistoryStorage) CreateChat(ctx context.Context) (*goai.ChatHistory, error) {
+	chat, err := s.CreateOwnedChat(ctx, "", ChatOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return chat.History(), nil
+}
+
+// CreateOwnedChat initializes a new chat conversation stamped with its owner
+func (s *MemoryChatHistoryStorage) CreateOwnedChat(ctx context.Context, owner string, options ChatOptions) (*Chat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	chat := &Chat{
+		UUID:         uuid.New(),
+		Messages:     []Message{},
+		CreatedAt:    now,
+		Owner:        owner,
+		UpdatedAt:    now,
+		SystemPrompt: options.SystemPrompt,
+		Persona:      options.Persona,
+		Settings:     copySettings(options.Settings),
+	}
+
+	s.chats[chat.UUID] = chat
+	return copyChat(chat), nil
+}
+
+// AddMessage adds a new message to an existing conversation
+func (s *MemoryChatHistoryStorage) AddMessage(ctx context.Context, chatUUID uuid.UUID, message goai.ChatHistoryMessage) error {
+	_, err := s.AddChatMessage(ctx, chatUUID, Message{ChatHistoryMessage: message})
+	return err
+}
+
+// AddChatMessage adds a new message together with its metadata to an existing conversation
+func (s *MemoryChatHistoryStorage) AddChatMessage(ctx context.Context, chatUUID uuid.UUID, message Message) (Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat, exists := s.chats[chatUUID]
+	if !exists {
+		return Message{}, fmt.Errorf("%w: %s", ErrChatNotFound, chatUUID)
+	}
+
+	if message.GeneratedAt.IsZero() {
+		message.GeneratedAt = time.Now()
+	}
+
+	s.lastMessageID++
+	message.ID = s.lastMessageID
+	message.ParentID = chat.ActiveMessageID
+
+	// Messages keep the metadata of their attachments only
+	message.Attachments = slices.Clone(message.Attachments)
+	for i := range message.Attachments {
+		attachment := &message.Attachments[i]
+		s.lastAttachmentID++
+		attachment.ID = s.lastAttachmentID
+		attachment.Size = len(attachment.Data)
+		s.attachments[attachment.ID] = memoryAttachment{Attachment: *attachment, chatUUID: chatUUID}
+		attachment.Data = nil
+	}
+
+	chat.Messages = append(chat.Messages, message)
+	chat.ActiveMessageID = message.ID
+	chat.UpdatedAt = time.Now()
+	return message, nil
+}
+
+// GetAttachments returns the attachments of a conversation with the given IDs together with their data
+func (s *MemoryChatHistoryStorage) GetAttachments(ctx context.Context, chatUUID uuid.UUID, ids []int64) ([]Attachment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found := make(map[int64]Attachment, len(ids))
+	for _, id := range ids {
+		if attachment, ok := s.attachments[id]; ok && attachment.chatUUID == chatUUID {
+			found[id] = attachment.Attachment
+		}
+	}
+	return orderedAttachments(found, ids)
+}
+
+// SetActiveMessage moves the end of the active branch of a conversation to the given message
+func (s *MemoryChatHistoryStorage) SetActiveMessage(ctx context.Context, chatUUID uuid.UUID, messageID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat, exists := s.chats[chatUUID]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrChatNotFound, chatUUID)
+	}
+
+	if _, ok := chat.Message(messageID); !ok && messageID != RootMessageID {
+		return fmt.Errorf("%w: %d", ErrMessageNotFound, messageID)
+	}
+
+	chat.ActiveMessageID = messageID
+	chat.UpdatedAt = time.Now()
+	return nil
+}
+
+// GetChat retrieves a conversation by its ChatUUID regardless of its owner
+func (s *MemoryChatHistoryStorage) GetChat(ctx context.Context, chatUUID uuid.UUID) (*goai.ChatHistory, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	chat, exists := s.chats[chatUUID]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrChatNotFound, chatUUID)
+	}
+
+	return chat.History(), nil
+}
+
+// GetOwnedChat retrieves a conversation by its ChatUUID if it belongs to owner
+func (s *MemoryChatHistoryStorage) GetOwnedChat(ctx context.Context, chatUUID uuid.UUID, owner string) (*Chat, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	chat, exists := s.chats[chatUUID]
+	if !exists || chat.Owner != owner {
+		return nil, fmt.Errorf("%w: %s", ErrChatNotFound, chatUUID)
+	}
+
+	return copyChat(chat), nil
+}
+
+// ListChatHistories returns all stored conversations, most recently created first
+func (s *MemoryChatHistoryStorage) ListChatHistories(ctx context.Context) ([]goai.ChatHistory, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	chats := make([]goai.ChatHistory, 0, len(s.chats))
+	for _, chat := range s.sortedChats() {
+		chats = append(chats, *chat.History())
+	}
+	return chats, nil
+}
+
+// ListOwnedChats returns all conversations belonging to owner, most recently created first
+func (s *MemoryChatHistoryStorage) ListOwnedChats(ctx context.Context, owner string) ([]Chat, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	chats := []Chat{}
+	for _, chat := range s.sortedChats() {
+		if chat.Owner == owner {
+			chats = append(chats, *copyChat(chat))
+		}
+	}
+	return chats, nil
+}
+
+// ListOwnedChatsPage returns the page of the conversations belonging to owner selected by query
+func (s *MemoryChatHistoryStorage) ListOwnedChatsPage(ctx context.Context, owner string, query ChatListQuery) (ChatPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matching []*Chat
+	for _, chat := range s.chats {
+		if chat.Owner == owner && query.matches(chat) {
+			matching = append(matching, chat)
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		return query.less(*query.cursor(matching[i]), *query.cursor(matching[j]))
+	})
+
+	page := ChatPage{Chats: []Chat{}}
+	for _, chat := range matching {
+		if query.Limit > 0 && len(page.Chats) == query.Limit {
+			page.Next = query.cursor(&page.Chats[len(page.Chats)-1])
+			break
+		}
+		page.Chats = append(page.Chats, *copyChat(chat))
+	}
+	return page, nil
+}
+
+// ListOwnedChatSummaries returns the summaries of the page of the conversations belonging to owner selected by query
+func (s *MemoryChatHistoryStorage) ListOwnedChatSummaries(ctx context.Context, owner string, query ChatListQuery) (ChatSummaryPage, error) {
+	page, err := s.ListOwnedChatsPage(ctx, owner, query)
+	if err != nil {
+		return ChatSummaryPage{}, err
+	}
+
+	summaries := ChatSummaryPage{Summaries: make([]ChatSummary, 0, len(page.Chats)), Next: page.Next}
+	for i := range page.Chats {
+		var last *Message
+		branch := page.Chats[i].ActiveBranch()
+		if len(branch) > 0 {
+			last = &branch[len(branch)-1]
+		}
+		summaries.Summaries = append(summaries.Summaries, newChatSummary(&page.Chats[i], len(branch), last))
+	}
+	return summaries, nil
+}
+
+// SearchOwnedChats returns the messages of the conversations belonging to
+// owner that contain any word of query, scored by the number of times they do
+func (s *MemoryChatHistoryStorage) SearchOwnedChats(ctx context.Context, owner string, query string, limit int) ([]SearchHit, error) {
+	terms := uniqueTerms(tokenize(query))
+	hits := []SearchHit{}
+	if len(terms) == 0 {
+		return hits, nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, chat := range s.chats {
+		if chat.Owner != owner {
+			continue
+		}
+
+		// Parents are added before their children
+		indexes := make(map[int64]int, len(chat.Messages))
+		for _, msg := range chat.Messages {
+			if parent, ok := indexes[msg.ParentID]; ok {
+				indexes[msg.ID] = parent + 1
+			} else {
+				indexes[msg.ID] = 0
+			}
+
+			matches := 0
+			for _, word := range tokenize(msg.Text) {
+				if slices.Contains(terms, word) {
+					matches++
+				}
+			}
+			if matches == 0 {
+				continue
+			}
+			hits = append(hits, SearchHit{
+				ChatUUID:     chat.UUID,
+				ChatTitle:    chat.Title,
+				MessageID:    msg.ID,
+				MessageIndex: indexes[msg.ID],
+				Role:         msg.Role,
+				Snippet:      highlightSnippet(msg.Text, terms),
+				Score:        float64(matches),
+				GeneratedAt:  msg.GeneratedAt,
+			})
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].GeneratedAt.After(hits[j].GeneratedAt)
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+// UpdateOwnedChat changes the title and/or archived flag of a conversation belonging to owner
+func (s *MemoryChatHistoryStorage) UpdateOwnedChat(ctx context.Context, chatUUID uuid.UUID, owner string, update ChatUpdate) (*Chat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat, exists := s.chats[chatUUID]
+	if !exists || chat.Owner != owner {
+		return nil, fmt.Errorf("%w: %s", ErrChatNotFound, chatUUID)
+	}
+
+	if update.Title != nil {
+		chat.Title = *update.Title
+	}
+	if update.Archived != nil {
+		chat.Archived = *update.Archived
+	}
+	if update.Settings != nil {
+		chat.Settings = copySettings(update.Settings)
+	}
+	chat.UpdatedAt = time.Now()
+
+	return copyChat(chat), nil
+}
+
+// SetChatSummary replaces the rolling summary of a conversation if it still
+// covers basedOn messages
+func (s *MemoryChatHistoryStorage) SetChatSummary(ctx context.Context, chatUUID uuid.UUID, summary string, summarizedMessages, basedOn int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat, exists := s.chats[chatUUID]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrChatNotFound, chatUUID)
+	}
+	if chat.SummarizedMessages != basedOn {
+		return fmt.Errorf("%w: %s", ErrSummaryOutdated, chatUUID)
+	}
+
+	chat.Summary = summary
+	chat.SummarizedMessages = summarizedMessages
+	return nil
+}
+
+// ResetChatSummary removes the rolling summary of a conversation
+func (s *MemoryChatHistoryStorage) ResetChatSummary(ctx context.Context, chatUUID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat, exists := s.chats[chatUUID]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrChatNotFound, chatUUID)
+	}
+
+	chat.Summary = ""
+	chat.SummarizedMessages = 0
+	return nil
+}
+
+// DeleteOwnedChats removes the given conversations belonging to owner
+func (s *MemoryChatHistoryStorage) DeleteOwnedChats(ctx context.Context, owner string, uuids []uuid.UUID) ([]uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := []uuid.UUID{}
+	for _, chatUUID := range uuids {
+		if chat, exists := s.chats[chatUUID]; exists && chat.Owner == owner {
+			s.deleteChat(chatUUID)
+			deleted = append(deleted, chatUUID)
+		}
+	}
+	return deleted, nil
+}
+
+// DeleteChat removes a conversation by its ChatUUID
+func (s *MemoryChatHistoryStorage) DeleteChat(ctx context.Context, chatUUID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.chats[chatUUID]; !exists {
+		return fmt.Errorf("%w: %s", ErrChatNotFound, chatUUID)
+	}
+
+	s.deleteChat(chatUUID)
+	return nil
+}
+
+// deleteChat removes a conversation and its attachments. The caller must hold the lock.
+func (s *MemoryChatHistoryStorage) deleteChat(chatUUID uuid.UUID) {
+	for id, attachment := range s.attachments {
+		if attachment.chatUUID == chatUUID {
+			delete(s.attachments, id)
+		}
+	}
+	delete(s.chats, chatUUID)
+}
+
+// sortedChats returns the chats ordered by creation time, newest first.
+// The caller must hold the lock.
+func (s *MemoryChatHistoryStorage) sortedChats() []*Chat {
+	chats := make([]*Chat, 0, len(s.chats))
+	for _, chat := range s.chats {
+		chats = append(chats, chat)
+	}
+	sort.Slice(chats, func(i, j int) bool {
+		return chats[i].CreatedAt.After(chats[j].CreatedAt)
+	})
+	return chats
+}
+
+// copyChat returns a copy of chat that is safe to hand out without the lock
+func copyChat(chat *Chat) *Chat {
+	c := *chat
+	c.Messages = append([]Message{}, chat.Messages...)
+	c.Settings = copySettings(chat.Settings)
+	return &c
+}
+
+func copySettings(settings *ChatSettings) *ChatSettings {
+	if settings == nil {
+		return nil
+	}
+	s := *settings
+	s.SelectedTools = append([]string{}, settings.SelectedTools...)
+	if settings.Temperature != nil {
+		temperature := *settings.Temperature
+		s.Temperature = &temperature
+	}
+	if settings.TopP != nil {
+		topP := *settings.TopP
+		s.TopP = &topP
+	}
+	return &s
+}
@@ -0,0 +1,905 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+	"github.com/shaharia-lab/goai"
+	"github.com/shaharia-lab/mcp-kit/internal/config"
+	_ "modernc.org/sqlite"
+)
+
+// SQLChatHistoryStorage implements goai.ChatHistoryStorage on top of a SQL
+// database. SQLite is meant for single node deployments, PostgreSQL for
+// deployments where several API servers share the same chat history.
+type SQLChatHistoryStorage struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// NewSQLChatHistoryStorage opens the configured database and applies any
+// pending schema migrations before returning the storage.
+func NewSQLChatHistoryStorage(ctx context.Context, cfg config.ChatHistoryConfig) (*SQLChatHistoryStorage, error) {
+	d, err := dialectFor(cfg.Driver)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.DSN == "" {
+		return nil, fmt.Errorf("chat history dsn is required for driver %s", cfg.Driver)
+	}
+
+	db, err := sql.Open(d.driverName, cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open chat history database: %w", err)
+	}
+
+	if d == sqliteDialect {
+		// SQLite allows a single writer at a time and connection level pragmas
+		// must apply to every statement, so we stick to one connection.
+		db.SetMaxOpenConns(1)
+	} else {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to chat history database: %w", err)
+	}
+
+	if d == sqliteDialect {
+		for _, pragma := range []string{
+			"PRAGMA foreign_keys = ON",
+			"PRAGMA busy_timeout = 5000",
+			"PRAGMA journal_mode = WAL",
+		} {
+			if _, err := db.ExecContext(ctx, pragma); err != nil {
+				db.Close()
+				return nil, fmt.Errorf("failed to configure sqlite: %w", err)
+			}
+		}
+	}
+
+	if err := migrate(ctx, db, d); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate chat history database: %w", err)
+	}
+
+	return &SQLChatHistoryStorage{db: db, dialect: d}, nil
+}
+
+// Close closes the underlying database connection pool
+func (s *SQLChatHistoryStorage) Close() error {
+	return s.db.Close()
+}
+
+// CreateChat initializes a new chat conversation without an owner
+func (s *SQLChatHistoryStorage) CreateChat(ctx context.Context) (*goai.ChatHistory, error) {
+	chat, err := s.CreateOwnedChat(ctx, "", ChatOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return chat.History(), nil
+}
+
+// CreateOwnedChat initializes a new chat conversation stamped with its owner
+func (s *SQLChatHistoryStorage) CreateOwnedChat(ctx context.Context, owner string, options ChatOptions) (*Chat, error) {
+	now := time.Now().UTC()
+	chat := &Chat{
+		UUID:         uuid.New(),
+		Messages:     []Message{},
+		CreatedAt:    now,
+		Owner:        owner,
+		UpdatedAt:    now,
+		SystemPrompt: options.SystemPrompt,
+		Persona:      options.Persona,
+		Settings:     options.Settings,
+	}
+
+	settings, err := encodeSettings(options.Settings)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		s.dialect.rebind(`INSERT INTO chats (uuid, owner, system_prompt, persona, settings, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`),
+		chat.UUID.String(), owner, options.SystemPrompt, options.Persona, settings, now, now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chat: %w", err)
+	}
+
+	return chat, nil
+}
+
+// AddMessage adds a new message to an existing conversation
+func (s *SQLChatHistoryStorage) AddMessage(ctx context.Context, chatUUID uuid.UUID, message goai.ChatHistoryMessage) error {
+	_, err := s.AddChatMessage(ctx, chatUUID, Message{ChatHistoryMessage: message})
+	return err
+}
+
+// AddChatMessage adds a new message together with its metadata to an existing conversation
+func (s *SQLChatHistoryStorage) AddChatMessage(ctx context.Context, chatUUID uuid.UUID, message Message) (Message, error) {
+	toolCalls, err := encodeToolCalls(message.ToolCalls)
+	if err != nil {
+		return Message{}, err
+	}
+	settings, err := encodeSettings(message.Settings)
+	if err != nil {
+		return Message{}, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Message{}, err
+	}
+	defer tx.Rollback()
+
+	// Messages added to the chat at the same time, possibly by other instances,
+	// wait for this one instead of getting the same parent. SQLite has a single
+	// connection, which runs one transaction at a time.
+	query := `SELECT active_message_id FROM chats WHERE uuid = ?`
+	if s.dialect == postgresDialect {
+		query += ` FOR UPDATE`
+	}
+	err = tx.QueryRowContext(ctx, s.dialect.rebind(query), chatUUID.String()).Scan(&message.ParentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Message{}, fmt.Errorf("%w: %s", ErrChatNotFound, chatUUID)
+	}
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to get chat: %w", err)
+	}
+
+	if message.GeneratedAt.IsZero() {
+		message.GeneratedAt = time.Now()
+	}
+	message.GeneratedAt = message.GeneratedAt.UTC()
+
+	var usage MessageUsage
+	if message.Usage != nil {
+		usage = *message.Usage
+	}
+
+	err = tx.QueryRowContext(ctx,
+		s.dialect.rebind(`INSERT INTO chat_messages (chat_uuid, parent_id, role, text, status, provider, model, tool_calls, input_tokens, output_tokens, latency_ms, finish_reason, settings, generated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`),
+		chatUUID.String(), message.ParentID, string(message.Role), message.Text, string(message.Status),
+		message.Provider, message.Model, toolCalls, usage.InputTokens, usage.OutputTokens,
+		message.LatencyMs, string(message.FinishReason), settings, message.GeneratedAt,
+	).Scan(&message.ID)
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to add message: %w", err)
+	}
+
+	if len(message.Attachments) > 0 {
+		message.Attachments = slices.Clone(message.Attachments)
+		if err := insertAttachments(ctx, tx, s.dialect, chatUUID.String(), message.ID, message.Attachments, message.GeneratedAt); err != nil {
+			return Message{}, fmt.Errorf("failed to add attachments: %w", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		s.dialect.rebind(`UPDATE chats SET active_message_id = ?, updated_at = ? WHERE uuid = ?`),
+		message.ID, time.Now().UTC(), chatUUID.String(),
+	); err != nil {
+		return Message{}, fmt.Errorf("failed to update chat: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Message{}, err
+	}
+	return message, nil
+}
+
+// insertAttachments stores the data of the attachments of a message in
+// chat_attachments and their metadata in the message. The IDs are set on
+// attachments, and their data removed.
+func insertAttachments(ctx context.Context, tx *sql.Tx, d dialect, chatUUID string, messageID int64, attachments []Attachment, createdAt time.Time) error {
+	for i := range attachments {
+		attachment := &attachments[i]
+		err := tx.QueryRowContext(ctx,
+			d.rebind(`INSERT INTO chat_attachments (chat_uuid, message_id, name, content_type, size, data, created_at) VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id`),
+			chatUUID, messageID, attachment.Name, attachment.ContentType, len(attachment.Data), attachment.Data, createdAt,
+		).Scan(&attachment.ID)
+		if err != nil {
+			return err
+		}
+		attachment.Size = len(attachment.Data)
+		attachment.Data = nil
+	}
+
+	metadata, err := encodeAttachments(attachments)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		d.rebind(`UPDATE chat_messages SET attachments = ? WHERE id = ?`),
+		metadata, messageID,
+	)
+	return err
+}
+
+// GetAttachments returns the attachments of a conversation with the given IDs together with their data
+func (s *SQLChatHistoryStorage) GetAttachments(ctx context.Context, chatUUID uuid.UUID, ids []int64) ([]Attachment, error) {
+	if len(ids) == 0 {
+		return []Attachment{}, nil
+	}
+
+	args := []any{chatUUID.String()}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := s.db.QueryContext(ctx,
+		s.dialect.rebind(`SELECT id, name, content_type, size, data FROM chat_attachments WHERE chat_uuid = ? AND id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)`),
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachments: %w", err)
+	}
+	defer rows.Close()
+
+	found := make(map[int64]Attachment, len(ids))
+	for rows.Next() {
+		var attachment Attachment
+		if err := rows.Scan(&attachment.ID, &attachment.Name, &attachment.ContentType, &attachment.Size, &attachment.Data); err != nil {
+			return nil, fmt.Errorf("failed to scan attachment: %w", err)
+		}
+		found[attachment.ID] = attachment
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orderedAttachments(found, ids)
+}
+
+// SetActiveMessage moves the end of the active branch of a conversation to the given message
+func (s *SQLChatHistoryStorage) SetActiveMessage(ctx context.Context, chatUUID uuid.UUID, messageID int64) error {
+	if messageID != RootMessageID {
+		var exists bool
+		err := s.db.QueryRowContext(ctx,
+			s.dialect.rebind(`SELECT EXISTS (SELECT 1 FROM chat_messages WHERE chat_uuid = ? AND id = ?)`),
+			chatUUID.String(), messageID,
+		).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to get message: %w", err)
+		}
+		if !exists {
+			return fmt.Errorf("%w: %d", ErrMessageNotFound, messageID)
+		}
+	}
+
+	res, err := s.db.ExecContext(ctx,
+		s.dialect.rebind(`UPDATE chats SET active_message_id = ?, updated_at = ? WHERE uuid = ?`),
+		messageID, time.Now().UTC(), chatUUID.String(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set active message: %w", err)
+	}
+	return expectAffected(res, chatUUID)
+}
+
+// GetChat retrieves a conversation by its ChatUUID regardless of its owner
+func (s *SQLChatHistoryStorage) GetChat(ctx context.Context, chatUUID uuid.UUID) (*goai.ChatHistory, error) {
+	chats, err := s.queryChats(ctx, `WHERE uuid = ?`, chatUUID.String())
+	if err != nil {
+		return nil, err
+	}
+	if len(chats) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrChatNotFound, chatUUID)
+	}
+	return chats[0].History(), nil
+}
+
+// GetOwnedChat retrieves a conversation by its ChatUUID if it belongs to owner
+func (s *SQLChatHistoryStorage) GetOwnedChat(ctx context.Context, chatUUID uuid.UUID, owner string) (*Chat, error) {
+	chats, err := s.queryChats(ctx, `WHERE uuid = ? AND owner = ?`, chatUUID.String(), owner)
+	if err != nil {
+		return nil, err
+	}
+	if len(chats) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrChatNotFound, chatUUID)
+	}
+	return &chats[0], nil
+}
+
+// ListChatHistories returns all stored conversations, most recently created first
+func (s *SQLChatHistoryStorage) ListChatHistories(ctx context.Context) ([]goai.ChatHistory, error) {
+	chats, err := s.queryChats(ctx, ``)
+	if err != nil {
+		return nil, err
+	}
+
+	histories := make([]goai.ChatHistory, 0, len(chats))
+	for _, chat := range chats {
+		histories = append(histories, *chat.History())
+	}
+	return histories, nil
+}
+
+// ListOwnedChats returns all conversations belonging to owner, most recently created first
+func (s *SQLChatHistoryStorage) ListOwnedChats(ctx context.Context, owner string) ([]Chat, error) {
+	return s.queryChats(ctx, `WHERE owner = ?`, owner)
+}
+
+// ListOwnedChatsPage returns the page of the conversations belonging to owner selected by query
+func (s *SQLChatHistoryStorage) ListOwnedChatsPage(ctx context.Context, owner string, query ChatListQuery) (ChatPage, error) {
+	where, orderBy, args := pageClauses(owner, query)
+	chats, err := s.queryOrderedChats(ctx, where, orderBy, args...)
+	if err != nil {
+		return ChatPage{}, err
+	}
+
+	page := ChatPage{Chats: chats}
+	if query.Limit > 0 && len(chats) > query.Limit {
+		page.Chats = chats[:query.Limit]
+		page.Next = query.cursor(&page.Chats[query.Limit-1])
+	}
+	return page, nil
+}
+
+// ListOwnedChatSummaries returns the summaries of the page of the conversations
+// belonging to owner selected by query. Only the last message of the active
+// branch of each chat is loaded, cut to the length of its preview.
+func (s *SQLChatHistoryStorage) ListOwnedChatSummaries(ctx context.Context, owner string, query ChatListQuery) (ChatSummaryPage, error) {
+	where, orderBy, args := pageClauses(owner, query)
+	chats, err := s.scanChats(ctx, where, orderBy, args...)
+	if err != nil {
+		return ChatSummaryPage{}, err
+	}
+
+	page := ChatSummaryPage{Summaries: make([]ChatSummary, 0, len(chats))}
+	if query.Limit > 0 && len(chats) > query.Limit {
+		chats = chats[:query.Limit]
+		page.Next = query.cursor(&chats[query.Limit-1])
+	}
+
+	var activeIDs []int64
+	for _, chat := range chats {
+		if chat.ActiveMessageID != RootMessageID {
+			activeIDs = append(activeIDs, chat.ActiveMessageID)
+		}
+	}
+	last, err := s.previewMessages(ctx, activeIDs)
+	if err != nil {
+		return ChatSummaryPage{}, err
+	}
+	depths := map[int64]int{}
+	if len(activeIDs) > 0 {
+		if depths, err = s.messageDepths(ctx, activeIDs); err != nil {
+			return ChatSummaryPage{}, err
+		}
+	}
+
+	for i := range chats {
+		var messageCount int
+		msg, ok := last[chats[i].ActiveMessageID]
+		if ok {
+			messageCount = depths[msg.ID] + 1
+		}
+		page.Summaries = append(page.Summaries, newChatSummary(&chats[i], messageCount, msg))
+	}
+	return page, nil
+}
+
+// previewMessages loads the messages with the given IDs for their preview,
+// with the text cut one character behind MaxMessagePreviewLength, which tells
+// whether it is longer
+func (s *SQLChatHistoryStorage) previewMessages(ctx context.Context, ids []int64) (map[int64]*Message, error) {
+	messages := make(map[int64]*Message, len(ids))
+	if len(ids) == 0 {
+		return messages, nil
+	}
+
+	args := make([]any, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := s.db.QueryContext(ctx,
+		s.dialect.rebind(fmt.Sprintf(`SELECT id, role, SUBSTR(text, 1, %d), generated_at FROM chat_messages WHERE id IN (?`, MaxMessagePreviewLength+1)+strings.Repeat(", ?", len(ids)-1)+`)`),
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var msg Message
+		var role string
+		if err := rows.Scan(&msg.ID, &role, &msg.Text, &msg.GeneratedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan last message: %w", err)
+		}
+		msg.Role = goai.LLMMessageRole(role)
+		messages[msg.ID] = &msg
+	}
+	return messages, rows.Err()
+}
+
+// pageClauses returns the WHERE and ORDER BY clauses selecting the page of
+// the chats of owner given by query, together with their arguments. The
+// limit is one more than asked for, which tells whether there is a next page.
+func pageClauses(owner string, query ChatListQuery) (string, string, []any) {
+	column := string(SortByCreatedAt)
+	if query.SortBy == SortByUpdatedAt {
+		column = string(SortByUpdatedAt)
+	}
+	direction, after := "DESC", "<"
+	if query.Ascending {
+		direction, after = "ASC", ">"
+	}
+
+	conditions := []string{"owner = ?"}
+	args := []any{owner}
+	if !query.From.IsZero() {
+		conditions = append(conditions, column+" >= ?")
+		args = append(args, query.From.UTC())
+	}
+	if !query.To.IsZero() {
+		conditions = append(conditions, column+" <= ?")
+		args = append(args, query.To.UTC())
+	}
+	if query.Archived != nil {
+		conditions = append(conditions, "archived = ?")
+		args = append(args, *query.Archived)
+	}
+	if query.Model != "" {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM chat_messages m WHERE m.chat_uuid = chats.uuid AND m.model = ?)")
+		args = append(args, query.Model)
+	}
+	if len(query.Tools) > 0 {
+		// tool_calls holds JSON, a called tool shows up as its "name" field
+		var matches []string
+		for _, tool := range query.Tools {
+			matches = append(matches, `m.tool_calls LIKE ? ESCAPE '\'`)
+			name, _ := json.Marshal(tool)
+			args = append(args, "%"+escapeLike(`"name":`+string(name))+"%")
+		}
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM chat_messages m WHERE m.chat_uuid = chats.uuid AND ("+strings.Join(matches, " OR ")+"))")
+	}
+	if query.After != nil {
+		conditions = append(conditions, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND uuid %[2]s ?))", column, after))
+		args = append(args, query.After.Time.UTC(), query.After.Time.UTC(), query.After.UUID.String())
+	}
+
+	orderBy := fmt.Sprintf("ORDER BY %[1]s %[2]s, uuid %[2]s", column, direction)
+	if query.Limit > 0 {
+		orderBy += fmt.Sprintf(" LIMIT %d", query.Limit+1)
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), orderBy, args
+}
+
+// SearchOwnedChats returns the messages of the conversations belonging to
+// owner that contain any word of query, ranked by the full-text search of the
+// database: ts_rank on PostgreSQL, bm25 on SQLite
+func (s *SQLChatHistoryStorage) SearchOwnedChats(ctx context.Context, owner string, query string, limit int) ([]SearchHit, error) {
+	terms := uniqueTerms(tokenize(query))
+	hits := []SearchHit{}
+	if len(terms) == 0 {
+		return hits, nil
+	}
+
+	// Terms only hold letters and numbers, nothing the query syntax interprets
+	var search, match string
+	if s.dialect == postgresDialect {
+		search = `SELECT m.id, m.chat_uuid, c.title, m.role, m.text, m.generated_at, ts_rank(m.search_vector, query) AS score
+			FROM chat_messages m JOIN chats c ON c.uuid = m.chat_uuid, to_tsquery('simple', ?) query
+			WHERE m.search_vector @@ query AND c.owner = ?
+			ORDER BY score DESC, m.generated_at DESC LIMIT ?`
+		match = strings.Join(terms, " | ")
+	} else {
+		search = `SELECT m.id, m.chat_uuid, c.title, m.role, m.text, m.generated_at, -bm25(chat_messages_fts) AS score
+			FROM chat_messages_fts JOIN chat_messages m ON m.id = chat_messages_fts.rowid JOIN chats c ON c.uuid = m.chat_uuid
+			WHERE chat_messages_fts MATCH ? AND c.owner = ?
+			ORDER BY score DESC, m.generated_at DESC LIMIT ?`
+		match = `"` + strings.Join(terms, `" OR "`) + `"`
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(search), match, owner, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search chats: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hit SearchHit
+		var chatUUID, role, text string
+		if err := rows.Scan(&hit.MessageID, &chatUUID, &hit.ChatTitle, &role, &text, &hit.GeneratedAt, &hit.Score); err != nil {
+			return nil, fmt.Errorf("failed to scan search hit: %w", err)
+		}
+		hit.ChatUUID, err = uuid.Parse(chatUUID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chat uuid %q: %w", chatUUID, err)
+		}
+		hit.Role = goai.LLMMessageRole(role)
+		hit.Snippet = highlightSnippet(text, terms)
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(hits) == 0 {
+		return hits, nil
+	}
+
+	ids := make([]int64, 0, len(hits))
+	for _, hit := range hits {
+		ids = append(ids, hit.MessageID)
+	}
+	depths, err := s.messageDepths(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range hits {
+		hits[i].MessageIndex = depths[hits[i].MessageID]
+	}
+	return hits, nil
+}
+
+// messageDepths returns the number of ancestors of the messages with the
+// given IDs, which is their position within their branch
+func (s *SQLChatHistoryStorage) messageDepths(ctx context.Context, ids []int64) (map[int64]int, error) {
+	args := make([]any, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := s.db.QueryContext(ctx,
+		s.dialect.rebind(`WITH RECURSIVE ancestors (message_id, parent_id) AS (
+				SELECT id, parent_id FROM chat_messages WHERE id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)
+				UNION ALL
+				SELECT a.message_id, m.parent_id FROM ancestors a JOIN chat_messages m ON m.id = a.parent_id
+			)
+			SELECT message_id, COUNT(*) - 1 FROM ancestors GROUP BY message_id`),
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message positions: %w", err)
+	}
+	defer rows.Close()
+
+	depths := make(map[int64]int, len(ids))
+	for rows.Next() {
+		var messageID int64
+		var depth int
+		if err := rows.Scan(&messageID, &depth); err != nil {
+			return nil, fmt.Errorf("failed to scan message position: %w", err)
+		}
+		depths[messageID] = depth
+	}
+	return depths, rows.Err()
+}
+
+// UpdateOwnedChat changes the title, archived flag and/or settings of a conversation belonging to owner
+func (s *SQLChatHistoryStorage) UpdateOwnedChat(ctx context.Context, chatUUID uuid.UUID, owner string, update ChatUpdate) (*Chat, error) {
+	sets := []string{"updated_at = ?"}
+	args := []any{time.Now().UTC()}
+	if update.Title != nil {
+		sets = append(sets, "title = ?")
+		args = append(args, *update.Title)
+	}
+	if update.Archived != nil {
+		sets = append(sets, "archived = ?")
+		args = append(args, *update.Archived)
+	}
+	if update.Settings != nil {
+		settings, err := encodeSettings(update.Settings)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, "settings = ?")
+		args = append(args, settings)
+	}
+	args = append(args, chatUUID.String(), owner)
+
+	res, err := s.db.ExecContext(ctx,
+		s.dialect.rebind(`UPDATE chats SET `+strings.Join(sets, ", ")+` WHERE uuid = ? AND owner = ?`),
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update chat: %w", err)
+	}
+	if err := expectAffected(res, chatUUID); err != nil {
+		return nil, err
+	}
+
+	return s.GetOwnedChat(ctx, chatUUID, owner)
+}
+
+// SetChatSummary replaces the rolling summary of a conversation if it still
+// covers basedOn messages
+func (s *SQLChatHistoryStorage) SetChatSummary(ctx context.Context, chatUUID uuid.UUID, summary string, summarizedMessages, basedOn int) error {
+	res, err := s.db.ExecContext(ctx,
+		s.dialect.rebind(`UPDATE chats SET summary = ?, summarized_messages = ? WHERE uuid = ? AND summarized_messages = ?`),
+		summary, summarizedMessages, chatUUID.String(), basedOn,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set chat summary: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	var exists int
+	err = s.db.QueryRowContext(ctx, s.dialect.rebind(`SELECT 1 FROM chats WHERE uuid = ?`), chatUUID.String()).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %s", ErrChatNotFound, chatUUID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set chat summary: %w", err)
+	}
+	return fmt.Errorf("%w: %s", ErrSummaryOutdated, chatUUID)
+}
+
+// ResetChatSummary removes the rolling summary of a conversation
+func (s *SQLChatHistoryStorage) ResetChatSummary(ctx context.Context, chatUUID uuid.UUID) error {
+	res, err := s.db.ExecContext(ctx,
+		s.dialect.rebind(`UPDATE chats SET summary = '', summarized_messages = 0 WHERE uuid = ?`),
+		chatUUID.String(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to reset chat summary: %w", err)
+	}
+	return expectAffected(res, chatUUID)
+}
+
+// DeleteOwnedChats removes the given conversations belonging to owner together with their messages
+func (s *SQLChatHistoryStorage) DeleteOwnedChats(ctx context.Context, owner string, uuids []uuid.UUID) ([]uuid.UUID, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	deleted := []uuid.UUID{}
+	for _, chatUUID := range uuids {
+		res, err := tx.ExecContext(ctx,
+			s.dialect.rebind(`DELETE FROM chats WHERE uuid = ? AND owner = ?`),
+			chatUUID.String(), owner,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete chat: %w", err)
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			continue
+		}
+
+		if err := s.deleteMessages(ctx, tx, chatUUID); err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, chatUUID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// DeleteChat removes a conversation and all of its messages by its ChatUUID
+func (s *SQLChatHistoryStorage) DeleteChat(ctx context.Context, chatUUID uuid.UUID) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.deleteMessages(ctx, tx, chatUUID); err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx,
+		s.dialect.rebind(`DELETE FROM chats WHERE uuid = ?`),
+		chatUUID.String(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete chat: %w", err)
+	}
+	if err := expectAffected(res, chatUUID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deleteMessages removes the messages of a conversation, their attachments
+// go along with them
+func (s *SQLChatHistoryStorage) deleteMessages(ctx context.Context, tx *sql.Tx, chatUUID uuid.UUID) error {
+	if _, err := tx.ExecContext(ctx,
+		s.dialect.rebind(`DELETE FROM chat_messages WHERE chat_uuid = ?`),
+		chatUUID.String(),
+	); err != nil {
+		return fmt.Errorf("failed to delete chat messages: %w", err)
+	}
+	return nil
+}
+
+// queryChats loads the chats matching the given WHERE clause together with
+// their messages, most recently created first
+func (s *SQLChatHistoryStorage) queryChats(ctx context.Context, where string, args ...any) ([]Chat, error) {
+	return s.queryOrderedChats(ctx, where, `ORDER BY created_at DESC`, args...)
+}
+
+// queryOrderedChats loads the chats matching the given WHERE clause together
+// with their messages, in the order and up to the limit given by orderBy
+func (s *SQLChatHistoryStorage) queryOrderedChats(ctx context.Context, where string, orderBy string, args ...any) ([]Chat, error) {
+	chats, err := s.scanChats(ctx, where, orderBy, args...)
+	if err != nil || len(chats) == 0 {
+		return chats, err
+	}
+
+	index := make(map[string]int, len(chats))
+	for i, chat := range chats {
+		index[chat.UUID.String()] = i
+	}
+
+	msgRows, err := s.db.QueryContext(ctx,
+		s.dialect.rebind(`SELECT chat_uuid, id, parent_id, role, text, status, provider, model, tool_calls, input_tokens, output_tokens, latency_ms, finish_reason, attachments, settings, generated_at FROM chat_messages
+			WHERE chat_uuid IN (SELECT uuid FROM chats `+where+` `+orderBy+`) ORDER BY chat_uuid, id`),
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chat messages: %w", err)
+	}
+	defer msgRows.Close()
+
+	for msgRows.Next() {
+		var id, role, text, status, provider, model, toolCalls, finishReason, attachments, settings string
+		var messageID, parentID, latencyMs int64
+		var usage MessageUsage
+		var generatedAt time.Time
+		if err := msgRows.Scan(&id, &messageID, &parentID, &role, &text, &status, &provider, &model, &toolCalls,
+			&usage.InputTokens, &usage.OutputTokens, &latencyMs, &finishReason, &attachments, &settings, &generatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+
+		i, ok := index[id]
+		if !ok {
+			// Chat was created after we listed the chats
+			continue
+		}
+
+		message := Message{
+			ChatHistoryMessage: goai.ChatHistoryMessage{
+				LLMMessage:  goai.LLMMessage{Role: goai.LLMMessageRole(role), Text: text},
+				GeneratedAt: generatedAt,
+			},
+			ID:           messageID,
+			ParentID:     parentID,
+			Status:       MessageStatus(status),
+			Provider:     provider,
+			Model:        model,
+			LatencyMs:    latencyMs,
+			FinishReason: FinishReason(finishReason),
+		}
+		if usage != (MessageUsage{}) {
+			message.Usage = &usage
+		}
+		if toolCalls != "" {
+			if err := json.Unmarshal([]byte(toolCalls), &message.ToolCalls); err != nil {
+				return nil, fmt.Errorf("invalid tool calls of message %d: %w", messageID, err)
+			}
+		}
+		if attachments != "" {
+			if err := json.Unmarshal([]byte(attachments), &message.Attachments); err != nil {
+				return nil, fmt.Errorf("invalid attachments of message %d: %w", messageID, err)
+			}
+		}
+		if settings != "" {
+			if err := json.Unmarshal([]byte(settings), &message.Settings); err != nil {
+				return nil, fmt.Errorf("invalid settings of message %d: %w", messageID, err)
+			}
+		}
+
+		chats[i].Messages = append(chats[i].Messages, message)
+	}
+
+	return chats, msgRows.Err()
+}
+
+// scanChats loads the chats matching the given WHERE clause without their
+// messages, in the order and up to the limit given by orderBy
+func (s *SQLChatHistoryStorage) scanChats(ctx context.Context, where string, orderBy string, args ...any) ([]Chat, error) {
+	rows, err := s.db.QueryContext(ctx,
+		s.dialect.rebind(`SELECT uuid, owner, title, archived, summary, summarized_messages, active_message_id, system_prompt, persona, settings, created_at, updated_at FROM chats `+where+` `+orderBy),
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chats: %w", err)
+	}
+	defer rows.Close()
+
+	chats := []Chat{}
+	for rows.Next() {
+		var id, settings string
+		var chat Chat
+		if err := rows.Scan(&id, &chat.Owner, &chat.Title, &chat.Archived, &chat.Summary, &chat.SummarizedMessages, &chat.ActiveMessageID, &chat.SystemPrompt, &chat.Persona, &settings, &chat.CreatedAt, &chat.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan chat: %w", err)
+		}
+		if settings != "" {
+			if err := json.Unmarshal([]byte(settings), &chat.Settings); err != nil {
+				return nil, fmt.Errorf("invalid settings of chat %s: %w", id, err)
+			}
+		}
+
+		chat.UUID, err = uuid.Parse(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chat uuid %q: %w", id, err)
+		}
+		chat.Messages = []Message{}
+		chats = append(chats, chat)
+	}
+	return chats, rows.Err()
+}
+
+// encodeToolCalls serializes tool calls for the tool_calls column, which is
+// empty for messages without tool calls
+func encodeToolCalls(calls []ToolCall) (string, error) {
+	if len(calls) == 0 {
+		return "", nil
+	}
+
+	data, err := json.Marshal(calls)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode tool calls: %w", err)
+	}
+	return string(data), nil
+}
+
+// encodeAttachments serializes attachments for the attachments column, which
+// is empty for messages without attachments
+func encodeAttachments(attachments []Attachment) (string, error) {
+	if len(attachments) == 0 {
+		return "", nil
+	}
+
+	data, err := json.Marshal(attachments)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode attachments: %w", err)
+	}
+	return string(data), nil
+}
+
+// encodeSettings serializes settings for the settings columns, which are empty
+// when there are none
+func encodeSettings(settings *ChatSettings) (string, error) {
+	if settings == nil {
+		return "", nil
+	}
+
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode settings: %w", err)
+	}
+	return string(data), nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern using backslash as escape character
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// expectAffected turns an UPDATE or DELETE that matched no chat into ErrChatNotFound
+func expectAffected(res sql.Result, chatUUID uuid.UUID) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s", ErrChatNotFound, chatUUID)
+	}
+	return nil
+}
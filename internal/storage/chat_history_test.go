@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/shaharia-lab/goai"
+	"github.com/shaharia-lab/mcp-kit/internal/config"
+)
+
+// testStorages returns a memory and a SQLite storage to run a test against
+func testStorages(t *testing.T) map[string]ChatHistoryStorage {
+	t.Helper()
+	sqlite, err := NewSQLChatHistoryStorage(context.Background(), config.ChatHistoryConfig{
+		Driver: "sqlite",
+		DSN:    filepath.Join(t.TempDir(), "chats.db"),
+	})
+	if err != nil {
+		t.Fatalf("NewSQLChatHistoryStorage() error = %v", err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+
+	return map[string]ChatHistoryStorage{
+		"memory": NewMemoryChatHistoryStorage(),
+		"sqlite": sqlite,
+	}
+}
+
+func addMessages(t *testing.T, s ChatHistoryStorage, chatUUID uuid.UUID, texts ...string) []Message {
+	t.Helper()
+	var added []Message
+	for i, text := range texts {
+		role := goai.UserRole
+		if i%2 == 1 {
+			role = goai.AssistantRole
+		}
+		msg, err := s.AddChatMessage(context.Background(), chatUUID, Message{
+			ChatHistoryMessage: goai.ChatHistoryMessage{LLMMessage: goai.LLMMessage{Role: role, Text: text}},
+		})
+		if err != nil {
+			t.Fatalf("AddChatMessage() error = %v", err)
+		}
+		added = append(added, msg)
+	}
+	return added
+}
+
+func TestSetChatSummaryKeepsANewerSummary(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			chat, err := s.CreateOwnedChat(ctx, "alice", ChatOptions{})
+			if err != nil {
+				t.Fatalf("CreateOwnedChat() error = %v", err)
+			}
+
+			if err := s.SetChatSummary(ctx, chat.UUID, "Talked about Tokyo and Paris", 4, 0); err != nil {
+				t.Fatalf("SetChatSummary() error = %v", err)
+			}
+			if err := s.SetChatSummary(ctx, chat.UUID, "Talked about Tokyo", 2, 0); !errors.Is(err, ErrSummaryOutdated) {
+				t.Errorf("SetChatSummary() over a newer summary error = %v, want ErrSummaryOutdated", err)
+			}
+			if err := s.SetChatSummary(ctx, uuid.New(), "Talked about Tokyo", 2, 0); !errors.Is(err, ErrChatNotFound) {
+				t.Errorf("SetChatSummary() of an unknown chat error = %v, want ErrChatNotFound", err)
+			}
+
+			got, err := s.GetOwnedChat(ctx, chat.UUID, "alice")
+			if err != nil {
+				t.Fatalf("GetOwnedChat() error = %v", err)
+			}
+			if got.Summary != "Talked about Tokyo and Paris" || got.SummarizedMessages != 4 {
+				t.Errorf("summary = %q of %d messages, want the newer one of 4", got.Summary, got.SummarizedMessages)
+			}
+
+			if err := s.ResetChatSummary(ctx, chat.UUID); err != nil {
+				t.Fatalf("ResetChatSummary() error = %v", err)
+			}
+			if err := s.SetChatSummary(ctx, chat.UUID, "Talked about Paris", 6, 4); !errors.Is(err, ErrSummaryOutdated) {
+				t.Errorf("SetChatSummary() over a reset summary error = %v, want ErrSummaryOutdated", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shaharia-lab/goai"
+)
+
+// MaxMessagePreviewLength is the number of characters of the last message shown in a chat summary
+const MaxMessagePreviewLength = 200
+
+// ChatSortField is the timestamp lists of chats are sorted by
+type ChatSortField string
+
+const (
+	SortByCreatedAt ChatSortField = "created_at"
+	SortByUpdatedAt ChatSortField = "updated_at"
+)
+
+// ChatListQuery selects a page of the chats of an owner. Zero values don't filter.
+type ChatListQuery struct {
+	SortBy    ChatSortField
+	Ascending bool
+	// From and To bound the sort field, both inclusive
+	From time.Time
+	To   time.Time
+	// Archived keeps only archived or only active chats when set
+	Archived *bool
+	// Model keeps the chats with an answer generated by the model
+	Model string
+	// Tools keeps the chats with an answer that called any of the tools
+	Tools []string
+	// After continues the list behind the chat at the cursor
+	After *ChatCursor
+	Limit int
+}
+
+// ChatCursor is the position of a chat in a list sorted by ChatListQuery.SortBy
+type ChatCursor struct {
+	Time time.Time
+	UUID uuid.UUID
+}
+
+// ChatPage is a page of a list of chats
+type ChatPage struct {
+	Chats []Chat
+	// Next is the position to continue the list from, nil on the last page
+	Next *ChatCursor
+}
+
+// ChatSummary is the lightweight projection of a chat in the chat list, based
+// on its active branch
+type ChatSummary struct {
+	UUID         uuid.UUID       `json:"uuid"`
+	Title        string          `json:"title"`
+	Archived     bool            `json:"archived"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	MessageCount int             `json:"message_count"`
+	LastMessage  *MessagePreview `json:"last_message,omitempty"`
+}
+
+// MessagePreview is the beginning of a message
+type MessagePreview struct {
+	ID          int64               `json:"id"`
+	Role        goai.LLMMessageRole `json:"role"`
+	Preview     string              `json:"preview"`
+	GeneratedAt time.Time           `json:"generated_at"`
+}
+
+// ChatSummaryPage is a page of a list of chat summaries
+type ChatSummaryPage struct {
+	Summaries []ChatSummary
+	// Next is the position to continue the list from, nil on the last page
+	Next *ChatCursor
+}
+
+// newChatSummary returns the summary of a chat whose active branch has
+// messageCount messages ending with last, which is nil for an empty branch
+func newChatSummary(chat *Chat, messageCount int, last *Message) ChatSummary {
+	summary := ChatSummary{
+		UUID:         chat.UUID,
+		Title:        chat.Title,
+		Archived:     chat.Archived,
+		CreatedAt:    chat.CreatedAt,
+		UpdatedAt:    chat.UpdatedAt,
+		MessageCount: messageCount,
+	}
+
+	if last != nil {
+		preview := []rune(last.Text)
+		if len(preview) > MaxMessagePreviewLength {
+			preview = append(preview[:MaxMessagePreviewLength], '…')
+		}
+		summary.LastMessage = &MessagePreview{
+			ID:          last.ID,
+			Role:        last.Role,
+			Preview:     string(preview),
+			GeneratedAt: last.GeneratedAt,
+		}
+	}
+	return summary
+}
+
+// sortTime returns the timestamp of the chat the query sorts by
+func (q ChatListQuery) sortTime(chat *Chat) time.Time {
+	if q.SortBy == SortByUpdatedAt {
+		return chat.UpdatedAt
+	}
+	return chat.CreatedAt
+}
+
+// cursor returns the position of the chat in the list
+func (q ChatListQuery) cursor(chat *Chat) *ChatCursor {
+	return &ChatCursor{Time: q.sortTime(chat), UUID: chat.UUID}
+}
+
+// less tells whether chat a is listed before chat b
+func (q ChatListQuery) less(a, b ChatCursor) bool {
+	if !a.Time.Equal(b.Time) {
+		return a.Time.Before(b.Time) == q.Ascending
+	}
+	if a.UUID == b.UUID {
+		return false
+	}
+	return (a.UUID.String() < b.UUID.String()) == q.Ascending
+}
+
+// matches tells whether the chat passes the filters of the query and comes after its cursor
+func (q ChatListQuery) matches(chat *Chat) bool {
+	t := q.sortTime(chat)
+	if !q.From.IsZero() && t.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && t.After(q.To) {
+		return false
+	}
+	if q.Archived != nil && chat.Archived != *q.Archived {
+		return false
+	}
+	if q.After != nil && !q.less(*q.After, *q.cursor(chat)) {
+		return false
+	}
+	if q.Model == "" && len(q.Tools) == 0 {
+		return true
+	}
+
+	usedModel, usedTool := q.Model == "", len(q.Tools) == 0
+	for _, msg := range chat.Messages {
+		if msg.Model == q.Model {
+			usedModel = true
+		}
+		for _, call := range msg.ToolCalls {
+			if slices.Contains(q.Tools, call.Name) {
+				usedTool = true
+			}
+		}
+	}
+	return usedModel && usedTool
+}
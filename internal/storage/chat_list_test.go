@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/shaharia-lab/goai"
+)
+
+func TestListOwnedChatSummaries(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			empty, err := s.CreateOwnedChat(ctx, "alice", ChatOptions{})
+			if err != nil {
+				t.Fatalf("CreateOwnedChat() error = %v", err)
+			}
+			chat, err := s.CreateOwnedChat(ctx, "alice", ChatOptions{})
+			if err != nil {
+				t.Fatalf("CreateOwnedChat() error = %v", err)
+			}
+			long := strings.Repeat("é", MaxMessagePreviewLength+10)
+			messages := addMessages(t, s, chat.UUID, "first question", "first answer", "second question")
+			// A second branch after the first answer, which becomes the active one
+			if err := s.SetActiveMessage(ctx, chat.UUID, messages[1].ID); err != nil {
+				t.Fatalf("SetActiveMessage() error = %v", err)
+			}
+			edited := addMessages(t, s, chat.UUID, long)
+
+			other, err := s.CreateOwnedChat(ctx, "bob", ChatOptions{})
+			if err != nil {
+				t.Fatalf("CreateOwnedChat() error = %v", err)
+			}
+			addMessages(t, s, other.UUID, "not alice's")
+
+			page, err := s.ListOwnedChatSummaries(ctx, "alice", ChatListQuery{SortBy: SortByUpdatedAt, Limit: 1})
+			if err != nil {
+				t.Fatalf("ListOwnedChatSummaries() error = %v", err)
+			}
+			if len(page.Summaries) != 1 || page.Next == nil {
+				t.Fatalf("page = %+v, want one summary and a next page", page)
+			}
+			summary := page.Summaries[0]
+			if summary.UUID != chat.UUID || summary.MessageCount != 3 {
+				t.Errorf("summary = %+v, want chat %s with the 3 messages of its active branch", summary, chat.UUID)
+			}
+			last := summary.LastMessage
+			if last == nil || last.ID != edited[0].ID || last.Role != goai.UserRole {
+				t.Fatalf("LastMessage = %+v, want the edited question", last)
+			}
+			if want := strings.Repeat("é", MaxMessagePreviewLength) + "…"; last.Preview != want {
+				t.Errorf("Preview = %q, want the first %d characters", last.Preview, MaxMessagePreviewLength)
+			}
+
+			page, err = s.ListOwnedChatSummaries(ctx, "alice", ChatListQuery{SortBy: SortByUpdatedAt, Limit: 1, After: page.Next})
+			if err != nil {
+				t.Fatalf("ListOwnedChatSummaries() error = %v", err)
+			}
+			if len(page.Summaries) != 1 || page.Next != nil {
+				t.Fatalf("page = %+v, want the last summary", page)
+			}
+			if got := page.Summaries[0]; got.UUID != empty.UUID || got.MessageCount != 0 || got.LastMessage != nil {
+				t.Errorf("summary = %+v, want the empty chat %s", got, empty.UUID)
+			}
+		})
+	}
+}
+
+func TestListOwnedChatSummariesMatchesChatPages(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			var uuids []uuid.UUID
+			for range 3 {
+				chat, err := s.CreateOwnedChat(ctx, "alice", ChatOptions{})
+				if err != nil {
+					t.Fatalf("CreateOwnedChat() error = %v", err)
+				}
+				addMessages(t, s, chat.UUID, "question", "answer")
+				uuids = append(uuids, chat.UUID)
+			}
+			archived := true
+			if _, err := s.UpdateOwnedChat(ctx, uuids[1], "alice", ChatUpdate{Archived: &archived}); err != nil {
+				t.Fatalf("UpdateOwnedChat() error = %v", err)
+			}
+
+			active := false
+			query := ChatListQuery{SortBy: SortByCreatedAt, Ascending: true, Archived: &active}
+			chats, err := s.ListOwnedChatsPage(ctx, "alice", query)
+			if err != nil {
+				t.Fatalf("ListOwnedChatsPage() error = %v", err)
+			}
+			summaries, err := s.ListOwnedChatSummaries(ctx, "alice", query)
+			if err != nil {
+				t.Fatalf("ListOwnedChatSummaries() error = %v", err)
+			}
+			if len(summaries.Summaries) != len(chats.Chats) || len(chats.Chats) != 2 {
+				t.Fatalf("summaries = %d, chats = %d, want the 2 chats that aren't archived", len(summaries.Summaries), len(chats.Chats))
+			}
+			for i, summary := range summaries.Summaries {
+				if summary.UUID != chats.Chats[i].UUID || summary.MessageCount != 2 || summary.LastMessage.Preview != "answer" {
+					t.Errorf("summary %d = %+v, want chat %s ending with the answer", i, summary, chats.Chats[i].UUID)
+				}
+			}
+		})
+	}
+}
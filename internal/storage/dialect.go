@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// dialect captures the differences between the SQL databases supported by
+// SQLChatHistoryStorage.
+type dialect struct {
+	// driverName is the name the database/sql driver is registered with
+	driverName string
+	// autoIncrementPK is the column definition for an auto incrementing primary key
+	autoIncrementPK string
+	// timestampType is the column type used to store timestamps
+	timestampType string
+	// blobType is the column type used to store binary data
+	blobType string
+	// numberedPlaceholders is true when the driver expects $1, $2, ... instead of ?
+	numberedPlaceholders bool
+	// advisoryLocks is true when the database has pg_advisory_lock, which keeps
+	// instances started together from migrating at the same time
+	advisoryLocks bool
+}
+
+var (
+	sqliteDialect = dialect{
+		driverName:      "sqlite",
+		autoIncrementPK: "INTEGER PRIMARY KEY AUTOINCREMENT",
+		timestampType:   "TIMESTAMP",
+		blobType:        "BLOB",
+	}
+
+	postgresDialect = dialect{
+		driverName:           "postgres",
+		autoIncrementPK:      "BIGSERIAL PRIMARY KEY",
+		timestampType:        "TIMESTAMPTZ",
+		blobType:             "BYTEA",
+		numberedPlaceholders: true,
+		advisoryLocks:        true,
+	}
+)
+
+func dialectFor(driver string) (dialect, error) {
+	switch strings.ToLower(driver) {
+	case "sqlite", "sqlite3":
+		return sqliteDialect, nil
+	case "postgres", "postgresql":
+		return postgresDialect, nil
+	default:
+		return dialect{}, fmt.Errorf("%w: %s", ErrUnsupportedDriver, driver)
+	}
+}
+
+// rebind rewrites the ? placeholders of a query into the form expected by the driver
+func (d dialect) rebind(query string) string {
+	if !d.numberedPlaceholders {
+		return query
+	}
+
+	var sb strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			sb.WriteByte('$')
+			sb.WriteString(strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
@@ -0,0 +1,11 @@
+package storage
+
+import "errors"
+
+var (
+	ErrChatNotFound       = errors.New("chat not found")
+	ErrMessageNotFound    = errors.New("message not found")
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	ErrUnsupportedDriver  = errors.New("unsupported chat history driver")
+	ErrSummaryOutdated    = errors.New("chat summary changed in the meantime")
+)
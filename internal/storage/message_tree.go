@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+)
+
+// RootMessageID is the parent of the first message of every branch
+const RootMessageID int64 = 0
+
+// Messages of a chat form a tree: editing a question or regenerating an answer
+// adds a sibling to the original message instead of overwriting it. The chat's
+// ActiveMessageID points at the last message of the branch the conversation
+// continues on.
+
+// Message returns the message with the given ID
+func (c *Chat) Message(id int64) (Message, bool) {
+	for _, msg := range c.Messages {
+		if msg.ID == id {
+			return msg, true
+		}
+	}
+	return Message{}, false
+}
+
+// ActiveBranch returns the messages from the first message down to the active message
+func (c *Chat) ActiveBranch() []Message {
+	return c.Branch(c.ActiveMessageID)
+}
+
+// Branch returns the messages from the first message down to the message with the given ID
+func (c *Chat) Branch(id int64) []Message {
+	byID := make(map[int64]Message, len(c.Messages))
+	for _, msg := range c.Messages {
+		byID[msg.ID] = msg
+	}
+
+	var branch []Message
+	for id != RootMessageID {
+		msg, ok := byID[id]
+		if !ok {
+			break
+		}
+		branch = append(branch, msg)
+		id = msg.ParentID
+	}
+
+	for i, j := 0, len(branch)-1; i < j; i, j = i+1, j-1 {
+		branch[i], branch[j] = branch[j], branch[i]
+	}
+	return branch
+}
+
+// Leaves returns the last message of every branch, oldest first
+func (c *Chat) Leaves() []Message {
+	hasChildren := make(map[int64]bool, len(c.Messages))
+	for _, msg := range c.Messages {
+		hasChildren[msg.ParentID] = true
+	}
+
+	var leaves []Message
+	for _, msg := range c.Messages {
+		if !hasChildren[msg.ID] {
+			leaves = append(leaves, msg)
+		}
+	}
+
+	sort.Slice(leaves, func(i, j int) bool {
+		return leaves[i].ID < leaves[j].ID
+	})
+	return leaves
+}
+
+// LatestLeaf returns the most recently added last message of a branch going
+// through the message with the given ID
+func (c *Chat) LatestLeaf(id int64) (Message, error) {
+	if _, ok := c.Message(id); !ok {
+		return Message{}, fmt.Errorf("%w: %d", ErrMessageNotFound, id)
+	}
+
+	var latest Message
+	for _, leaf := range c.Leaves() {
+		for _, msg := range c.Branch(leaf.ID) {
+			if msg.ID == id && leaf.ID > latest.ID {
+				latest = leaf
+			}
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migration is a single, append-only schema change. Migrations are applied in
+// order of their version and are never modified once released.
+type migration struct {
+	version     int
+	description string
+	statements  func(d dialect) []string
+}
+
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create chats and chat_messages tables",
+		statements: func(d dialect) []string {
+			return []string{
+				fmt.Sprintf(`CREATE TABLE IF NOT EXISTS chats (
+					uuid TEXT PRIMARY KEY,
+					created_at %[1]s NOT NULL,
+					updated_at %[1]s NOT NULL
+				)`, d.timestampType),
+				fmt.Sprintf(`CREATE TABLE IF NOT EXISTS chat_messages (
+					id %s,
+					chat_uuid TEXT NOT NULL REFERENCES chats(uuid) ON DELETE CASCADE,
+					role TEXT NOT NULL,
+					text TEXT NOT NULL,
+					generated_at %s NOT NULL
+				)`, d.autoIncrementPK, d.timestampType),
+				`CREATE INDEX IF NOT EXISTS idx_chat_messages_chat_uuid ON chat_messages (chat_uuid, id)`,
+			}
+		},
+	},
+	{
+		version:     2,
+		description: "add owner to chats",
+		statements: func(d dialect) []string {
+			return []string{
+				`ALTER TABLE chats ADD COLUMN owner TEXT NOT NULL DEFAULT ''`,
+				`CREATE INDEX IF NOT EXISTS idx_chats_owner ON chats (owner, created_at)`,
+			}
+		},
+	},
+	{
+		version:     3,
+		description: "add title and archived flag to chats",
+		statements: func(d dialect) []string {
+			return []string{
+				`ALTER TABLE chats ADD COLUMN title TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE chats ADD COLUMN archived BOOLEAN NOT NULL DEFAULT FALSE`,
+			}
+		},
+	},
+	{
+		version:     4,
+		description: "add rolling summary to chats",
+		statements: func(d dialect) []string {
+			return []string{
+				`ALTER TABLE chats ADD COLUMN summary TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE chats ADD COLUMN summarized_messages INTEGER NOT NULL DEFAULT 0`,
+			}
+		},
+	},
+	{
+		version:     5,
+		description: "add status to chat_messages",
+		statements: func(d dialect) []string {
+			return []string{
+				`ALTER TABLE chat_messages ADD COLUMN status TEXT NOT NULL DEFAULT ''`,
+			}
+		},
+	},
+	{
+		version:     6,
+		description: "turn chat messages into a tree of branches",
+		statements: func(d dialect) []string {
+			return []string{
+				`ALTER TABLE chat_messages ADD COLUMN parent_id BIGINT NOT NULL DEFAULT 0`,
+				`ALTER TABLE chats ADD COLUMN active_message_id BIGINT NOT NULL DEFAULT 0`,
+				// Existing conversations become a single branch
+				`UPDATE chat_messages SET parent_id = COALESCE((
+					SELECT MAX(p.id) FROM chat_messages p
+					WHERE p.chat_uuid = chat_messages.chat_uuid AND p.id < chat_messages.id
+				), 0)`,
+				`UPDATE chats SET active_message_id = COALESCE((
+					SELECT MAX(m.id) FROM chat_messages m WHERE m.chat_uuid = chats.uuid
+				), 0)`,
+			}
+		},
+	},
+	{
+		version:     7,
+		description: "add tool calls and token usage to chat_messages",
+		statements: func(d dialect) []string {
+			return []string{
+				`ALTER TABLE chat_messages ADD COLUMN tool_calls TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE chat_messages ADD COLUMN input_tokens INTEGER NOT NULL DEFAULT 0`,
+				`ALTER TABLE chat_messages ADD COLUMN output_tokens INTEGER NOT NULL DEFAULT 0`,
+			}
+		},
+	},
+	{
+		version:     8,
+		description: "add provider and model to chat_messages",
+		statements: func(d dialect) []string {
+			return []string{
+				`ALTER TABLE chat_messages ADD COLUMN provider TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE chat_messages ADD COLUMN model TEXT NOT NULL DEFAULT ''`,
+				`CREATE INDEX IF NOT EXISTS idx_chats_owner_updated_at ON chats (owner, updated_at)`,
+			}
+		},
+	},
+	{
+		version:     9,
+		description: "add latency and finish reason to chat_messages",
+		statements: func(d dialect) []string {
+			return []string{
+				`ALTER TABLE chat_messages ADD COLUMN latency_ms INTEGER NOT NULL DEFAULT 0`,
+				`ALTER TABLE chat_messages ADD COLUMN finish_reason TEXT NOT NULL DEFAULT ''`,
+			}
+		},
+	},
+	{
+		version:     10,
+		description: "add attachments to chat_messages and their data to chat_attachments",
+		statements: func(d dialect) []string {
+			return []string{
+				`ALTER TABLE chat_messages ADD COLUMN attachments TEXT NOT NULL DEFAULT ''`,
+				fmt.Sprintf(`CREATE TABLE IF NOT EXISTS chat_attachments (
+					id %s,
+					chat_uuid TEXT NOT NULL REFERENCES chats(uuid) ON DELETE CASCADE,
+					message_id BIGINT NOT NULL REFERENCES chat_messages(id) ON DELETE CASCADE,
+					name TEXT NOT NULL,
+					content_type TEXT NOT NULL,
+					size INTEGER NOT NULL,
+					data %s NOT NULL,
+					created_at %s NOT NULL
+				)`, d.autoIncrementPK, d.blobType, d.timestampType),
+				`CREATE INDEX IF NOT EXISTS idx_chat_attachments_chat_uuid ON chat_attachments (chat_uuid, id)`,
+				`CREATE INDEX IF NOT EXISTS idx_chat_attachments_message_id ON chat_attachments (message_id)`,
+			}
+		},
+	},
+	{
+		version:     11,
+		description: "add system prompt and persona to chats",
+		statements: func(d dialect) []string {
+			return []string{
+				`ALTER TABLE chats ADD COLUMN system_prompt TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE chats ADD COLUMN persona TEXT NOT NULL DEFAULT ''`,
+			}
+		},
+	},
+	{
+		version:     12,
+		description: "add settings to chats and chat_messages",
+		statements: func(d dialect) []string {
+			return []string{
+				`ALTER TABLE chats ADD COLUMN settings TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE chat_messages ADD COLUMN settings TEXT NOT NULL DEFAULT ''`,
+			}
+		},
+	},
+	{
+		version:     13,
+		description: "add full-text search of chat_messages",
+		statements: func(d dialect) []string {
+			if d == postgresDialect {
+				return []string{
+					`ALTER TABLE chat_messages ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (to_tsvector('simple', text)) STORED`,
+					`CREATE INDEX IF NOT EXISTS idx_chat_messages_search_vector ON chat_messages USING GIN (search_vector)`,
+				}
+			}
+			// An external content FTS5 table kept in sync by triggers. Diacritics
+			// are kept, like the words highlighted in the snippets.
+			return []string{
+				`CREATE VIRTUAL TABLE IF NOT EXISTS chat_messages_fts USING fts5(
+					text, content='chat_messages', content_rowid='id', tokenize='unicode61 remove_diacritics 0'
+				)`,
+				`CREATE TRIGGER IF NOT EXISTS chat_messages_fts_insert AFTER INSERT ON chat_messages BEGIN
+					INSERT INTO chat_messages_fts (rowid, text) VALUES (new.id, new.text);
+				END`,
+				`CREATE TRIGGER IF NOT EXISTS chat_messages_fts_delete AFTER DELETE ON chat_messages BEGIN
+					INSERT INTO chat_messages_fts (chat_messages_fts, rowid, text) VALUES ('delete', old.id, old.text);
+				END`,
+				`CREATE TRIGGER IF NOT EXISTS chat_messages_fts_update AFTER UPDATE OF text ON chat_messages BEGIN
+					INSERT INTO chat_messages_fts (chat_messages_fts, rowid, text) VALUES ('delete', old.id, old.text);
+					INSERT INTO chat_messages_fts (rowid, text) VALUES (new.id, new.text);
+				END`,
+				`INSERT INTO chat_messages_fts (chat_messages_fts) VALUES ('rebuild')`,
+			}
+		},
+	},
+}
+
+// migrationLockKey is the key of the advisory lock held while migrating
+const migrationLockKey = 7263547102
+
+// migrate brings the database schema up to date. Each migration runs in its own
+// transaction together with the bookkeeping row in schema_migrations. Where the
+// database has advisory locks, the whole migration holds one, so instances
+// started at the same time migrate one after the other.
+func migrate(ctx context.Context, db *sql.DB, d dialect) error {
+	// Advisory locks belong to a session, so everything runs on a single connection
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get a connection: %w", err)
+	}
+	defer conn.Close()
+
+	if d.advisoryLocks {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+			return fmt.Errorf("failed to take the migration lock: %w", err)
+		}
+		// Unlocking only fails on a broken connection, whose session ends with the lock
+		defer conn.ExecContext(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+	}
+
+	_, err = conn.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at %s NOT NULL
+	)`, d.timestampType))
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	var current int
+	if err := conn.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		if err := applyMigration(ctx, conn, d, m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.description, err)
+		}
+	}
+
+	return nil
+}
+
+func applyMigration(ctx context.Context, conn *sql.Conn, d dialect, m migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range m.statements(d) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx,
+		d.rebind(`INSERT INTO schema_migrations (version, description, applied_at) VALUES (?, ?, ?)`),
+		m.version, m.description, time.Now().UTC(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"html"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/shaharia-lab/goai"
+)
+
+// snippetRadius is the number of characters shown around the first match of a snippet
+const snippetRadius = 80
+
+// SearchHit is a message matching a search query
+type SearchHit struct {
+	ChatUUID  uuid.UUID `json:"chat_uuid"`
+	ChatTitle string    `json:"chat_title"`
+	MessageID int64     `json:"message_id"`
+	// MessageIndex is the position of the message within its branch, which
+	// is its index in the messages of the chat while the branch is active
+	MessageIndex int                 `json:"message_index"`
+	Role         goai.LLMMessageRole `json:"role"`
+	// Snippet is an HTML escaped excerpt of the message with the matched
+	// words wrapped in <mark> tags
+	Snippet string `json:"snippet"`
+	// Score ranks the hits of a search, higher is better. Its scale depends
+	// on the storage.
+	Score       float64   `json:"score"`
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+// ChatSearcher searches the message text of the chats of an owner
+type ChatSearcher interface {
+	// SearchOwnedChats returns up to limit messages of the conversations
+	// belonging to owner that contain any word of query, best matches first
+	SearchOwnedChats(ctx context.Context, owner string, query string, limit int) ([]SearchHit, error)
+}
+
+// tokenize splits text into lower case words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func uniqueTerms(terms []string) []string {
+	seen := make(map[string]bool, len(terms))
+	unique := terms[:0]
+	for _, term := range terms {
+		if !seen[term] {
+			seen[term] = true
+			unique = append(unique, term)
+		}
+	}
+	return unique
+}
+
+// highlightSnippet cuts the text around the first matched word and marks all
+// matched words in the excerpt
+func highlightSnippet(text string, terms []string) string {
+	matched := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		matched[term] = true
+	}
+
+	runes := []rune(text)
+	type span struct{ start, end int }
+	var matches []span
+	for start := 0; start < len(runes); {
+		if !unicode.IsLetter(runes[start]) && !unicode.IsNumber(runes[start]) {
+			start++
+			continue
+		}
+		end := start
+		for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsNumber(runes[end])) {
+			end++
+		}
+		if matched[strings.ToLower(string(runes[start:end]))] {
+			matches = append(matches, span{start, end})
+		}
+		start = end
+	}
+
+	from, to := 0, min(len(runes), 2*snippetRadius)
+	if len(matches) > 0 {
+		from = max(0, matches[0].start-snippetRadius)
+		to = min(len(runes), matches[0].end+snippetRadius)
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, m := range matches {
+		if m.start < from || m.end > to {
+			continue
+		}
+		b.WriteString(html.EscapeString(string(runes[pos:m.start])))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(string(runes[m.start:m.end])))
+		b.WriteString("</mark>")
+		pos = m.end
+	}
+	b.WriteString(html.EscapeString(string(runes[pos:to])))
+	if to < len(runes) {
+		b.WriteString("…")
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/shaharia-lab/goai"
+)
+
+func TestSearchOwnedChats(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			chat, err := s.CreateOwnedChat(ctx, "alice", ChatOptions{})
+			if err != nil {
+				t.Fatalf("CreateOwnedChat() error = %v", err)
+			}
+			title := "Deployments"
+			if _, err := s.UpdateOwnedChat(ctx, chat.UUID, "alice", ChatUpdate{Title: &title}); err != nil {
+				t.Fatalf("UpdateOwnedChat() error = %v", err)
+			}
+			messages := addMessages(t, s, chat.UUID,
+				"How do I deploy to Kubernetes?",
+				"Use a <Deployment> and kubectl apply.",
+				"And rolling back a deploy?",
+			)
+
+			other, err := s.CreateOwnedChat(ctx, "bob", ChatOptions{})
+			if err != nil {
+				t.Fatalf("CreateOwnedChat() error = %v", err)
+			}
+			addMessages(t, s, other.UUID, "deploy deploy deploy")
+
+			hits, err := s.SearchOwnedChats(ctx, "alice", "DEPLOY kubernetes", 10)
+			if err != nil {
+				t.Fatalf("SearchOwnedChats() error = %v", err)
+			}
+			if len(hits) != 2 {
+				t.Fatalf("hits = %+v, want the 2 messages of alice containing deploy or kubernetes", hits)
+			}
+
+			// Both words match the first question
+			first := hits[0]
+			if first.MessageID != messages[0].ID || first.MessageIndex != 0 || first.Role != goai.UserRole {
+				t.Errorf("first hit = %+v, want the first question", first)
+			}
+			if first.ChatUUID != chat.UUID || first.ChatTitle != "Deployments" {
+				t.Errorf("first hit chat = %s %q, want %s %q", first.ChatUUID, first.ChatTitle, chat.UUID, "Deployments")
+			}
+			if want := "How do I <mark>deploy</mark> to <mark>Kubernetes</mark>?"; first.Snippet != want {
+				t.Errorf("Snippet = %q, want %q", first.Snippet, want)
+			}
+			if hits[1].MessageID != messages[2].ID || hits[1].MessageIndex != 2 {
+				t.Errorf("second hit = %+v, want the third message of the branch", hits[1])
+			}
+			if hits[0].Score <= hits[1].Score {
+				t.Errorf("scores = %v, %v, want the best match first", hits[0].Score, hits[1].Score)
+			}
+
+			hits, err = s.SearchOwnedChats(ctx, "alice", "deployment", 10)
+			if err != nil {
+				t.Fatalf("SearchOwnedChats() error = %v", err)
+			}
+			if len(hits) != 1 || hits[0].Snippet != "Use a &lt;<mark>Deployment</mark>&gt; and kubectl apply." {
+				t.Errorf("hits = %+v, want the answer with an escaped snippet", hits)
+			}
+
+			hits, err = s.SearchOwnedChats(ctx, "alice", "deploy", 1)
+			if err != nil {
+				t.Fatalf("SearchOwnedChats() error = %v", err)
+			}
+			if len(hits) != 1 {
+				t.Errorf("hits = %d, want the limit of 1", len(hits))
+			}
+		})
+	}
+}
+
+func TestSearchOwnedChatsIgnoresDeletedChatsAndQuerySyntax(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			chat, err := s.CreateOwnedChat(ctx, "alice", ChatOptions{})
+			if err != nil {
+				t.Fatalf("CreateOwnedChat() error = %v", err)
+			}
+			addMessages(t, s, chat.UUID, `say "hello" OR NOT world*`)
+
+			for _, query := range []string{`"hello`, `world*`, `NOT:hello`, `"`, `***`} {
+				if _, err := s.SearchOwnedChats(ctx, "alice", query, 10); err != nil {
+					t.Errorf("SearchOwnedChats(%q) error = %v", query, err)
+				}
+			}
+
+			if _, err := s.DeleteOwnedChats(ctx, "alice", []uuid.UUID{chat.UUID}); err != nil {
+				t.Fatalf("DeleteOwnedChats() error = %v", err)
+			}
+			hits, err := s.SearchOwnedChats(ctx, "alice", "hello", 10)
+			if err != nil {
+				t.Fatalf("SearchOwnedChats() error = %v", err)
+			}
+			if len(hits) != 0 {
+				t.Errorf("hits = %+v, want none once the chat is deleted", hits)
+			}
+		})
+	}
+}